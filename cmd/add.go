@@ -2,15 +2,19 @@
 package cmd
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"syscall"
 
 	"passmanager/internal/config"
 	"passmanager/internal/crypto"
 	"passmanager/internal/database"
 	"passmanager/internal/models"
+	"passmanager/internal/strength"
+	"passmanager/internal/vault"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
@@ -22,9 +26,11 @@ var (
 	addPassword string
 	addURL      string
 	addNotes    string
+	addTOTP     string
 	addCategory string
 	addGenerate bool
 	addLength   int
+	addPolicy   policyFlags
 )
 
 var addCmd = &cobra.Command{
@@ -39,37 +45,64 @@ func init() {
 	addCmd.Flags().StringVarP(&addPassword, "password", "p", "", "Password (will prompt if not provided)")
 	addCmd.Flags().StringVarP(&addURL, "url", "l", "", "Website URL")
 	addCmd.Flags().StringVarP(&addNotes, "notes", "n", "", "Additional notes")
+	addCmd.Flags().StringVar(&addTOTP, "totp", "", "TOTP secret (base32) or otpauth:// URI")
 	addCmd.Flags().StringVarP(&addCategory, "category", "c", "general", "Category")
 	addCmd.Flags().BoolVarP(&addGenerate, "generate", "g", false, "Generate a random password")
-	addCmd.Flags().IntVar(&addLength, "length", 20, "Generated password length")
+	addCmd.Flags().IntVar(&addLength, "length", crypto.DefaultPolicy().Length, "Generated password length")
+	addPolicy.register(addCmd, "")
 	addCmd.MarkFlagRequired("title")
 }
 
 func runAdd(cmd *cobra.Command, args []string) {
-	cfg, client, cryptoSvc := authenticate()
+	ctx := cmd.Context()
+
+	// Checked before unlocking so a typo doesn't cost a password prompt
+	var totp *crypto.TOTP
+	if addTOTP != "" {
+		var err error
+		if totp, err = crypto.ParseTOTP(addTOTP); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	_, client, cryptoSvc, vaultConfig := authenticate(ctx)
 	defer cryptoSvc.SecureClear()
 
 	// Handle password
 	var password string
 	if addGenerate {
 		var err error
-		password, err = crypto.GeneratePassword(addLength, true)
+		password, err = crypto.GeneratePassword(addPolicy.policy(cmd, "length", addLength))
 		if err != nil {
 			fmt.Printf("❌ Failed to generate password: %v\n", err)
 			os.Exit(1)
 		}
 		fmt.Printf("🔑 Generated password: %s\n", password)
-	} else if addPassword == "" {
-		fmt.Print("Password: ")
-		passBytes, _ := term.ReadPassword(int(syscall.Stdin))
-		password = string(passBytes)
-		fmt.Println()
 	} else {
-		password = addPassword
+		if addPassword == "" {
+			fmt.Print("Password: ")
+			passBytes, _ := term.ReadPassword(int(syscall.Stdin))
+			password = string(passBytes)
+			fmt.Println()
+		} else {
+			password = addPassword
+		}
+
+		if result := strength.Estimate(password, addTitle, addUsername, addURL); result.Score < strength.ScoreStrong {
+			printWeakPassword("Password is "+strings.ToLower(result.Label()), result)
+		}
+	}
+
+	// The ID is chosen up front because the encrypted fields are bound to it
+	id, err := database.NewRecordID()
+	if err != nil {
+		fmt.Printf("❌ Failed to create credential ID: %v\n", err)
+		os.Exit(1)
 	}
 
 	// Encrypt password
-	encryptedPassword, err := cryptoSvc.Encrypt(password)
+	encryptedPassword, err := vault.Seal(cryptoSvc, id, vault.FieldPassword, password)
 	if err != nil {
 		fmt.Printf("❌ Failed to encrypt password: %v\n", err)
 		os.Exit(1)
@@ -78,72 +111,245 @@ func runAdd(cmd *cobra.Command, args []string) {
 	// Encrypt notes if provided
 	encryptedNotes := ""
 	if addNotes != "" {
-		encryptedNotes, _ = cryptoSvc.Encrypt(addNotes)
+		encryptedNotes, _ = vault.Seal(cryptoSvc, id, vault.FieldNotes, addNotes)
+	}
+
+	encryptedTOTP := ""
+	if totp != nil {
+		if encryptedTOTP, err = vault.Seal(cryptoSvc, id, vault.FieldTOTP, totp.URI()); err != nil {
+			fmt.Printf("❌ Failed to encrypt TOTP secret: %v\n", err)
+			os.Exit(1)
+		}
 	}
 
 	// Create credential
 	cred := models.Credential{
+		ID:                id,
 		Title:             addTitle,
 		Username:          addUsername,
 		EncryptedPassword: encryptedPassword,
 		URL:               addURL,
 		Notes:             encryptedNotes,
+		TOTP:              encryptedTOTP,
 		Category:          addCategory,
 	}
 
-	_ = cfg // Use config if needed
-	created, err := client.CreateCredential(cred)
+	if vaultConfig.EncryptMetadata {
+		if err := vault.SealMetadata(cryptoSvc, &cred); err != nil {
+			fmt.Printf("❌ Failed to encrypt credential details: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	created, err := client.CreateCredential(ctx, cred)
 	if err != nil {
-		fmt.Printf("❌ Failed to save credential: %v\n", err)
-		os.Exit(1)
+		fail("Failed to save credential", err)
 	}
 
 	fmt.Printf("✅ Credential saved successfully (ID: %s)\n", created.ID)
 }
 
-func authenticate() (*config.Config, *database.PocketBaseClient, *crypto.CryptoService) {
+// authenticate unlocks the vault and returns the app config, the backend,
+// the vault key and the vault config as it stands after any upgrade.
+func authenticate(ctx context.Context) (*config.Config, database.VaultStore, *crypto.CryptoService, models.VaultConfig) {
+	cfg, store, secret, cryptoSvc, vaultConfig := unlockVault(ctx)
+	secret.Destroy()
+	return cfg, store, cryptoSvc, vaultConfig
+}
+
+// unlockVault is authenticate that also returns the secret the vault was
+// unlocked with, for commands that re-wrap the vault key. The caller must
+// Destroy it.
+func unlockVault(ctx context.Context) (*config.Config, database.VaultStore, *crypto.SecureBuffer, *crypto.CryptoService, models.VaultConfig) {
 	cfg, err := config.Load()
 	if err != nil {
 		fmt.Println("❌ Vault not initialized. Run 'passmanager init' first.")
 		os.Exit(1)
 	}
 
+	if keyFilePath != "" {
+		cfg.KeyFile = keyFilePath
+	}
+
+	// secret is the master password, followed by the key file's hash if the
+	// vault needs one
+	var (
+		store   database.VaultStore
+		secret  *crypto.SecureBuffer
+		passKey *crypto.DerivedKey
+	)
+
+	switch cfg.Backend {
+	case config.BackendLocal:
+		needsKeyFile, err := database.LocalFileNeedsKeyFile(config.GetVaultPath())
+		if err != nil {
+			fail("Failed to open vault", err)
+		}
+
+		// The vault file itself is encrypted with the master password
+		secret = readUnlockSecret(cfg, needsKeyFile)
+
+		localStore, key, err := database.OpenLocalFileStore(config.GetVaultPath(), secret.Bytes())
+		if err != nil {
+			fail("Failed to open vault", err)
+		}
+		store, passKey = localStore, key
+	case config.BackendSQLite:
+		sqliteStore, err := database.OpenSQLiteStore(config.GetSQLitePath())
+		if err != nil {
+			fail("Failed to open database", err)
+		}
+		store = sqliteStore
+	default:
+		store = connectPocketBase(ctx, cfg)
+	}
+
+	// Get vault config
+	vaultConfig, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		fail("Failed to get vault config", err)
+	}
+
+	// Get master password
+	if secret == nil {
+		secret = readUnlockSecret(cfg, vaultConfig.KeyFile)
+	}
+
+	// Verify master password and unwrap the vault key
+	cryptoSvc, err := vault.UnlockDerived(secret.Bytes(), passKey, *vaultConfig)
+	passKey.Destroy()
+	if errors.Is(err, vault.ErrInvalidPassword) {
+		if vaultConfig.KeyFile {
+			fmt.Println("❌ Invalid master password or key file")
+		} else {
+			fmt.Println("❌ Invalid master password")
+		}
+		os.Exit(exitAuth)
+	}
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(exitError)
+	}
+
+	// The server's config could have been rolled back to accept older,
+	// unbound ciphertexts
+	lowered, err := vault.PinCipherVersion(vaultConfig, cryptoSvc)
+	if err != nil {
+		fmt.Printf("❌ Failed to check the vault's cipher version: %v\n", err)
+		os.Exit(exitError)
+	}
+	if lowered {
+		fmt.Println("⚠️  The vault config claims an older encryption format than this device has seen; older records are refused")
+	}
+
+	// Records may be under two keys until an interrupted key rotation is
+	// finished, which needs the interactive app
+	if journal, _ := vault.LoadJournal(); journal != nil && journal.VaultID == vaultConfig.ID {
+		if !journal.Committed(*vaultConfig) {
+			fmt.Println("❌ A vault key rotation was interrupted. Run passmanager without arguments to finish or roll it back.")
+			os.Exit(exitError)
+		}
+		vault.RemoveJournal()
+	} else if vaultConfig.Rotation != "" {
+		fmt.Println("⚠️  Another device is rotating the vault key; some credentials may not open until it finishes")
+	}
+
+	// Best effort: the key already in use becomes the wrapped vault key and
+	// old ciphertexts are rewritten. An older vault keeps working as it is
+	// if this fails, and it is tried again next time.
+	upgraded := *vaultConfig
+	if vault.NeedsUpgrade(upgraded) {
+		if next, err := vault.ChangePassword(ctx, store, upgraded, cryptoSvc, secret.Bytes()); err == nil {
+			upgraded = next
+		}
+	}
+	if vault.NeedsRecordUpgrade(upgraded) {
+		if next, _, err := vault.UpgradeRecords(ctx, store, cryptoSvc, upgraded, nil); err == nil {
+			upgraded = next
+		}
+	}
+
+	return cfg, store, secret, cryptoSvc, upgraded
+}
+
+// connectPocketBase signs in to the configured server, asking for the admin
+// password.
+func connectPocketBase(ctx context.Context, cfg *config.Config) *database.PocketBaseClient {
 	// Get admin password
-	fmt.Print("Admin Password: ")
-	adminPassBytes, _ := term.ReadPassword(int(syscall.Stdin))
-	adminPass := string(adminPassBytes)
-	fmt.Println()
+	adminPass := readLine("Admin Password: ")
 
 	// Connect to PocketBase
 	client := database.NewPocketBaseClient(cfg.PocketBaseURL)
-	if err := client.Authenticate(cfg.AdminEmail, adminPass); err != nil {
-		fmt.Printf("❌ Authentication failed: %v\n", err)
-		os.Exit(1)
+	policy := database.DefaultRetryPolicy()
+	policy.MaxRetries = cfg.Settings.MaxRetries
+	client.SetRetryPolicy(policy)
+	client.SetReauthFunc(func(ctx context.Context, identity string) (string, error) {
+		fmt.Printf("⚠️  Server session expired, signing in again as %s\n", identity)
+		return readLine("Admin Password: "), nil
+	})
+
+	if err := client.Authenticate(ctx, cfg.AdminEmail, adminPass); err != nil {
+		fail("Authentication failed", err)
 	}
 
-	// Get vault config
-	vaultConfig, err := client.GetVaultConfig()
+	// Best effort: brings older deployments up to date with new fields,
+	// but only works for superusers
+	client.EnsureSchema(ctx)
+
+	return client
+}
+
+// readUnlockSecret reads the master password and adds the key file to it if
+// the vault needs one. It exits if the key file is not there.
+func readUnlockSecret(cfg *config.Config, needsKeyFile bool) *crypto.SecureBuffer {
+	if needsKeyFile && cfg.KeyFile == "" {
+		fmt.Println("❌ This vault requires a key file. Pass --keyfile <path>.")
+		os.Exit(exitAuth)
+	}
+	if needsKeyFile {
+		// Checked before asking for the password, which would be wasted
+		if _, err := os.Stat(cfg.KeyFile); errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("❌ Key file not found: %s\n", cfg.KeyFile)
+			fmt.Println("   This vault requires it. Pass --keyfile <path> if it has moved.")
+			os.Exit(exitAuth)
+		}
+	}
+
+	password := readPassword("Master Password: ")
+	defer password.Destroy()
+
+	keyFile := ""
+	if needsKeyFile {
+		keyFile = cfg.KeyFile
+	}
+	secret, err := vault.WithKeyFile(password.Bytes(), keyFile)
 	if err != nil {
-		fmt.Printf("❌ Failed to get vault config: %v\n", err)
-		os.Exit(1)
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(exitAuth)
 	}
+	return secret
+}
 
-	// Get master password
-	fmt.Print("Master Password: ")
-	masterPassBytes, _ := term.ReadPassword(int(syscall.Stdin))
-	masterPass := string(masterPassBytes)
+// readPassword reads a secret into locked memory. The caller must Destroy
+// it.
+func readPassword(prompt string) *crypto.SecureBuffer {
+	fmt.Print(prompt)
+	passBytes, _ := term.ReadPassword(int(syscall.Stdin))
 	fmt.Println()
 
-	// Verify master password
-	salt, _ := base64.StdEncoding.DecodeString(vaultConfig.Salt)
-	passwordHash := crypto.HashMasterPassword(masterPass, salt)
-
-	if passwordHash != vaultConfig.PasswordHash {
-		fmt.Println("❌ Invalid master password")
+	password, err := crypto.SecureBytes(passBytes)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
 		os.Exit(1)
 	}
+	return password
+}
 
-	cryptoSvc := crypto.NewCryptoService(masterPass, salt)
-
-	return cfg, client, cryptoSvc
+// readLine reads input without echoing it, for secrets such as the server
+// password that end up in a request anyway.
+func readLine(prompt string) string {
+	fmt.Print(prompt)
+	passBytes, _ := term.ReadPassword(int(syscall.Stdin))
+	fmt.Println()
+	return string(passBytes)
 }
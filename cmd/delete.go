@@ -24,7 +24,9 @@ func init() {
 }
 
 func runDelete(cmd *cobra.Command, args []string) {
-	_, client, cryptoSvc := authenticate()
+	ctx := cmd.Context()
+
+	_, client, cryptoSvc, _ := authenticate(ctx)
 	defer cryptoSvc.SecureClear()
 
 	// Confirm deletion
@@ -38,9 +40,8 @@ func runDelete(cmd *cobra.Command, args []string) {
 		return
 	}
 
-	if err := client.DeleteCredential(deleteID); err != nil {
-		fmt.Printf("❌ Failed to delete credential: %v\n", err)
-		os.Exit(1)
+	if err := client.DeleteCredential(ctx, deleteID); err != nil {
+		fail("Failed to delete credential", err)
 	}
 
 	fmt.Println("✅ Credential deleted successfully")
@@ -0,0 +1,46 @@
+// cmd/errors.go
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"passmanager/internal/database"
+)
+
+// Exit codes, so scripts can tell failures apart.
+const (
+	exitError     = 1
+	exitAuth      = 3
+	exitNotFound  = 4
+	exitConflict  = 5
+	exitInvalid   = 6
+	exitCancelled = 130
+)
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return exitCancelled
+	case errors.Is(err, database.ErrInvalidPassphrase),
+		errors.Is(err, database.ErrUnauthorized),
+		errors.Is(err, database.ErrForbidden):
+		return exitAuth
+	case errors.Is(err, database.ErrNotFound),
+		errors.Is(err, database.ErrNotInitialized):
+		return exitNotFound
+	case errors.Is(err, database.ErrConflict):
+		return exitConflict
+	case errors.Is(err, database.ErrValidation):
+		return exitInvalid
+	}
+	return exitError
+}
+
+// fail reports a backend error and exits with the matching code.
+func fail(msg string, err error) {
+	fmt.Printf("❌ %s: %s\n", msg, database.Describe(err))
+	os.Exit(exitCode(err))
+}
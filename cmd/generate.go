@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"passmanager/internal/config"
 	"passmanager/internal/crypto"
 
 	"github.com/atotto/clipboard"
@@ -12,31 +13,62 @@ import (
 )
 
 var (
-	genLength  int
-	genSymbols bool
-	genCopy    bool
+	genLength     int
+	genCopy       bool
+	genPolicy     policyFlags
+	genPassphrase bool
+	genWords      int
+	genSeparator  string
+	genCapitalize bool
+	genDigit      bool
 )
 
 var generateCmd = &cobra.Command{
 	Use:   "generate",
-	Short: "Generate a secure password",
+	Short: "Generate a secure password or passphrase",
 	Run:   runGenerate,
 }
 
 func init() {
-	generateCmd.Flags().IntVarP(&genLength, "length", "l", 20, "Password length")
-	generateCmd.Flags().BoolVarP(&genSymbols, "symbols", "s", true, "Include symbols")
+	generateCmd.Flags().IntVarP(&genLength, "length", "l", crypto.DefaultPolicy().Length, "Password length")
 	generateCmd.Flags().BoolVarP(&genCopy, "copy", "c", false, "Copy to clipboard")
+	genPolicy.register(generateCmd, "s")
+
+	def := crypto.DefaultPassphrasePolicy()
+	generateCmd.Flags().BoolVar(&genPassphrase, "passphrase", false, "Generate a passphrase of random words instead")
+	generateCmd.Flags().IntVarP(&genWords, "words", "w", def.Words, "Number of words in a passphrase")
+	generateCmd.Flags().StringVar(&genSeparator, "separator", def.Separator, "Separator between passphrase words")
+	generateCmd.Flags().BoolVar(&genCapitalize, "capitalize", def.Capitalize, "Capitalize passphrase words")
+	generateCmd.Flags().BoolVar(&genDigit, "digit", def.AddDigit, "Add a random digit to a passphrase")
 }
 
 func runGenerate(cmd *cobra.Command, args []string) {
-	password, err := crypto.GeneratePassword(genLength, genSymbols)
-	if err != nil {
-		fmt.Printf("❌ Failed to generate password: %v\n", err)
-		os.Exit(1)
-	}
+	var password string
+	if genPassphrase {
+		policy := crypto.PassphrasePolicy{
+			Words:      genWords,
+			Separator:  genSeparator,
+			Capitalize: genCapitalize,
+			AddDigit:   genDigit,
+		}
+
+		var err error
+		if password, err = crypto.GeneratePassphrase(policy); err != nil {
+			fmt.Printf("❌ Failed to generate passphrase: %v\n", err)
+			os.Exit(1)
+		}
 
-	fmt.Printf("🔑 Generated Password: %s\n", password)
+		fmt.Printf("🔑 Generated Passphrase: %s\n", password)
+		fmt.Printf("📊 Entropy: %.1f bits\n", policy.Entropy())
+	} else {
+		var err error
+		if password, err = crypto.GeneratePassword(genPolicy.policy(cmd, "length", genLength)); err != nil {
+			fmt.Printf("❌ Failed to generate password: %v\n", err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("🔑 Generated Password: %s\n", password)
+	}
 
 	if genCopy {
 		if err := clipboard.WriteAll(password); err != nil {
@@ -46,3 +78,62 @@ func runGenerate(cmd *cobra.Command, args []string) {
 		}
 	}
 }
+
+// policyFlags are the password policy options shared by the commands that
+// generate passwords. Only flags given on the command line override the
+// default policy from the settings.
+type policyFlags struct {
+	lower, upper, digits, symbols             bool
+	minLower, minUpper, minDigits, minSymbols int
+	symbolSet, exclude                        string
+	noAmbiguous                               bool
+	maxRepeat                                 int
+}
+
+func (f *policyFlags) register(cmd *cobra.Command, symbolsShorthand string) {
+	def := crypto.DefaultPolicy()
+	flags := cmd.Flags()
+	flags.BoolVar(&f.lower, "lower", def.Lowercase, "Include lowercase letters")
+	flags.BoolVar(&f.upper, "upper", def.Uppercase, "Include uppercase letters")
+	flags.BoolVar(&f.digits, "digits", def.Digits, "Include digits")
+	flags.BoolVarP(&f.symbols, "symbols", symbolsShorthand, def.Symbols, "Include symbols")
+	flags.IntVar(&f.minLower, "min-lower", def.MinLowercase, "Minimum lowercase letters")
+	flags.IntVar(&f.minUpper, "min-upper", def.MinUppercase, "Minimum uppercase letters")
+	flags.IntVar(&f.minDigits, "min-digits", def.MinDigits, "Minimum digits")
+	flags.IntVar(&f.minSymbols, "min-symbols", def.MinSymbols, "Minimum symbols")
+	flags.StringVar(&f.symbolSet, "symbol-set", crypto.DefaultSymbols, "Symbols to choose from")
+	flags.StringVar(&f.exclude, "exclude", "", "Characters never to use")
+	flags.BoolVar(&f.noAmbiguous, "no-ambiguous", def.ExcludeAmbiguous, "Avoid look-alike characters such as 0/O and l/1")
+	flags.IntVar(&f.maxRepeat, "max-repeat", def.MaxRepeat, "Most times a character may repeat in a row (0 = no limit)")
+}
+
+// policy returns the default policy with the flags that were set applied.
+// lengthFlag names the command's own length flag.
+func (f *policyFlags) policy(cmd *cobra.Command, lengthFlag string, length int) crypto.PasswordPolicy {
+	policy := crypto.DefaultPolicy()
+	if cfg, err := config.Load(); err == nil {
+		policy = cfg.Settings.PasswordPolicy
+	}
+
+	flags := cmd.Flags()
+	set := func(name string, apply func()) {
+		if flags.Changed(name) {
+			apply()
+		}
+	}
+	set(lengthFlag, func() { policy.Length = length })
+	set("lower", func() { policy.Lowercase = f.lower })
+	set("upper", func() { policy.Uppercase = f.upper })
+	set("digits", func() { policy.Digits = f.digits })
+	set("symbols", func() { policy.Symbols = f.symbols })
+	set("min-lower", func() { policy.MinLowercase = f.minLower })
+	set("min-upper", func() { policy.MinUppercase = f.minUpper })
+	set("min-digits", func() { policy.MinDigits = f.minDigits })
+	set("min-symbols", func() { policy.MinSymbols = f.minSymbols })
+	set("symbol-set", func() { policy.SymbolSet = f.symbolSet })
+	set("exclude", func() { policy.Exclude = f.exclude })
+	set("no-ambiguous", func() { policy.ExcludeAmbiguous = f.noAmbiguous })
+	set("max-repeat", func() { policy.MaxRepeat = f.maxRepeat })
+
+	return policy
+}
@@ -4,6 +4,10 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/vault"
 
 	"github.com/atotto/clipboard"
 	"github.com/spf13/cobra"
@@ -13,6 +17,7 @@ var (
 	getID   string
 	getCopy bool
 	getShow bool
+	getTOTP bool
 )
 
 var getCmd = &cobra.Command{
@@ -25,25 +30,35 @@ func init() {
 	getCmd.Flags().StringVarP(&getID, "id", "i", "", "Credential ID (required)")
 	getCmd.Flags().BoolVarP(&getCopy, "copy", "c", false, "Copy password to clipboard")
 	getCmd.Flags().BoolVarP(&getShow, "show", "s", false, "Show password in output")
+	getCmd.Flags().BoolVar(&getTOTP, "copy-totp", false, "Copy the current TOTP code to clipboard")
 	getCmd.MarkFlagRequired("id")
 }
 
 func runGet(cmd *cobra.Command, args []string) {
-	_, client, cryptoSvc := authenticate()
+	ctx := cmd.Context()
+
+	_, client, cryptoSvc, vaultConfig := authenticate(ctx)
 	defer cryptoSvc.SecureClear()
 
-	cred, err := client.GetCredential(getID)
+	cred, err := client.GetCredential(ctx, getID)
+	if err != nil {
+		fail("Failed to get credential", err)
+	}
+
+	// Decrypt title, username, URL and category if the vault encrypts them
+	*cred, err = vault.OpenMetadata(cryptoSvc, *cred, vaultConfig.CipherVersion)
 	if err != nil {
-		fmt.Printf("❌ Credential not found: %v\n", err)
+		fmt.Printf("❌ Failed to decrypt credential details: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Decrypt password
-	password, err := cryptoSvc.Decrypt(cred.EncryptedPassword)
+	password, err := vault.OpenSecret(cryptoSvc, cred.ID, vault.FieldPassword, cred.EncryptedPassword, vaultConfig.CipherVersion)
 	if err != nil {
 		fmt.Printf("❌ Failed to decrypt password: %v\n", err)
 		os.Exit(1)
 	}
+	defer password.Destroy()
 
 	fmt.Println("\n📋 Credential Details")
 	fmt.Println("=====================")
@@ -53,21 +68,68 @@ func runGet(cmd *cobra.Command, args []string) {
 	fmt.Printf("Category: %s\n", cred.Category)
 
 	if getShow {
-		fmt.Printf("Password: %s\n", password)
+		fmt.Print("Password: ")
+		os.Stdout.Write(password.Bytes())
+		fmt.Println()
 	} else {
 		fmt.Printf("Password: %s\n", "********")
 	}
 
 	if cred.Notes != "" {
-		notes, _ := cryptoSvc.Decrypt(cred.Notes)
-		fmt.Printf("Notes:    %s\n", notes)
+		notes, err := vault.OpenSecret(cryptoSvc, cred.ID, vault.FieldNotes, cred.Notes, vaultConfig.CipherVersion)
+		fmt.Print("Notes:    ")
+		if err != nil {
+			fmt.Printf("⚠️  %v\n", err)
+		} else {
+			os.Stdout.Write(notes.Bytes())
+			fmt.Println()
+			notes.Destroy()
+		}
+	}
+
+	var totpCode string
+	if cred.TOTP != "" {
+		code, remaining, err := currentTOTP(cryptoSvc, cred.ID, cred.TOTP, vaultConfig.CipherVersion)
+		if err != nil {
+			fmt.Printf("TOTP:     ⚠️  %v\n", err)
+		} else {
+			totpCode = code
+			fmt.Printf("TOTP:     %s (valid for %ds)\n", code, int(remaining.Seconds()))
+		}
 	}
 
 	if getCopy {
-		if err := clipboard.WriteAll(password); err != nil {
+		if err := clipboard.WriteAll(password.UnsafeString()); err != nil {
 			fmt.Printf("❌ Failed to copy to clipboard: %v\n", err)
 		} else {
 			fmt.Println("\n✅ Password copied to clipboard!")
 		}
 	}
+
+	if getTOTP {
+		if totpCode == "" {
+			fmt.Println("❌ This credential has no usable TOTP secret")
+			os.Exit(1)
+		}
+		if err := clipboard.WriteAll(totpCode); err != nil {
+			fmt.Printf("❌ Failed to copy to clipboard: %v\n", err)
+		} else {
+			fmt.Println("\n✅ TOTP code copied to clipboard!")
+		}
+	}
+}
+
+func currentTOTP(cryptoSvc *crypto.CryptoService, id, encrypted string, minVersion int) (code string, remaining time.Duration, err error) {
+	uri, err := vault.OpenSecret(cryptoSvc, id, vault.FieldTOTP, encrypted, minVersion)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to decrypt TOTP secret: %w", err)
+	}
+	defer uri.Destroy()
+
+	totp, err := crypto.ParseTOTPBytes(uri.Bytes())
+	if err != nil {
+		return "", 0, err
+	}
+	defer totp.Wipe()
+	return totp.Code(time.Now())
 }
@@ -2,9 +2,11 @@
 package cmd
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 
@@ -12,22 +14,243 @@ import (
 	"passmanager/internal/crypto"
 	"passmanager/internal/database"
 	"passmanager/internal/models"
+	"passmanager/internal/strength"
+	"passmanager/internal/vault"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
 
+var (
+	initBackend     string
+	initAllowWeak   bool
+	initRecoveryKey bool
+	initKit         string
+	initTuneKDF     bool
+)
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the password vault",
-	Long:  "Set up the password manager with master password and PocketBase connection",
+	Long:  "Set up the password manager with master password and PocketBase connection or a local vault file",
 	Run:   runInit,
 }
 
+func init() {
+	initCmd.Flags().StringVarP(&initBackend, "backend", "b", config.BackendPocketBase, "Storage backend (pocketbase, local or sqlite)")
+	initCmd.Flags().BoolVar(&initAllowWeak, "allow-weak-password", false, "Accept a master password that is easy to guess")
+	initCmd.Flags().BoolVar(&initRecoveryKey, "recovery-key", false, "Also create a recovery key that can unlock the vault")
+	initCmd.Flags().BoolVar(&initTuneKDF, "tune-kdf", false, "Calibrate Argon2 for this machine instead of using the fixed defaults")
+	initCmd.Flags().DurationVar(&kdfTarget, "kdf-target", crypto.DefaultKDFTarget, "Time an unlock should take, with --tune-kdf")
+	initCmd.Flags().IntVar(&kdfMaxMemory, "kdf-max-memory", crypto.DefaultKDFMaxMemory/1024, "Most memory Argon2 may use in MiB, with --tune-kdf")
+	initCmd.Flags().StringVar(&initKit, "kit", defaultKitPath(), "Where to write the emergency kit with the recovery key (.html or .txt)")
+}
+
 func runInit(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
 	fmt.Println("🔐 Password Manager Setup")
 	fmt.Println("========================")
 
+	cfg := &config.Config{
+		Backend:     initBackend,
+		Settings:    models.DefaultSettings(),
+		Initialized: true,
+	}
+
+	var client *database.PocketBaseClient
+	switch initBackend {
+	case config.BackendPocketBase:
+		client, cfg.PocketBaseURL, cfg.AdminEmail = initPocketBase(ctx)
+	case config.BackendLocal:
+		fmt.Printf("💾 Vault file: %s\n", config.GetVaultPath())
+	case config.BackendSQLite:
+		fmt.Printf("🗃️  Database: %s\n", config.GetSQLitePath())
+	default:
+		fmt.Printf("❌ Unknown backend %q (use pocketbase, local or sqlite)\n", initBackend)
+		os.Exit(1)
+	}
+
+	// Set master password
+	fmt.Println()
+	masterPass := readNewMasterPassword(initAllowWeak, cfg.AdminEmail, cfg.PocketBaseURL)
+	defer masterPass.Destroy()
+
+	// The vault key is wrapped with the password and the key file together
+	if keyFilePath != "" {
+		cfg.KeyFile = initKeyFile(keyFilePath)
+	}
+	secret, err := vault.WithKeyFile(masterPass.Bytes(), cfg.KeyFile)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(1)
+	}
+	defer secret.Destroy()
+
+	kdf := crypto.DefaultKDF()
+	if initTuneKDF {
+		fmt.Println()
+		kdf = calibrateKDF(kdfTarget, kdfMaxMemory)
+	}
+
+	// The vault config and the local vault file share this key
+	passKey, err := vault.NewPasswordKey(secret.Bytes(), kdf)
+	if err != nil {
+		fmt.Printf("❌ Failed to derive key: %v\n", err)
+		os.Exit(1)
+	}
+	defer passKey.Destroy()
+
+	var store database.VaultStore = client
+	switch initBackend {
+	case config.BackendLocal:
+		localStore, err := database.CreateLocalFileStore(config.GetVaultPath(), passKey)
+		if err != nil {
+			fmt.Printf("❌ Failed to create vault file: %v\n", err)
+			os.Exit(1)
+		}
+		store = localStore
+	case config.BackendSQLite:
+		sqliteStore, err := database.OpenSQLiteStore(config.GetSQLitePath())
+		if err != nil {
+			fmt.Printf("❌ Failed to create database: %v\n", err)
+			os.Exit(1)
+		}
+		store = sqliteStore
+	}
+
+	// Generate the vault key and wrap it with the master password
+	vaultConfig, dataKey, err := vault.NewConfig(passKey)
+	if err != nil {
+		fmt.Printf("❌ Failed to generate vault key: %v\n", err)
+		os.Exit(1)
+	}
+	// Kept for the recovery key, which is set up once the vault has an ID
+	defer dataKey.SecureClear()
+	vaultConfig.KeyFile = cfg.KeyFile != ""
+
+	fmt.Println("\n📦 Saving vault configuration...")
+	if err := store.SaveVaultConfig(ctx, vaultConfig); err != nil {
+		fail("Failed to save vault config", err)
+	}
+
+	// Save local config
+	if err := cfg.Save(); err != nil {
+		fmt.Printf("❌ Failed to save local config: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Println("\n✅ Password vault initialized successfully!")
+	if !initRecoveryKey {
+		fmt.Println("⚠️  Remember your master password - it cannot be recovered!")
+	}
+	if cfg.KeyFile != "" {
+		fmt.Printf("🔑 Key file: %s\n", cfg.KeyFile)
+		fmt.Println("⚠️  Keep a backup of the key file - the vault cannot be opened without it!")
+	}
+
+	if initRecoveryKey {
+		initEmergencyKit(ctx, cfg, store, dataKey)
+	}
+}
+
+// initEmergencyKit sets up the recovery key of a new vault. The vault works
+// without one, so failures only warn.
+func initEmergencyKit(ctx context.Context, cfg *config.Config, store database.VaultStore, dataKey *crypto.CryptoService) {
+	// The kit needs the ID the store gave the vault
+	saved, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to set up the recovery key: %s\n", database.Describe(err))
+		fmt.Println("   Run 'passmanager recovery kit' to try again.")
+		return
+	}
+
+	next, recoveryKey, err := vault.NewRecoveryKey(*saved, dataKey)
+	if err != nil {
+		fmt.Printf("⚠️  Failed to generate recovery key: %v\n", err)
+		return
+	}
+	defer recoveryKey.Destroy()
+
+	if !writeEmergencyKit(cfg, next, recoveryKey, initKit) {
+		fmt.Println("   No recovery key was set up. Run 'passmanager recovery kit' to try again.")
+		return
+	}
+	enableRecovery(ctx, store, next)
+}
+
+// initKeyFile returns the absolute path of the key file to use, creating a
+// random one if nothing is there yet.
+func initKeyFile(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	info, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := crypto.GenerateKeyFile(path); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("🔑 Created key file %s\n", path)
+	case err != nil:
+		fmt.Printf("❌ Failed to read key file: %v\n", err)
+		os.Exit(1)
+	case info.IsDir():
+		fmt.Printf("❌ Key file %s is a directory\n", path)
+		os.Exit(1)
+	}
+	return path
+}
+
+// readNewMasterPassword reads a new master password twice and checks it.
+// It exits unless the password is long and strong enough, or allowWeak is
+// set. The caller must Destroy the result.
+func readNewMasterPassword(allowWeak bool, userInputs ...string) *crypto.SecureBuffer {
+	masterPass := readPassword("Create Master Password (min 12 chars): ")
+
+	confirmPass := readPassword("Confirm Master Password: ")
+	matches := masterPass.Equal(confirmPass)
+	confirmPass.Destroy()
+
+	if !matches {
+		fmt.Println("❌ Passwords don't match")
+		os.Exit(1)
+	}
+
+	if masterPass.Len() < 12 {
+		fmt.Println("❌ Master password must be at least 12 characters")
+		os.Exit(1)
+	}
+
+	var result strength.Result
+	masterPass.Use(func(password []byte) error {
+		result = strength.EstimateSecret(password, userInputs...)
+		return nil
+	})
+	if result.Score < strength.ScoreStrong {
+		printWeakPassword("Master password is "+strings.ToLower(result.Label()), result)
+		if !allowWeak {
+			fmt.Println("   Choose a stronger one, or pass --allow-weak-password to use it anyway")
+			os.Exit(1)
+		}
+	}
+	return masterPass
+}
+
+// printWeakPassword explains what makes a password easy to guess.
+func printWeakPassword(msg string, result strength.Result) {
+	fmt.Printf("⚠️  %s (cracked in %s offline)\n", msg, result.CrackTime())
+	if result.Warning != "" {
+		fmt.Printf("   %s\n", result.Warning)
+	}
+	for _, suggestion := range result.Suggestions {
+		fmt.Printf("💡 %s\n", suggestion)
+	}
+}
+
+func initPocketBase(ctx context.Context) (*database.PocketBaseClient, string, string) {
 	// Get PocketBase URL
 	var pbURL string
 	fmt.Print("PocketBase URL (e.g., http://127.0.0.1:8090): ")
@@ -41,7 +264,7 @@ func runInit(cmd *cobra.Command, args []string) {
 	client := database.NewPocketBaseClient(pbURL)
 	
 	fmt.Println("\n🔍 Testing connection to PocketBase...")
-	if err := client.TestConnection(); err != nil {
+	if err := client.TestConnection(ctx); err != nil {
 		fmt.Printf("❌ %v\n", err)
 		fmt.Println("\n💡 Make sure PocketBase is running:")
 		fmt.Println("   ./pocketbase serve")
@@ -62,73 +285,27 @@ func runInit(cmd *cobra.Command, args []string) {
 
 	// Authenticate
 	fmt.Println("\n🔐 Authenticating...")
-	if err := client.Authenticate(adminEmail, adminPass); err != nil {
-		fmt.Printf("❌ Failed to authenticate: %v\n", err)
+	if err := client.Authenticate(ctx, adminEmail, adminPass); err != nil {
+		fmt.Printf("❌ Failed to authenticate: %s\n", database.Describe(err))
 		fmt.Println("\n💡 Troubleshooting:")
 		fmt.Println("   1. Make sure you've created an admin/superuser in PocketBase")
 		fmt.Println("   2. Go to PocketBase Admin UI → Settings → Admins")
 		fmt.Println("   3. Or create a 'users' collection with email/password auth")
-		os.Exit(1)
-	}
-
-	// Set master password
-	fmt.Print("\nCreate Master Password (min 12 chars): ")
-	masterPassBytes, _ := term.ReadPassword(int(syscall.Stdin))
-	masterPass := string(masterPassBytes)
-	fmt.Println()
-
-	fmt.Print("Confirm Master Password: ")
-	confirmPassBytes, _ := term.ReadPassword(int(syscall.Stdin))
-	confirmPass := string(confirmPassBytes)
-	fmt.Println()
-
-	if masterPass != confirmPass {
-		fmt.Println("❌ Passwords don't match")
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 
-	if len(masterPass) < 12 {
-		fmt.Println("❌ Master password must be at least 12 characters")
-		os.Exit(1)
-	}
-
-	// Generate salt and hash
-	salt, err := crypto.GenerateSalt()
+	// Create or upgrade the collections the vault needs
+	fmt.Println("\n🗄  Preparing collections...")
+	changes, err := client.EnsureSchema(ctx)
 	if err != nil {
-		fmt.Printf("❌ Failed to generate salt: %v\n", err)
-		os.Exit(1)
-	}
-
-	passwordHash := crypto.HashMasterPassword(masterPass, salt)
-
-	// Save vault config to PocketBase
-	vaultConfig := models.VaultConfig{
-		Salt:         base64.StdEncoding.EncodeToString(salt),
-		PasswordHash: passwordHash,
-	}
-
-	fmt.Println("\n📦 Saving vault configuration...")
-	if err := client.SaveVaultConfig(vaultConfig); err != nil {
-		fmt.Printf("❌ Failed to save vault config: %v\n", err)
-		fmt.Println("\n💡 Make sure you've created the 'vault_config' collection:")
-		fmt.Println("   - Go to PocketBase Admin UI")
-		fmt.Println("   - Create collection 'vault_config'")
-		fmt.Println("   - Add fields: salt (text), password_hash (text)")
-		os.Exit(1)
-	}
-
-	// Save local config
-	cfg := &config.Config{
-		PocketBaseURL:  pbURL,
-		AdminEmail:     adminEmail,
-		SessionTimeout: 5,
+		fmt.Printf("❌ Failed to prepare collections: %s\n", database.Describe(err))
+		fmt.Println("\n💡 Sign in as a superuser, or create the collections by hand (see README)")
+		os.Exit(exitCode(err))
 	}
-
-	if err := cfg.Save(); err != nil {
-		fmt.Printf("❌ Failed to save local config: %v\n", err)
-		os.Exit(1)
+	for _, change := range changes {
+		fmt.Printf("   - %s\n", change)
 	}
+	fmt.Println("✅ Collections ready")
 
-	fmt.Println("\n✅ Password vault initialized successfully!")
-	fmt.Println("⚠️  Remember your master password - it cannot be recovered!")
+	return client, pbURL, adminEmail
 }
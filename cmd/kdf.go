@@ -0,0 +1,85 @@
+// cmd/kdf.go
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/vault"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	kdfTarget    time.Duration
+	kdfMaxMemory int
+	kdfDryRun    bool
+)
+
+var kdfCmd = &cobra.Command{
+	Use:   "kdf",
+	Short: "Manage how the master password is turned into a key",
+}
+
+var kdfTuneCmd = &cobra.Command{
+	Use:   "tune",
+	Short: "Calibrate Argon2 for this machine and apply it to the vault",
+	Long: `Benchmark Argon2id on this machine and pick the memory and number of passes
+that take about --target per unlock without using more than --max-memory.
+The vault key is then re-wrapped with those settings. Every device that
+unlocks the vault uses them, so tune on the slowest one.`,
+	Run: runKDFTune,
+}
+
+func init() {
+	kdfTuneCmd.Flags().DurationVar(&kdfTarget, "target", crypto.DefaultKDFTarget, "Time an unlock should take")
+	kdfTuneCmd.Flags().IntVar(&kdfMaxMemory, "max-memory", crypto.DefaultKDFMaxMemory/1024, "Most memory to use, in MiB")
+	kdfTuneCmd.Flags().BoolVar(&kdfDryRun, "dry-run", false, "Only show the settings that would be used")
+	kdfCmd.AddCommand(kdfTuneCmd)
+}
+
+func runKDFTune(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	kdf := calibrateKDF(kdfTarget, kdfMaxMemory)
+	if kdfDryRun {
+		return
+	}
+
+	_, store, secret, cryptoSvc, vaultConfig := unlockVault(ctx)
+	defer secret.Destroy()
+	defer cryptoSvc.SecureClear()
+
+	if current, err := crypto.ParseKDF(vaultConfig.KDF); err == nil && vaultConfig.KDF != "" {
+		fmt.Printf("ℹ️  Current settings: %s\n", current.Summary())
+	}
+
+	if _, err := vault.SetKDF(ctx, store, vaultConfig, cryptoSvc, secret.Bytes(), kdf); err != nil {
+		fail("Failed to update key derivation", err)
+	}
+	fmt.Println("✅ Vault key re-wrapped with the new settings")
+}
+
+// calibrateKDF benchmarks Argon2id for target and maxMemory (MiB) and
+// prints what it picked. It exits if the limits make no sense.
+func calibrateKDF(target time.Duration, maxMemory int) crypto.KDFParams {
+	if maxMemory <= 0 {
+		fmt.Println("❌ --max-memory must be positive")
+		os.Exit(exitInvalid)
+	}
+
+	fmt.Printf("⏱️  Calibrating Argon2id for %s per unlock, at most %d MiB...\n", target, maxMemory)
+	kdf, took, err := crypto.Calibrate(target, uint32(maxMemory)*1024)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(exitInvalid)
+	}
+
+	fmt.Printf("✅ %s (%s per unlock here)\n", kdf.Summary(), took.Round(10*time.Millisecond))
+	if took > 2*target {
+		fmt.Println("⚠️  This machine is slower than the target even at the minimum settings")
+	}
+	return kdf
+}
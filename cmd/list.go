@@ -4,11 +4,19 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"time"
+
+	"passmanager/internal/vault"
 
 	"github.com/spf13/cobra"
 )
 
-var listSearch string
+var (
+	listSearch     string
+	listCategories []string
+	listSince      string
+	listDomain     string
+)
 
 var listCmd = &cobra.Command{
 	Use:   "list",
@@ -18,18 +26,31 @@ var listCmd = &cobra.Command{
 
 func init() {
 	listCmd.Flags().StringVarP(&listSearch, "search", "s", "", "Search by title, username, or URL")
+	listCmd.Flags().StringSliceVarP(&listCategories, "category", "c", nil, "Only show these categories (repeatable)")
+	listCmd.Flags().StringVar(&listDomain, "domain", "", "Only show credentials for this domain")
+	listCmd.Flags().StringVar(&listSince, "since", "", "Only show credentials updated on or after this date (YYYY-MM-DD)")
 }
 
 func runList(cmd *cobra.Command, args []string) {
-	_, client, cryptoSvc := authenticate()
-	defer cryptoSvc.SecureClear()
+	ctx := cmd.Context()
 
-	creds, err := client.ListCredentials(listSearch)
+	query, err := buildListQuery()
 	if err != nil {
-		fmt.Printf("❌ Failed to list credentials: %v\n", err)
+		fmt.Printf("❌ %v\n", err)
 		os.Exit(1)
 	}
 
+	_, client, cryptoSvc, vaultConfig := authenticate(ctx)
+	defer cryptoSvc.SecureClear()
+
+	creds, failures, err := vault.Find(ctx, client, cryptoSvc, vaultConfig, query)
+	if err != nil {
+		fail("Failed to list credentials", err)
+	}
+	for _, failure := range failures {
+		fmt.Printf("⚠️  Skipped %s, it could not be decrypted: %v\n", failure.ID, failure.Err)
+	}
+
 	if len(creds) == 0 {
 		fmt.Println("📭 No credentials found")
 		return
@@ -49,6 +70,24 @@ func runList(cmd *cobra.Command, args []string) {
 	fmt.Printf("\nTotal: %d credential(s)\n", len(creds))
 }
 
+func buildListQuery() (vault.Query, error) {
+	query := vault.Query{
+		Term:       listSearch,
+		Domain:     listDomain,
+		Categories: listCategories,
+	}
+
+	if listSince != "" {
+		since, err := time.ParseInLocation("2006-01-02", listSince, time.Local)
+		if err != nil {
+			return vault.Query{}, fmt.Errorf("invalid --since date %q, expected YYYY-MM-DD", listSince)
+		}
+		query.Since = since
+	}
+
+	return query, nil
+}
+
 func truncate(s string, max int) string {
 	if len(s) > max {
 		return s[:max-2] + ".."
@@ -0,0 +1,346 @@
+// cmd/recovery.go
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"passmanager/internal/config"
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+	"passmanager/internal/ui"
+	"passmanager/internal/vault"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	recoveryShares    int
+	recoveryThreshold int
+	recoveryOut       string
+	recoveryKitOut    string
+	recoverAllowWeak  bool
+)
+
+var recoveryCmd = &cobra.Command{
+	Use:   "recovery",
+	Short: "Manage the recovery key for a lost master password",
+}
+
+var recoveryKitCmd = &cobra.Command{
+	Use:   "kit",
+	Short: "Create a new recovery key and write it to an emergency kit",
+	Long: `Create a new recovery key and write it, with what is needed to reach the
+vault, to a printable emergency kit. Earlier kits and shares stop working.`,
+	Run: runRecoveryKit,
+}
+
+var recoverySplitCmd = &cobra.Command{
+	Use:   "split",
+	Short: "Create a new recovery key split into shares",
+	Long: `Create a new recovery key and split it into shares, any threshold of which
+recover the vault. Earlier shares stop working.`,
+	Run: runRecoverySplit,
+}
+
+var recoveryDisableCmd = &cobra.Command{
+	Use:   "disable",
+	Short: "Remove the recovery key, so its kit and shares no longer work",
+	Run:   runRecoveryDisable,
+}
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Unlock the vault with the recovery key or shares and set a new master password",
+	Run:   runRecover,
+}
+
+func init() {
+	recoverySplitCmd.Flags().IntVarP(&recoveryShares, "shares", "n", 5, "Number of shares")
+	recoverySplitCmd.Flags().IntVarP(&recoveryThreshold, "threshold", "t", 3, "Shares needed to recover")
+	recoverySplitCmd.Flags().StringVarP(&recoveryOut, "out", "o", "", "Save shares as text and PNG files in this folder instead of printing them")
+	recoveryKitCmd.Flags().StringVarP(&recoveryKitOut, "out", "o", defaultKitPath(), "Where to write the kit (.html or .txt)")
+	recoveryCmd.AddCommand(recoveryKitCmd)
+	recoveryCmd.AddCommand(recoverySplitCmd)
+	recoveryCmd.AddCommand(recoveryDisableCmd)
+
+	recoverCmd.Flags().BoolVar(&recoverAllowWeak, "allow-weak-password", false, "Accept a master password that is easy to guess")
+}
+
+func runRecoverySplit(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	if recoveryThreshold < 2 || recoveryShares < recoveryThreshold || recoveryShares > 255 {
+		fmt.Println("❌ Use a threshold of at least 2 and no more shares than 255")
+		os.Exit(exitInvalid)
+	}
+
+	_, store, cryptoSvc, vaultConfig := authenticate(ctx)
+	defer cryptoSvc.SecureClear()
+
+	// Saving the new key would fail at the end, after handing it out
+	if err := vault.CheckRotation(ctx, store); err != nil {
+		fail("Failed to set up recovery", err)
+	}
+
+	next, recoveryKey, err := vault.NewRecoveryKey(vaultConfig, cryptoSvc)
+	if err != nil {
+		fail("Failed to set up recovery", err)
+	}
+	defer recoveryKey.Destroy()
+
+	shares, err := vault.SplitRecoveryKey(next, recoveryKey.Bytes(), recoveryShares, recoveryThreshold)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		os.Exit(exitError)
+	}
+
+	// The new key is only saved once every share is out; until then the
+	// earlier shares keep working
+	if recoveryOut != "" {
+		paths, err := ui.SaveShares(recoveryOut, shares)
+		if err != nil {
+			fmt.Printf("❌ Failed to save shares: %v\n", err)
+			fmt.Println("ℹ️  Recovery key not changed")
+			os.Exit(exitError)
+		}
+		for _, path := range paths {
+			fmt.Printf("💾 %s\n", path)
+		}
+	} else {
+		cards := make([]string, len(shares))
+		for i, share := range shares {
+			if cards[i], err = ui.ShareCard(share, len(shares)); err != nil {
+				fmt.Printf("❌ %v\n", err)
+				fmt.Println("ℹ️  Recovery key not changed")
+				os.Exit(exitError)
+			}
+		}
+		for _, card := range cards {
+			fmt.Println(card)
+		}
+	}
+
+	enableRecovery(ctx, store, next)
+
+	fmt.Printf("✅ Recovery set up: any %d of %d shares recover the vault\n", recoveryThreshold, recoveryShares)
+	fmt.Println("⚠️  Hand the shares out to different people or places. Earlier shares no longer work.")
+}
+
+func runRecoveryKit(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	cfg, store, cryptoSvc, vaultConfig := authenticate(ctx)
+	defer cryptoSvc.SecureClear()
+
+	// Saving the new key would fail at the end, after handing it out
+	if err := vault.CheckRotation(ctx, store); err != nil {
+		fail("Failed to set up recovery", err)
+	}
+
+	next, recoveryKey, err := vault.NewRecoveryKey(vaultConfig, cryptoSvc)
+	if err != nil {
+		fail("Failed to set up recovery", err)
+	}
+	defer recoveryKey.Destroy()
+
+	if !writeEmergencyKit(cfg, next, recoveryKey, recoveryKitOut) {
+		fmt.Println("ℹ️  Recovery key not changed")
+		os.Exit(exitError)
+	}
+	enableRecovery(ctx, store, next)
+	fmt.Println("⚠️  Earlier emergency kits and recovery shares no longer work.")
+}
+
+// defaultKitPath is where the emergency kit goes unless told otherwise.
+func defaultKitPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "passmanager-emergency-kit.html")
+}
+
+// writeEmergencyKit saves the emergency kit to path and prints the recovery
+// key, and reports whether it worked. The key is not in effect until
+// enableRecovery.
+func writeEmergencyKit(cfg *config.Config, vaultConfig models.VaultConfig, recoveryKey *crypto.SecureBuffer, path string) bool {
+	kit := ui.NewEmergencyKit(cfg, vaultConfig, recoveryKey.Bytes())
+
+	if err := ui.SaveEmergencyKit(path, kit); err != nil {
+		fmt.Printf("❌ Failed to save emergency kit: %v\n", err)
+		return false
+	}
+	fmt.Printf("\n🆘 Recovery key: %s\n", kit.RecoveryKey)
+	fmt.Printf("📄 Emergency kit saved to %s\n", path)
+	fmt.Println("⚠️  Print it and keep it somewhere safe, then delete the file from this device.")
+	return true
+}
+
+// enableRecovery saves next, whose recovery key has just been handed out,
+// and exits if that fails.
+func enableRecovery(ctx context.Context, store database.VaultStore, next models.VaultConfig) {
+	if err := vault.EnableRecovery(ctx, store, next); err != nil {
+		fmt.Println("⚠️  The kit or shares just created do not work; any earlier ones still do.")
+		fail("Failed to save the new recovery key", err)
+	}
+}
+
+func runRecoveryDisable(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	_, store, cryptoSvc, vaultConfig := authenticate(ctx)
+	defer cryptoSvc.SecureClear()
+
+	if vaultConfig.RecoveryPublicKey == "" {
+		fmt.Println("ℹ️  Recovery is not set up")
+		return
+	}
+	if _, err := vault.DisableRecovery(ctx, store, vaultConfig); err != nil {
+		fail("Failed to turn off recovery", err)
+	}
+	fmt.Println("✅ Recovery turned off; the existing kit and shares no longer work")
+}
+
+func runRecover(cmd *cobra.Command, args []string) {
+	ctx := cmd.Context()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("❌ Vault not initialized. Run 'passmanager init' first.")
+		os.Exit(1)
+	}
+
+	// Finishing the rotation later would bring the old password back
+	if journal, _ := vault.LoadJournal(); journal != nil {
+		fmt.Printf("❌ %v. This needs the master password; recovery is not possible until then.\n", vault.ErrRekeyInProgress)
+		os.Exit(exitError)
+	}
+
+	fmt.Println("🧩 Enter your recovery key, or your recovery shares one at a time (empty to cancel).")
+	recoveryKey := readRecoveryKey()
+	defer recoveryKey.Destroy()
+
+	var store database.VaultStore
+	switch cfg.Backend {
+	case config.BackendLocal:
+		localStore, err := database.OpenLocalFileStoreRecovery(config.GetVaultPath(), recoveryKey.Bytes())
+		if err != nil {
+			failRecovery(err)
+		}
+		store = localStore
+	case config.BackendSQLite:
+		sqliteStore, err := database.OpenSQLiteStore(config.GetSQLitePath())
+		if err != nil {
+			fail("Failed to open database", err)
+		}
+		store = sqliteStore
+	default:
+		store = connectPocketBase(ctx, cfg)
+	}
+
+	vaultConfig, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		fail("Failed to get vault config", err)
+	}
+
+	dataKey, err := vault.UnlockWithRecovery(recoveryKey.Bytes(), *vaultConfig)
+	if err != nil {
+		failRecovery(err)
+	}
+	defer dataKey.SecureClear()
+
+	// Recover re-saves the config, which must not carry a rolled back
+	// cipher version along
+	lowered, err := vault.PinCipherVersion(vaultConfig, dataKey)
+	if err != nil {
+		fmt.Printf("❌ Failed to check the vault's cipher version: %v\n", err)
+		os.Exit(exitError)
+	}
+	if lowered {
+		fmt.Println("⚠️  The vault config claims an older encryption format than this device has seen; older records are refused")
+	}
+	fmt.Println("✅ Recovery key accepted")
+
+	fmt.Println()
+	newPass := readNewMasterPassword(recoverAllowWeak, cfg.AdminEmail, cfg.PocketBaseURL)
+	defer newPass.Destroy()
+
+	if _, err := vault.Recover(ctx, store, *vaultConfig, dataKey, newPass.Bytes()); err != nil {
+		fail("Failed to set new master password", err)
+	}
+	if cfg.KeyFile != "" {
+		cfg.KeyFile = ""
+		cfg.Save()
+	}
+
+	fmt.Println("\n✅ Vault recovered. Unlock it with the new master password from now on.")
+	if vaultConfig.KeyFile {
+		fmt.Println("ℹ️  A key file is no longer required")
+	}
+	fmt.Println("💡 Your recovery key and shares still work. Run 'passmanager recovery kit' or 'passmanager recovery split' for new ones if any were exposed.")
+}
+
+// readRecoveryKey reads the whole recovery key, or recovery shares until
+// there are enough to rebuild it. The caller must Destroy the result.
+func readRecoveryKey() *crypto.SecureBuffer {
+	var shares []vault.Share
+	for {
+		prompt := "Recovery key or share 1: "
+		if len(shares) > 0 {
+			prompt = fmt.Sprintf("Share %d of %d: ", len(shares)+1, shares[0].Threshold)
+		}
+		text := readPassword(prompt)
+		if len(bytes.TrimSpace(text.Bytes())) == 0 {
+			fmt.Println("❌ Recovery cancelled")
+			os.Exit(exitCancelled)
+		}
+
+		if len(shares) == 0 && vault.IsRecoveryKey(text.Bytes()) {
+			recoveryKey, err := vault.ParseRecoveryKey(text.Bytes())
+			text.Destroy()
+			if err != nil {
+				fmt.Printf("❌ %v\n", err)
+				continue
+			}
+			return recoveryKey
+		}
+
+		share, err := vault.ParseShare(text.Bytes())
+		text.Destroy()
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			continue
+		}
+		if len(shares) > 0 && share.Set != shares[0].Set {
+			fmt.Println("❌ This share belongs to a different set")
+			continue
+		}
+		if slices.ContainsFunc(shares, func(s vault.Share) bool { return s.Index() == share.Index() }) {
+			fmt.Printf("⚠️  Share %d was already entered\n", share.Index())
+			continue
+		}
+
+		shares = append(shares, share)
+		if len(shares) >= share.Threshold {
+			recoveryKey, err := vault.CombineShares(shares)
+			if err != nil {
+				fmt.Printf("❌ %v\n", err)
+				os.Exit(exitInvalid)
+			}
+			return recoveryKey
+		}
+		fmt.Printf("✅ Share %d accepted, %d more needed\n", share.Index(), share.Threshold-len(shares))
+	}
+}
+
+func failRecovery(err error) {
+	if errors.Is(err, crypto.ErrInvalidRecoveryKey) || errors.Is(err, vault.ErrRecoveryKeyMismatch) {
+		fmt.Println("❌ This does not match the vault's current recovery key")
+		os.Exit(exitAuth)
+	}
+	fail("Recovery failed", err)
+}
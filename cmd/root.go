@@ -2,8 +2,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/spf13/cobra"
 )
@@ -18,17 +21,29 @@ All passwords are encrypted locally before being stored.`,
 }
 
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Ctrl+C cancels in-flight requests instead of killing the process
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
+// keyFilePath overrides the key file location saved in the config
+var keyFilePath string
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&keyFilePath, "keyfile", "", "Key file needed to unlock the vault, if it uses one")
+
 	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(addCmd)
 	rootCmd.AddCommand(getCmd)
 	rootCmd.AddCommand(listCmd)
 	rootCmd.AddCommand(deleteCmd)
 	rootCmd.AddCommand(generateCmd)
+	rootCmd.AddCommand(recoveryCmd)
+	rootCmd.AddCommand(recoverCmd)
+	rootCmd.AddCommand(kdfCmd)
 }
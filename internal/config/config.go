@@ -9,9 +9,17 @@ import (
 	"passmanager/internal/models"
 )
 
+const (
+	BackendPocketBase = "pocketbase"
+	BackendLocal      = "local"
+	BackendSQLite     = "sqlite"
+)
+
 type Config struct {
+	Backend       string              `json:"backend,omitempty"`
 	PocketBaseURL string              `json:"pocketbase_url"`
 	AdminEmail    string              `json:"admin_email"`
+	KeyFile       string              `json:"key_file,omitempty"` // where this device finds the vault's key file
 	Settings      *models.AppSettings `json:"settings"`
 	Initialized   bool                `json:"initialized"`
 }
@@ -25,6 +33,18 @@ func GetConfigPath() string {
 	return filepath.Join(GetConfigDir(), "config.json")
 }
 
+// GetVaultPath returns the location of the offline vault file used by the
+// local backend.
+func GetVaultPath() string {
+	return filepath.Join(GetConfigDir(), "vault.enc")
+}
+
+// GetSQLitePath returns the location of the database used by the SQLite
+// backend.
+func GetSQLitePath() string {
+	return filepath.Join(GetConfigDir(), "vault.db")
+}
+
 func Load() (*Config, error) {
 	configPath := GetConfigPath()
 
@@ -33,7 +53,9 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
-	var config Config
+	// Start from the defaults so settings added since the file was written
+	// get sensible values
+	config := Config{Settings: models.DefaultSettings()}
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, err
 	}
@@ -43,9 +65,39 @@ func Load() (*Config, error) {
 		config.Settings = models.DefaultSettings()
 	}
 
+	migrateSettings(data, config.Settings)
+
+	// Configs written before backends were selectable always used PocketBase
+	if config.Backend == "" {
+		config.Backend = BackendPocketBase
+	}
+
 	return &config, nil
 }
 
+// migrateSettings carries over settings from older config files.
+func migrateSettings(data []byte, settings *models.AppSettings) {
+	// Before password policies there was only a length and a symbols toggle
+	var legacy struct {
+		Settings struct {
+			PasswordPolicy json.RawMessage `json:"password_policy"`
+			PasswordLength int             `json:"password_length"`
+			IncludeSymbols *bool           `json:"include_symbols"`
+		} `json:"settings"`
+	}
+	if json.Unmarshal(data, &legacy) != nil || legacy.Settings.PasswordPolicy != nil {
+		return
+	}
+
+	if legacy.Settings.PasswordLength > 0 {
+		settings.PasswordPolicy.Length = legacy.Settings.PasswordLength
+	}
+	if legacy.Settings.IncludeSymbols != nil && !*legacy.Settings.IncludeSymbols {
+		settings.PasswordPolicy.Symbols = false
+		settings.PasswordPolicy.MinSymbols = 0
+	}
+}
+
 func (c *Config) Save() error {
 	configDir := GetConfigDir()
 	configPath := GetConfigPath()
@@ -63,7 +115,7 @@ func (c *Config) Save() error {
 		return err
 	}
 
-	return os.WriteFile(configPath, data, 0600)
+	return WriteFileAtomic(configPath, data, 0600)
 }
 
 func Exists() bool {
@@ -73,6 +125,7 @@ func Exists() bool {
 
 func NewDefault() *Config {
 	return &Config{
+		Backend:     BackendPocketBase,
 		Settings:    models.DefaultSettings(),
 		Initialized: false,
 	}
@@ -0,0 +1,83 @@
+// internal/crypto/calibrate.go
+package crypto
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Defaults for Calibrate: about a second per unlock, and no more memory
+// than small machines can spare. An unlock derives one key on every
+// backend, as the local vault file is keyed by a subkey of it, so the time
+// measured is the time an unlock takes.
+const (
+	DefaultKDFTarget    = time.Second
+	DefaultKDFMaxMemory = 256 * 1024 // KiB
+)
+
+// Every vault's KDF has to cost at least OWASP's minimum for Argon2id:
+// 19 MiB with two passes, or less memory with more passes, down to 7 MiB.
+const (
+	minKDFMemory = 7 * 1024
+	minKDFCost   = 2 * 19 * 1024 // passes times KiB
+)
+
+// Calibrate benchmarks Argon2id on this machine and picks parameters that
+// use as much memory as maxMemory (KiB) allows and as many passes as fit
+// in target. Memory only goes down when one pass is already too slow, and
+// never below the minimum cost, even if that takes longer than target. It
+// returns the parameters and how long they took.
+func Calibrate(target time.Duration, maxMemory uint32) (KDFParams, time.Duration, error) {
+	if target <= 0 {
+		return KDFParams{}, 0, errors.New("target time must be positive")
+	}
+	if maxMemory < minKDFMemory {
+		return KDFParams{}, 0, fmt.Errorf("at least %d MiB of memory is needed", minKDFMemory/1024)
+	}
+	if maxMemory > maxKDFMemory {
+		return KDFParams{}, 0, fmt.Errorf("at most %d MiB of memory can be used", maxKDFMemory/1024)
+	}
+
+	p := KDFParams{
+		Algorithm: KDFArgon2id,
+		Time:      1,
+		Memory:    maxMemory,
+		Threads:   uint8(min(runtime.NumCPU(), argonThreads)),
+	}
+	salt := make([]byte, saltLen)
+
+	pass := p.benchmark(salt)
+	for pass > target && p.Memory/2 >= minKDFMemory {
+		p.Memory /= 2
+		pass = p.benchmark(salt)
+	}
+
+	// The first pass costs more than the rest, so correct once by measuring
+	p.Time = p.passesFor(target, pass)
+	took := p.benchmark(salt)
+	if next := p.passesFor(target, took/time.Duration(p.Time)); next != p.Time {
+		p.Time = next
+		took = p.benchmark(salt)
+	}
+	return p, took, nil
+}
+
+// passesFor returns how many passes of perPass fit in target, but never
+// fewer than the minimum cost needs at p.Memory, nor more than the limits
+// allow.
+func (p KDFParams) passesFor(target, perPass time.Duration) uint32 {
+	passes := uint32(min(max(1, target/max(perPass, 1)), maxKDFTime))
+	passes = min(passes, maxKDFCost/p.Memory)
+	return max(passes, (minKDFCost+p.Memory-1)/p.Memory)
+}
+
+// benchmark times one key derivation with p.
+func (p KDFParams) benchmark(salt []byte) time.Duration {
+	start := time.Now()
+	argon2.IDKey([]byte("calibration"), salt, p.Time, p.Memory, p.Threads, argonKeyLen)
+	return time.Since(start)
+}
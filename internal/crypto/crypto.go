@@ -4,14 +4,18 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 
-	"golang.org/x/crypto/argon2"
+	"strconv"
+	"strings"
 )
 
 const (
@@ -22,19 +26,28 @@ const (
 	saltLen      = 16
 )
 
+// Ciphertexts are "v<version>:" followed by base64 of nonce and sealed
+// bytes. Those written before versioning have no prefix and count as
+// version 0; they are still read, but never written. Version 2 ciphertexts
+// are bound to associated data and only open with the same data.
+const (
+	CipherLegacy  = 0
+	CipherV1      = 1
+	CipherV2      = 2
+	CipherCurrent = CipherV2
+)
+
+var ErrUnsupportedCipher = errors.New("unsupported ciphertext version")
+
+// CryptoService encrypts with a 256-bit key kept in a SecureBuffer.
 type CryptoService struct {
-	masterKey []byte
+	masterKey *SecureBuffer
 }
 
-func DeriveKey(password string, salt []byte) []byte {
-	return argon2.IDKey(
-		[]byte(password),
-		salt,
-		argonTime,
-		argonMemory,
-		argonThreads,
-		argonKeyLen,
-	)
+// DeriveKey uses the parameters vaults had before they were stored.
+func DeriveKey(password []byte, salt []byte) []byte {
+	key, _ := LegacyKDF().DeriveKey(password, salt)
+	return key
 }
 
 func GenerateSalt() ([]byte, error) {
@@ -45,96 +58,318 @@ func GenerateSalt() ([]byte, error) {
 	return salt, nil
 }
 
-func NewCryptoService(masterPassword string, salt []byte) *CryptoService {
-	key := DeriveKey(masterPassword, salt)
-	return &CryptoService{masterKey: key}
+func NewCryptoService(masterPassword []byte, salt []byte) (*CryptoService, error) {
+	return newKeyService(DeriveKey(masterPassword, salt))
 }
 
-func (c *CryptoService) Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(c.masterKey)
+// GenerateKey returns a service with a new random key.
+func GenerateKey() (*CryptoService, error) {
+	key, err := NewSecureBuffer(argonKeyLen)
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return nil, err
+	}
+	if _, err := rand.Read(key.Bytes()); err != nil {
+		key.Destroy()
+		return nil, fmt.Errorf("failed to generate key: %w", err)
 	}
+	return &CryptoService{masterKey: key}, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// NewCryptoServiceFromKey uses a copy of an existing 32-byte key.
+func NewCryptoServiceFromKey(key []byte) (*CryptoService, error) {
+	if len(key) != argonKeyLen {
+		return nil, fmt.Errorf("invalid key length %d", len(key))
+	}
+	buf, err := NewSecureBuffer(len(key))
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
+	copy(buf.Bytes(), key)
+	return &CryptoService{masterKey: buf}, nil
+}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return "", fmt.Errorf("failed to generate nonce: %w", err)
+// newKeyService moves key into a service and wipes it.
+func newKeyService(key []byte) (*CryptoService, error) {
+	if len(key) != argonKeyLen {
+		clearBytes(key)
+		return nil, fmt.Errorf("invalid key length %d", len(key))
 	}
+	buf, err := SecureBytes(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CryptoService{masterKey: buf}, nil
+}
 
-	ciphertext := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+// Clone returns an independent copy, so that clearing one does not affect
+// the other.
+func (c *CryptoService) Clone() (*CryptoService, error) {
+	key, err := c.masterKey.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return &CryptoService{masterKey: key}, nil
 }
 
-func (c *CryptoService) Decrypt(encryptedText string) (string, error) {
-	ciphertext, err := base64.StdEncoding.DecodeString(encryptedText)
+// WrapKey encrypts the key of other under this service's key.
+func (c *CryptoService) WrapKey(other *CryptoService) (string, error) {
+	// Sealing locks c's key, which may be other's; take a copy first
+	key, err := other.masterKey.Clone()
 	if err != nil {
-		return "", fmt.Errorf("failed to decode ciphertext: %w", err)
+		return "", err
 	}
+	defer key.Destroy()
 
-	block, err := aes.NewCipher(c.masterKey)
+	encoded, err := NewSecureBuffer(base64.StdEncoding.EncodedLen(key.Len()))
 	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+		return "", err
 	}
+	defer encoded.Destroy()
 
-	gcm, err := cipher.NewGCM(block)
+	base64.StdEncoding.Encode(encoded.Bytes(), key.Bytes())
+	return c.seal(CipherV1, encoded.Bytes(), nil)
+}
+
+// UnwrapKey reverses WrapKey.
+func (c *CryptoService) UnwrapKey(wrapped string) (*CryptoService, error) {
+	encoded, err := c.Decrypt(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	defer encoded.Destroy()
+
+	key, err := NewSecureBuffer(base64.StdEncoding.DecodedLen(encoded.Len()))
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+
+	n, err := base64.StdEncoding.Decode(key.Bytes(), encoded.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode key: %w", err)
+	}
+
+	return NewCryptoServiceFromKey(key.Bytes()[:n])
+}
+
+func (c *CryptoService) Encrypt(plaintext string) (string, error) {
+	return c.seal(CipherV1, []byte(plaintext), nil)
+}
+
+// EncryptBytes is Encrypt for a plaintext held as bytes, which the caller
+// can wipe afterwards.
+func (c *CryptoService) EncryptBytes(plaintext []byte) (string, error) {
+	return c.seal(CipherV1, plaintext, nil)
+}
+
+// Decrypt reverses Encrypt. The plaintext is left in a SecureBuffer, which
+// the caller must Destroy.
+func (c *CryptoService) Decrypt(encryptedText string) (*SecureBuffer, error) {
+	return c.DecryptBound(encryptedText, nil)
+}
+
+// EncryptBound encrypts plaintext so that it only decrypts with the same
+// associated data.
+func (c *CryptoService) EncryptBound(plaintext string, aad []byte) (string, error) {
+	return c.seal(CipherV2, []byte(plaintext), aad)
+}
+
+// EncryptBoundBytes is EncryptBound for a plaintext held as bytes.
+func (c *CryptoService) EncryptBoundBytes(plaintext, aad []byte) (string, error) {
+	return c.seal(CipherV2, plaintext, aad)
+}
+
+// DecryptBound reverses EncryptBound. Older ciphertexts were written
+// without associated data and are opened without it. The plaintext is
+// decrypted straight into a SecureBuffer, which the caller must Destroy.
+func (c *CryptoService) DecryptBound(encryptedText string, aad []byte) (*SecureBuffer, error) {
+	version, payload, err := splitCiphertext(encryptedText)
+	if err != nil {
+		return nil, err
+	}
+	if version > CipherCurrent {
+		return nil, fmt.Errorf("%w %d", ErrUnsupportedCipher, version)
+	}
+	if version < CipherV2 {
+		aad = nil
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode ciphertext: %w", err)
+	}
+
+	gcm, err := c.gcm()
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
-	if len(ciphertext) < nonceSize {
-		return "", errors.New("ciphertext too short")
+	if len(ciphertext) < nonceSize+gcm.Overhead() {
+		return nil, errors.New("ciphertext too short")
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := NewSecureBuffer(len(ciphertext) - gcm.Overhead())
 	if err != nil {
-		return "", fmt.Errorf("failed to decrypt: %w", err)
+		return nil, err
+	}
+
+	// Open appends to the buffer's own memory, as it has exactly the room
+	// needed
+	if _, err := gcm.Open(plaintext.Bytes()[:0], nonce, ciphertext, aad); err != nil {
+		plaintext.Destroy()
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
 
-	return string(plaintext), nil
+	return plaintext, nil
 }
 
-func HashMasterPassword(password string, salt []byte) string {
-	key := DeriveKey(password, salt)
+func (c *CryptoService) seal(version int, plaintext []byte, aad []byte) (string, error) {
+	gcm, err := c.gcm()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, aad)
+	return fmt.Sprintf("v%d:%s", version, base64.StdEncoding.EncodeToString(ciphertext)), nil
+}
+
+func (c *CryptoService) gcm() (cipher.AEAD, error) {
+	var block cipher.Block
+	err := c.masterKey.Use(func(key []byte) error {
+		var err error
+		block, err = aes.NewCipher(key)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// BlindIndex returns a keyed hash of value for purpose, so equal values can
+// be matched on a server that never sees them. The key is derived from this
+// service's key and used for nothing else.
+func (c *CryptoService) BlindIndex(purpose, value string) (string, error) {
+	var indexKey []byte
+	err := c.masterKey.Use(func(key []byte) error {
+		var err error
+		indexKey, err = hkdf.Key(sha256.New, key, nil, "passmanager blind index", argonKeyLen)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to derive index key: %w", err)
+	}
+	defer clearBytes(indexKey)
+
+	mac := hmac.New(sha256.New, indexKey)
+	mac.Write([]byte(purpose))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+
+	// 128 bits is plenty to tell values apart; matches are re-checked after
+	// decryption anyway
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16]), nil
+}
+
+// CipherVersion returns the format version of a ciphertext.
+func CipherVersion(encryptedText string) (int, error) {
+	version, _, err := splitCiphertext(encryptedText)
+	return version, err
+}
+
+func splitCiphertext(encryptedText string) (int, string, error) {
+	// ':' is not in the base64 alphabet, so legacy ciphertexts never match
+	prefix, payload, found := strings.Cut(encryptedText, ":")
+	if !found {
+		return CipherLegacy, encryptedText, nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimPrefix(prefix, "v"))
+	if err != nil || !strings.HasPrefix(prefix, "v") || version <= CipherLegacy {
+		return 0, "", fmt.Errorf("%w %q", ErrUnsupportedCipher, prefix)
+	}
+	return version, payload, nil
+}
+
+// HashKey returns the verifier older vaults stored for a password-derived
+// key: a plain hash of the key that also wraps the vault key.
+func HashKey(key []byte) string {
 	hash := sha256.Sum256(key)
 	return base64.StdEncoding.EncodeToString(hash[:])
 }
 
-func GeneratePassword(length int, includeSymbols bool) (string, error) {
-	const (
-		lowercase = "abcdefghijklmnopqrstuvwxyz"
-		uppercase = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-		digits    = "0123456789"
-		symbols   = "!@#$%^&*()_+-=[]{}|;:,.<>?"
-	)
+// Password-derived keys are split with HKDF into a key that wraps the vault
+// key and a verifier that is stored, so the stored value reveals nothing
+// about the wrapping key.
+const verifierPrefix = "hkdf-sha256$"
+
+// KeyVerifier returns the value to store for checking a password-derived key.
+func KeyVerifier(key []byte) (string, error) {
+	verifier, err := hkdf.Key(sha256.New, key, nil, "passmanager password verifier", argonKeyLen)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive verifier: %w", err)
+	}
+	return verifierPrefix + base64.StdEncoding.EncodeToString(verifier), nil
+}
+
+// WrappingKey returns the subkey of a password-derived key that wraps the
+// vault key.
+func WrappingKey(key []byte) (*CryptoService, error) {
+	wrapKey, err := hkdf.Key(sha256.New, key, nil, "passmanager key wrapping", argonKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	return newKeyService(wrapKey)
+}
 
-	charset := lowercase + uppercase + digits
-	if includeSymbols {
-		charset += symbols
+// FileKey returns the subkey of a password-derived key that encrypts the
+// local vault file.
+func FileKey(key []byte) (*CryptoService, error) {
+	fileKey, err := hkdf.Key(sha256.New, key, nil, "passmanager local file", argonKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive file key: %w", err)
 	}
+	return newKeyService(fileKey)
+}
 
-	password := make([]byte, length)
-	charsetLen := len(charset)
+// LegacyVerifier reports whether stored was written by HashKey rather than
+// KeyVerifier.
+func LegacyVerifier(stored string) bool {
+	return !strings.HasPrefix(stored, verifierPrefix)
+}
 
-	for i := range password {
-		randomByte := make([]byte, 1)
-		if _, err := rand.Read(randomByte); err != nil {
-			return "", err
+// VerifyKey checks key against either kind of stored verifier in constant
+// time.
+func VerifyKey(key []byte, stored string) bool {
+	expected := HashKey(key)
+	if !LegacyVerifier(stored) {
+		var err error
+		if expected, err = KeyVerifier(key); err != nil {
+			return false
 		}
-		password[i] = charset[int(randomByte[0])%charsetLen]
 	}
-
-	return string(password), nil
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(stored)) == 1
 }
 
+// SecureClear wipes and releases the key. The service is unusable after.
 func (c *CryptoService) SecureClear() {
-	for i := range c.masterKey {
-		c.masterKey[i] = 0
+	c.masterKey.Destroy()
+}
+
+func clearBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
 	}
 }
\ No newline at end of file
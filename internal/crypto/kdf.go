@@ -0,0 +1,178 @@
+// internal/crypto/kdf.go
+package crypto
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const KDFArgon2id = "argon2id"
+
+// Stored parameters come from the backend, so they are capped: whoever can
+// edit the vault config must not be able to make every unlock run out of
+// memory or never finish.
+const (
+	maxKDFMemory  = 1024 * 1024 // KiB
+	maxKDFTime    = 64
+	maxKDFThreads = 16
+	maxKDFCost    = 4 * 1024 * 1024 // passes times KiB
+)
+
+// KDFParams says how a password is turned into a key. It is stored with the
+// vault in a PHC-like form, e.g. "argon2id$v=19$m=65536,t=3,p=4".
+type KDFParams struct {
+	Algorithm string
+	Time      uint32
+	Memory    uint32 // KiB
+	Threads   uint8
+}
+
+// DefaultKDF is used for new vaults unless they are calibrated, and for
+// vaults whose own parameters are outdated.
+func DefaultKDF() KDFParams {
+	return KDFParams{Algorithm: KDFArgon2id, Time: argonTime, Memory: argonMemory, Threads: argonThreads}
+}
+
+// LegacyKDF is what vaults used before the parameters were stored.
+func LegacyKDF() KDFParams {
+	return KDFParams{Algorithm: KDFArgon2id, Time: 3, Memory: 64 * 1024, Threads: 4}
+}
+
+// ParseKDF reads parameters written by KDFParams.String. An empty string
+// means the vault predates stored parameters.
+func ParseKDF(s string) (KDFParams, error) {
+	if s == "" {
+		return LegacyKDF(), nil
+	}
+
+	var version int
+	p := KDFParams{Algorithm: KDFArgon2id}
+	_, err := fmt.Sscanf(s, KDFArgon2id+"$v=%d$m=%d,t=%d,p=%d", &version, &p.Memory, &p.Time, &p.Threads)
+	// Sscanf stops at the last verb, so anything after it is caught by
+	// writing the parameters out again
+	if err != nil || version != argon2.Version || p.String() != s {
+		return KDFParams{}, fmt.Errorf("unsupported key derivation %q", s)
+	}
+	if err := p.validate(); err != nil {
+		return KDFParams{}, err
+	}
+
+	return p, nil
+}
+
+func (p KDFParams) String() string {
+	return fmt.Sprintf("%s$v=%d$m=%d,t=%d,p=%d", p.Algorithm, argon2.Version, p.Memory, p.Time, p.Threads)
+}
+
+// Summary describes p for people, e.g. "Argon2id, 64 MiB, 3 passes, 4 threads".
+func (p KDFParams) Summary() string {
+	memory := fmt.Sprintf("%d KiB", p.Memory)
+	if p.Memory%1024 == 0 {
+		memory = fmt.Sprintf("%d MiB", p.Memory/1024)
+	}
+	return fmt.Sprintf("Argon2id, %s, %s, %s", memory, plural(int(p.Time), "pass", "passes"), plural(int(p.Threads), "thread", "threads"))
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return "1 " + one
+	}
+	return fmt.Sprintf("%d %s", n, many)
+}
+
+// Outdated reports whether p costs less than the minimum every vault must
+// meet, so vaults using it should be moved to the defaults at the next
+// unlock. Calibrated parameters may use less memory than DefaultKDF on
+// purpose and are not outdated for that.
+func (p KDFParams) Outdated() bool {
+	return p.Algorithm != KDFArgon2id || p.Memory < minKDFMemory || uint64(p.Time)*uint64(p.Memory) < minKDFCost
+}
+
+// DeriveKey runs the KDF over password and salt.
+func (p KDFParams) DeriveKey(password []byte, salt []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, argonKeyLen), nil
+}
+
+// NewCryptoService derives a key from password and salt.
+func (p KDFParams) NewCryptoService(password []byte, salt []byte) (*CryptoService, error) {
+	key, err := p.DeriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	return newKeyService(key)
+}
+
+// DerivedKey is a password run through the KDF with a given salt. The keys
+// a vault needs from its password are all subkeys of it, so one derivation
+// serves a whole unlock.
+type DerivedKey struct {
+	Salt []byte
+	KDF  string // as stored, "" for LegacyKDF
+	key  *SecureBuffer
+}
+
+// NewDerivedKey derives a key from password, salt and the stored KDF
+// parameters. The caller must Destroy it.
+func NewDerivedKey(password, salt []byte, kdf string) (*DerivedKey, error) {
+	params, err := ParseKDF(kdf)
+	if err != nil {
+		return nil, err
+	}
+	derived, err := params.DeriveKey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+	key, err := SecureBytes(derived)
+	if err != nil {
+		return nil, err
+	}
+	return &DerivedKey{Salt: append([]byte(nil), salt...), KDF: kdf, key: key}, nil
+}
+
+// Matches reports whether k was derived with the base64 salt and KDF
+// parameters stored as salt and kdf.
+func (k *DerivedKey) Matches(salt, kdf string) bool {
+	return k.KDF == kdf && base64.StdEncoding.EncodeToString(k.Salt) == salt
+}
+
+// Use calls fn with the key itself.
+func (k *DerivedKey) Use(fn func(key []byte) error) error {
+	return k.key.Use(fn)
+}
+
+// FileKey returns the subkey that encrypts the local vault file.
+func (k *DerivedKey) FileKey() (*CryptoService, error) {
+	var fileKey *CryptoService
+	err := k.Use(func(key []byte) error {
+		var err error
+		fileKey, err = FileKey(key)
+		return err
+	})
+	return fileKey, err
+}
+
+// Destroy wipes the key. It is safe to call on nil.
+func (k *DerivedKey) Destroy() {
+	if k != nil {
+		k.key.Destroy()
+	}
+}
+
+func (p KDFParams) validate() error {
+	if p.Algorithm != KDFArgon2id {
+		return fmt.Errorf("unsupported key derivation algorithm %q", p.Algorithm)
+	}
+	if p.Time == 0 || p.Memory < 8*uint32(p.Threads) || p.Threads == 0 {
+		return fmt.Errorf("invalid key derivation parameters %s", p)
+	}
+	if p.Time > maxKDFTime || p.Memory > maxKDFMemory || p.Threads > maxKDFThreads ||
+		uint64(p.Time)*uint64(p.Memory) > maxKDFCost {
+		return fmt.Errorf("key derivation parameters %s exceed the limits", p)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// internal/crypto/keyfile.go
+package crypto
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Key files are hashed, so any file will do; generated ones hold this many
+// random bytes.
+const keyFileSize = 64
+
+var (
+	ErrKeyFileMissing = errors.New("key file not found")
+	ErrKeyFileEmpty   = errors.New("key file is empty")
+)
+
+// KeyFileHash returns the SHA-256 of the file at path. The caller must
+// Destroy the result.
+func KeyFileHash(path string) (*SecureBuffer, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrKeyFileMissing, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key file: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrKeyFileEmpty, path)
+	}
+	return SecureBytes(h.Sum(nil))
+}
+
+// GenerateKeyFile writes a new key file of random bytes to path. It never
+// overwrites an existing file.
+func GenerateKeyFile(path string) error {
+	content := make([]byte, keyFileSize)
+	defer clearBytes(content)
+	if _, err := rand.Read(content); err != nil {
+		return fmt.Errorf("failed to generate key file: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		os.Remove(path)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+// internal/crypto/passphrase.go
+package crypto
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The EFF large word list for five dice: 7776 words, one per line after the
+// dice roll that selects it.
+//
+//go:embed eff_large_wordlist.txt
+var effLargeWordList string
+
+const maxPassphraseWords = 64
+
+var (
+	wordsOnce sync.Once
+	wordList  []string
+)
+
+func words() []string {
+	wordsOnce.Do(func() {
+		for _, line := range strings.Split(strings.TrimSpace(effLargeWordList), "\n") {
+			if _, word, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok {
+				wordList = append(wordList, word)
+			}
+		}
+	})
+	return wordList
+}
+
+// PassphrasePolicy describes the passphrases GeneratePassphrase produces.
+type PassphrasePolicy struct {
+	Words      int    `json:"words"`
+	Separator  string `json:"separator"`
+	Capitalize bool   `json:"capitalize"`
+	AddDigit   bool   `json:"add_digit"` // appended to one random word
+}
+
+// DefaultPassphrasePolicy gives about 77 bits of entropy.
+func DefaultPassphrasePolicy() PassphrasePolicy {
+	return PassphrasePolicy{Words: 6, Separator: "-"}
+}
+
+func (p PassphrasePolicy) Validate() error {
+	if p.Words < 1 || p.Words > maxPassphraseWords {
+		return fmt.Errorf("word count must be between 1 and %d", maxPassphraseWords)
+	}
+	if len(words()) == 0 {
+		return errors.New("word list is empty")
+	}
+	return nil
+}
+
+// Entropy returns the strength of passphrases from p in bits, assuming the
+// attacker knows the word list and the policy.
+func (p PassphrasePolicy) Entropy() float64 {
+	bits := float64(p.Words) * math.Log2(float64(len(words())))
+	if p.AddDigit {
+		// Which digit, and which word it follows
+		bits += math.Log2(10 * float64(p.Words))
+	}
+	return bits
+}
+
+// GeneratePassphrase picks p.Words words uniformly from the EFF large word
+// list.
+func GeneratePassphrase(p PassphrasePolicy) (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", err
+	}
+
+	list := words()
+	chosen := make([]string, p.Words)
+	for i := range chosen {
+		n, err := randomIndex(len(list))
+		if err != nil {
+			return "", err
+		}
+		chosen[i] = list[n]
+		if p.Capitalize {
+			chosen[i] = capitalize(chosen[i])
+		}
+	}
+
+	if p.AddDigit {
+		word, err := randomIndex(len(chosen))
+		if err != nil {
+			return "", err
+		}
+		digit, err := randomIndex(10)
+		if err != nil {
+			return "", err
+		}
+		chosen[word] += strconv.Itoa(digit)
+	}
+
+	return strings.Join(chosen, p.Separator), nil
+}
+
+func capitalize(word string) string {
+	r, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToUpper(r)) + word[size:]
+}
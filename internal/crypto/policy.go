@@ -0,0 +1,263 @@
+// internal/crypto/policy.go
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	LowercaseChars = "abcdefghijklmnopqrstuvwxyz"
+	UppercaseChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	DigitChars     = "0123456789"
+	DefaultSymbols = "!@#$%^&*()_+-=[]{}|;:,.<>?"
+
+	// AmbiguousChars are easily confused when read or typed by hand.
+	AmbiguousChars = "0Oo1lI|"
+
+	maxPasswordLength = 1024
+	maxPolicyAttempts = 1000
+)
+
+var ErrPolicyTooStrict = errors.New("password policy cannot be satisfied")
+
+// PasswordPolicy describes the passwords GeneratePassword produces.
+type PasswordPolicy struct {
+	Length    int  `json:"length"`
+	Lowercase bool `json:"lowercase"`
+	Uppercase bool `json:"uppercase"`
+	Digits    bool `json:"digits"`
+	Symbols   bool `json:"symbols"`
+
+	// Minimum number of characters from each enabled class
+	MinLowercase int `json:"min_lowercase"`
+	MinUppercase int `json:"min_uppercase"`
+	MinDigits    int `json:"min_digits"`
+	MinSymbols   int `json:"min_symbols"`
+
+	SymbolSet        string `json:"symbol_set,omitempty"` // empty means DefaultSymbols
+	Exclude          string `json:"exclude,omitempty"`
+	ExcludeAmbiguous bool   `json:"exclude_ambiguous"`
+
+	// Most times the same character may appear in a row; 0 means no limit
+	MaxRepeat int `json:"max_repeat"`
+}
+
+// DefaultPolicy uses every class at least once.
+func DefaultPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		Length:       20,
+		Lowercase:    true,
+		Uppercase:    true,
+		Digits:       true,
+		Symbols:      true,
+		MinLowercase: 1,
+		MinUppercase: 1,
+		MinDigits:    1,
+		MinSymbols:   1,
+	}
+}
+
+type charClass struct {
+	name  string
+	chars string
+	min   int
+}
+
+// classes returns the enabled character classes with excluded characters
+// already removed.
+func (p PasswordPolicy) classes() []charClass {
+	symbols := p.SymbolSet
+	if symbols == "" {
+		symbols = DefaultSymbols
+	}
+
+	all := []struct {
+		charClass
+		enabled bool
+	}{
+		{charClass{"lowercase", LowercaseChars, p.MinLowercase}, p.Lowercase},
+		{charClass{"uppercase", UppercaseChars, p.MinUppercase}, p.Uppercase},
+		{charClass{"digits", DigitChars, p.MinDigits}, p.Digits},
+		{charClass{"symbols", symbols, p.MinSymbols}, p.Symbols},
+	}
+
+	var classes []charClass
+	for _, c := range all {
+		if c.enabled {
+			c.chars = p.filter(c.chars)
+			classes = append(classes, c.charClass)
+		}
+	}
+	return classes
+}
+
+// filter drops excluded and duplicate characters.
+func (p PasswordPolicy) filter(chars string) string {
+	var b strings.Builder
+	for _, r := range chars {
+		if strings.ContainsRune(p.Exclude, r) ||
+			(p.ExcludeAmbiguous && strings.ContainsRune(AmbiguousChars, r)) ||
+			strings.ContainsRune(b.String(), r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Alphabet returns every character the policy may use.
+func (p PasswordPolicy) Alphabet() string {
+	var b strings.Builder
+	for _, c := range p.classes() {
+		b.WriteString(c.chars)
+	}
+	return p.filter(b.String())
+}
+
+// Validate reports why no password could satisfy p.
+func (p PasswordPolicy) Validate() error {
+	if p.Length < 1 || p.Length > maxPasswordLength {
+		return fmt.Errorf("password length must be between 1 and %d", maxPasswordLength)
+	}
+	if p.MaxRepeat < 0 {
+		return errors.New("max repeat cannot be negative")
+	}
+
+	classes := p.classes()
+	if len(classes) == 0 {
+		return errors.New("password policy enables no character classes")
+	}
+
+	required := 0
+	for _, c := range classes {
+		if c.chars == "" {
+			return fmt.Errorf("all %s are excluded", c.name)
+		}
+		if c.min < 0 {
+			return fmt.Errorf("minimum %s cannot be negative", c.name)
+		}
+		required += c.min
+	}
+	if required > p.Length {
+		return fmt.Errorf("minimum character counts add up to %d, more than the length %d", required, p.Length)
+	}
+
+	if p.MaxRepeat == 1 && p.Length > 1 && len([]rune(p.Alphabet())) < 2 {
+		return ErrPolicyTooStrict
+	}
+	return nil
+}
+
+// String summarises the policy for display.
+func (p PasswordPolicy) String() string {
+	var names []string
+	for _, c := range p.classes() {
+		if c.min > 0 {
+			names = append(names, fmt.Sprintf("%s (min %d)", c.name, c.min))
+		} else {
+			names = append(names, c.name)
+		}
+	}
+
+	s := fmt.Sprintf("%d chars, %s", p.Length, strings.Join(names, ", "))
+	if p.ExcludeAmbiguous {
+		s += ", no ambiguous"
+	}
+	if p.Exclude != "" {
+		s += fmt.Sprintf(", excluding %q", p.Exclude)
+	}
+	if p.MaxRepeat > 0 {
+		s += fmt.Sprintf(", max %d in a row", p.MaxRepeat)
+	}
+	return s
+}
+
+// GeneratePassword returns a random password satisfying policy. Every
+// character is drawn uniformly from its set.
+func GeneratePassword(policy PasswordPolicy) (string, error) {
+	if err := policy.Validate(); err != nil {
+		return "", err
+	}
+
+	classes := policy.classes()
+	alphabet := []rune(policy.Alphabet())
+
+	// Passwords breaking the repeat limit are drawn again, which keeps the
+	// remaining ones uniformly likely
+	for attempt := 0; attempt < maxPolicyAttempts; attempt++ {
+		password := make([]rune, 0, policy.Length)
+		for _, c := range classes {
+			chars := []rune(c.chars)
+			for i := 0; i < c.min; i++ {
+				r, err := pick(chars)
+				if err != nil {
+					return "", err
+				}
+				password = append(password, r)
+			}
+		}
+		for len(password) < policy.Length {
+			r, err := pick(alphabet)
+			if err != nil {
+				return "", err
+			}
+			password = append(password, r)
+		}
+
+		if err := shuffle(password); err != nil {
+			return "", err
+		}
+		if policy.MaxRepeat == 0 || maxRun(password) <= policy.MaxRepeat {
+			return string(password), nil
+		}
+	}
+
+	return "", ErrPolicyTooStrict
+}
+
+// randomIndex returns a uniformly random integer in [0, n).
+func randomIndex(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+func pick(chars []rune) (rune, error) {
+	i, err := randomIndex(len(chars))
+	if err != nil {
+		return 0, err
+	}
+	return chars[i], nil
+}
+
+// shuffle is a Fisher-Yates shuffle, so the required characters can end up
+// anywhere.
+func shuffle(s []rune) error {
+	for i := len(s) - 1; i > 0; i-- {
+		j, err := randomIndex(i + 1)
+		if err != nil {
+			return err
+		}
+		s[i], s[j] = s[j], s[i]
+	}
+	return nil
+}
+
+func maxRun(s []rune) int {
+	longest, run := 0, 0
+	for i := range s {
+		if i > 0 && s[i] == s[i-1] {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
@@ -0,0 +1,153 @@
+// internal/crypto/policy_test.go
+package crypto
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func countIn(password, chars string) int {
+	n := 0
+	for _, r := range password {
+		if strings.ContainsRune(chars, r) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestGeneratePasswordMinimums(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PasswordPolicy
+	}{
+		{"default", DefaultPolicy()},
+		{"minimums fill the length", PasswordPolicy{
+			Length: 8, Lowercase: true, Uppercase: true, Digits: true, Symbols: true,
+			MinLowercase: 2, MinUppercase: 2, MinDigits: 2, MinSymbols: 2,
+		}},
+		{"digits only", PasswordPolicy{Length: 6, Digits: true, MinDigits: 6}},
+		{"custom symbols", PasswordPolicy{
+			Length: 12, Lowercase: true, Symbols: true, MinSymbols: 5, SymbolSet: "-_",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			symbols := tt.policy.SymbolSet
+			if symbols == "" {
+				symbols = DefaultSymbols
+			}
+			for range 50 {
+				password, err := GeneratePassword(tt.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n := len([]rune(password)); n != tt.policy.Length {
+					t.Fatalf("%q has %d characters, want %d", password, n, tt.policy.Length)
+				}
+				if n := countIn(password, LowercaseChars); n < tt.policy.MinLowercase {
+					t.Errorf("%q has %d lowercase, want at least %d", password, n, tt.policy.MinLowercase)
+				}
+				if n := countIn(password, UppercaseChars); n < tt.policy.MinUppercase {
+					t.Errorf("%q has %d uppercase, want at least %d", password, n, tt.policy.MinUppercase)
+				}
+				if n := countIn(password, DigitChars); n < tt.policy.MinDigits {
+					t.Errorf("%q has %d digits, want at least %d", password, n, tt.policy.MinDigits)
+				}
+				if n := countIn(password, symbols); n < tt.policy.MinSymbols {
+					t.Errorf("%q has %d symbols, want at least %d", password, n, tt.policy.MinSymbols)
+				}
+				if n := countIn(password, tt.policy.Alphabet()); n != tt.policy.Length {
+					t.Errorf("%q uses characters outside %q", password, tt.policy.Alphabet())
+				}
+			}
+		})
+	}
+}
+
+func TestGeneratePasswordExclusions(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Length = 64
+	policy.ExcludeAmbiguous = true
+	policy.Exclude = "abc!"
+
+	for range 50 {
+		password, err := GeneratePassword(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.ContainsAny(password, AmbiguousChars) {
+			t.Errorf("%q contains an ambiguous character", password)
+		}
+		if strings.ContainsAny(password, policy.Exclude) {
+			t.Errorf("%q contains an excluded character", password)
+		}
+	}
+}
+
+func TestGeneratePasswordMaxRepeat(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy PasswordPolicy
+	}{
+		{"no repeats", PasswordPolicy{Length: 30, Digits: true, MaxRepeat: 1}},
+		{"two in a row", PasswordPolicy{Length: 40, Digits: true, MaxRepeat: 2}},
+		{"two characters", PasswordPolicy{Length: 6, Digits: true, Exclude: "23456789", MaxRepeat: 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 50 {
+				password, err := GeneratePassword(tt.policy)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if run := maxRun([]rune(password)); run > tt.policy.MaxRepeat {
+					t.Fatalf("%q repeats a character %d times in a row, limit %d", password, run, tt.policy.MaxRepeat)
+				}
+			}
+		})
+	}
+}
+
+func TestPolicyInfeasible(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     PasswordPolicy
+		tooStrict  bool
+		wantSubstr string
+	}{
+		{"zero length", PasswordPolicy{Length: 0, Digits: true}, false, "length"},
+		{"too long", PasswordPolicy{Length: maxPasswordLength + 1, Digits: true}, false, "length"},
+		{"no classes", PasswordPolicy{Length: 10}, false, "no character classes"},
+		{"class fully excluded", PasswordPolicy{Length: 10, Digits: true, Exclude: DigitChars}, false, "all digits are excluded"},
+		{"minimums exceed length", PasswordPolicy{
+			Length: 3, Lowercase: true, Digits: true, MinLowercase: 2, MinDigits: 2,
+		}, false, "add up to 4"},
+		{"negative minimum", PasswordPolicy{Length: 5, Digits: true, MinDigits: -1}, false, "negative"},
+		{"negative repeat", PasswordPolicy{Length: 5, Digits: true, MaxRepeat: -1}, false, "negative"},
+		{"one character, no repeats", PasswordPolicy{
+			Length: 4, Digits: true, Exclude: "123456789", MaxRepeat: 1,
+		}, true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if err == nil {
+				t.Fatal("Validate accepted an infeasible policy")
+			}
+			if tt.tooStrict && !errors.Is(err, ErrPolicyTooStrict) {
+				t.Errorf("Validate() = %v, want ErrPolicyTooStrict", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantSubstr) {
+				t.Errorf("Validate() = %q, want it to mention %q", err, tt.wantSubstr)
+			}
+			if _, genErr := GeneratePassword(tt.policy); genErr == nil {
+				t.Error("GeneratePassword succeeded for an infeasible policy")
+			}
+		})
+	}
+}
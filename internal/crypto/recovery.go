@@ -0,0 +1,144 @@
+// internal/crypto/recovery.go
+package crypto
+
+import (
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A recovery key is a random secret kept offline. Keys are wrapped for it
+// with X25519: the public half, stored with the vault, is enough to wrap,
+// so the password can change and the vault key can rotate while the
+// recovery key stays put. Only the secret half unwraps.
+const RecoveryKeySize = 32
+
+const recoveryPrefix = "x25519$"
+
+var ErrInvalidRecoveryKey = errors.New("invalid recovery key")
+
+// GenerateRecoveryKey returns a new random recovery key. The caller must
+// Destroy it.
+func GenerateRecoveryKey() (*SecureBuffer, error) {
+	key, err := NewSecureBuffer(RecoveryKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(key.Bytes()); err != nil {
+		key.Destroy()
+		return nil, fmt.Errorf("failed to generate recovery key: %w", err)
+	}
+	return key, nil
+}
+
+// RecoveryPublicKey returns the public half of a recovery key, which is
+// what RecoveryWrap needs.
+func RecoveryPublicKey(recoveryKey []byte) (string, error) {
+	private, err := recoveryPrivateKey(recoveryKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(private.PublicKey().Bytes()), nil
+}
+
+func recoveryPrivateKey(recoveryKey []byte) (*ecdh.PrivateKey, error) {
+	if len(recoveryKey) != RecoveryKeySize {
+		return nil, ErrInvalidRecoveryKey
+	}
+	scalar, err := hkdf.Key(sha256.New, recoveryKey, nil, "passmanager recovery key", 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive recovery key: %w", err)
+	}
+	defer clearBytes(scalar)
+	return ecdh.X25519().NewPrivateKey(scalar)
+}
+
+// RecoveryWrap encrypts key so that the recovery key behind publicKey
+// unwraps it.
+func RecoveryWrap(publicKey string, key *CryptoService) (string, error) {
+	recipient, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("corrupt recovery public key: %w", err)
+	}
+	public, err := ecdh.X25519().NewPublicKey(recipient)
+	if err != nil {
+		return "", fmt.Errorf("corrupt recovery public key: %w", err)
+	}
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	shared, err := ephemeral.ECDH(public)
+	if err != nil {
+		return "", err
+	}
+
+	sender := ephemeral.PublicKey().Bytes()
+	wrapKey, err := recoveryWrappingKey(shared, sender, recipient)
+	if err != nil {
+		return "", err
+	}
+	defer wrapKey.SecureClear()
+
+	wrapped, err := wrapKey.WrapKey(key)
+	if err != nil {
+		return "", err
+	}
+	return recoveryPrefix + base64.StdEncoding.EncodeToString(sender) + "$" + wrapped, nil
+}
+
+// RecoveryUnwrap reverses RecoveryWrap with the recovery key.
+func RecoveryUnwrap(recoveryKey []byte, wrapped string) (*CryptoService, error) {
+	rest, ok := strings.CutPrefix(wrapped, recoveryPrefix)
+	encoded, sealed, found := strings.Cut(rest, "$")
+	if !ok || !found {
+		return nil, fmt.Errorf("%w %q", ErrUnsupportedCipher, wrapped[:min(len(wrapped), len(recoveryPrefix))])
+	}
+	sender, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt recovery data: %w", err)
+	}
+	public, err := ecdh.X25519().NewPublicKey(sender)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt recovery data: %w", err)
+	}
+
+	private, err := recoveryPrivateKey(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := private.ECDH(public)
+	if err != nil {
+		return nil, ErrInvalidRecoveryKey
+	}
+
+	wrapKey, err := recoveryWrappingKey(shared, sender, private.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer wrapKey.SecureClear()
+
+	key, err := wrapKey.UnwrapKey(sealed)
+	if err != nil {
+		return nil, ErrInvalidRecoveryKey
+	}
+	return key, nil
+}
+
+// recoveryWrappingKey derives the wrapping key from an X25519 shared secret,
+// bound to both public keys. shared is wiped.
+func recoveryWrappingKey(shared, sender, recipient []byte) (*CryptoService, error) {
+	defer clearBytes(shared)
+	key, err := hkdf.Key(sha256.New, shared, slices.Concat(sender, recipient), "passmanager recovery wrapping", argonKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	return newKeyService(key)
+}
@@ -0,0 +1,165 @@
+// internal/crypto/securebuf.go
+package crypto
+
+import (
+	"crypto/subtle"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+var ErrBufferDestroyed = errors.New("secure buffer has been destroyed")
+
+// SecureBuffer holds a secret outside the Go heap, where the garbage
+// collector cannot copy it around. The memory is locked so it is not
+// swapped out, sits between guard pages that fault on any overrun, and is
+// wiped and released by Destroy. If the system refuses to lock more memory
+// the buffer still works, it just may be swapped.
+type SecureBuffer struct {
+	mu        sync.Mutex
+	region    []byte // the whole allocation, including guard pages
+	data      []byte
+	destroyed bool
+}
+
+// NewSecureBuffer returns a zeroed buffer of size bytes.
+func NewSecureBuffer(size int) (*SecureBuffer, error) {
+	if size < 0 {
+		return nil, errors.New("negative secure buffer size")
+	}
+	region, data, err := allocLocked(size)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &SecureBuffer{region: region, data: data}
+	// Only a safety net; callers are expected to Destroy what they create
+	runtime.SetFinalizer(b, (*SecureBuffer).Destroy)
+	return b, nil
+}
+
+// SecureBytes moves secret into a new buffer and wipes secret.
+func SecureBytes(secret []byte) (*SecureBuffer, error) {
+	b, err := NewSecureBuffer(len(secret))
+	if err != nil {
+		clearBytes(secret)
+		return nil, err
+	}
+	copy(b.data, secret)
+	clearBytes(secret)
+	return b, nil
+}
+
+// SecureString copies s into a new buffer. s itself cannot be wiped, so
+// use SecureBytes wherever the secret is available as bytes.
+func SecureString(s string) (*SecureBuffer, error) {
+	b, err := NewSecureBuffer(len(s))
+	if err != nil {
+		return nil, err
+	}
+	copy(b.data, s)
+	return b, nil
+}
+
+// Bytes returns the secret in place. It is only valid until Destroy, and
+// nil afterwards, so keep it to buffers the caller destroys itself. A
+// buffer that another goroutine may destroy, such as one tracked by the
+// session, must be read through Use.
+func (b *SecureBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.data
+}
+
+func (b *SecureBuffer) Len() int {
+	n := 0
+	b.Use(func(secret []byte) error {
+		n = len(secret)
+		return nil
+	})
+	return n
+}
+
+// Use calls fn with the secret, and keeps Destroy from freeing it until fn
+// returns.
+func (b *SecureBuffer) Use(fn func(secret []byte) error) error {
+	if b == nil {
+		return ErrBufferDestroyed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.destroyed {
+		return ErrBufferDestroyed
+	}
+	return fn(b.data)
+}
+
+// Clone copies the secret into an independent buffer.
+func (b *SecureBuffer) Clone() (*SecureBuffer, error) {
+	var clone *SecureBuffer
+	err := b.Use(func(secret []byte) error {
+		var err error
+		clone, err = NewSecureBuffer(len(secret))
+		if err == nil {
+			copy(clone.data, secret)
+		}
+		return err
+	})
+	return clone, err
+}
+
+// Equal compares two buffers in constant time.
+func (b *SecureBuffer) Equal(other *SecureBuffer) bool {
+	if b == other {
+		return !b.Destroyed()
+	}
+	equal := false
+	b.Use(func(secret []byte) error {
+		return other.Use(func(otherSecret []byte) error {
+			equal = subtle.ConstantTimeCompare(secret, otherSecret) == 1
+			return nil
+		})
+	})
+	return equal
+}
+
+// UnsafeString copies the secret into an ordinary string, for APIs that
+// take nothing else. The copy cannot be wiped.
+func (b *SecureBuffer) UnsafeString() string {
+	var s string
+	b.Use(func(secret []byte) error {
+		s = string(secret)
+		return nil
+	})
+	return s
+}
+
+func (b *SecureBuffer) Destroyed() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.destroyed
+}
+
+// Destroy wipes the secret and releases its memory. It is safe to call more
+// than once.
+func (b *SecureBuffer) Destroy() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.destroyed {
+		return
+	}
+
+	clearBytes(b.data)
+	freeLocked(b.region)
+	b.region, b.data, b.destroyed = nil, nil, true
+	runtime.SetFinalizer(b, nil)
+}
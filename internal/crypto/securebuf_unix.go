@@ -0,0 +1,45 @@
+// internal/crypto/securebuf_unix.go
+//go:build !windows
+
+package crypto
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocLocked maps the secret's pages with an inaccessible page on either
+// side. The secret ends right at the upper guard page, so writing past it
+// faults at once.
+func allocLocked(size int) (region, data []byte, err error) {
+	page := os.Getpagesize()
+	inner := (max(size, 1) + page - 1) / page * page
+
+	region, err = unix.Mmap(-1, 0, inner+2*page, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANON)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to allocate secure memory: %w", err)
+	}
+
+	if err := unix.Mprotect(region[:page], unix.PROT_NONE); err != nil {
+		unix.Munmap(region)
+		return nil, nil, fmt.Errorf("failed to protect secure memory: %w", err)
+	}
+	if err := unix.Mprotect(region[page+inner:], unix.PROT_NONE); err != nil {
+		unix.Munmap(region)
+		return nil, nil, fmt.Errorf("failed to protect secure memory: %w", err)
+	}
+
+	// Fails once RLIMIT_MEMLOCK is used up; the memory is still guarded
+	// and wiped then, only not kept out of swap
+	unix.Mlock(region[page : page+inner])
+
+	return region, region[page+inner-size : page+inner], nil
+}
+
+func freeLocked(region []byte) {
+	page := os.Getpagesize()
+	unix.Munlock(region[page : len(region)-page])
+	unix.Munmap(region)
+}
@@ -0,0 +1,50 @@
+// internal/crypto/securebuf_windows.go
+//go:build windows
+
+package crypto
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// allocLocked reserves the secret's pages with an inaccessible page on
+// either side. The secret ends right at the upper guard page, so writing
+// past it faults at once.
+func allocLocked(size int) (region, data []byte, err error) {
+	page := os.Getpagesize()
+	inner := (max(size, 1) + page - 1) / page * page
+	total := inner + 2*page
+
+	addr, err := windows.VirtualAlloc(0, uintptr(total), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to allocate secure memory: %w", err)
+	}
+	region = unsafe.Slice(*(**byte)(unsafe.Pointer(&addr)), total)
+
+	var old uint32
+	if err := windows.VirtualProtect(addr, uintptr(page), windows.PAGE_NOACCESS, &old); err != nil {
+		windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+		return nil, nil, fmt.Errorf("failed to protect secure memory: %w", err)
+	}
+	if err := windows.VirtualProtect(addr+uintptr(page+inner), uintptr(page), windows.PAGE_NOACCESS, &old); err != nil {
+		windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+		return nil, nil, fmt.Errorf("failed to protect secure memory: %w", err)
+	}
+
+	// Fails once the working set limit is reached; the memory is still
+	// guarded and wiped then, only not kept out of the page file
+	windows.VirtualLock(addr+uintptr(page), uintptr(inner))
+
+	return region, region[page+inner-size : page+inner], nil
+}
+
+func freeLocked(region []byte) {
+	page := os.Getpagesize()
+	addr := uintptr(unsafe.Pointer(&region[0]))
+	windows.VirtualUnlock(addr+uintptr(page), uintptr(len(region)-2*page))
+	windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+}
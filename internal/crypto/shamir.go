@@ -0,0 +1,126 @@
+// internal/crypto/shamir.go
+package crypto
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+const maxShares = 255
+
+var ErrInvalidShares = errors.New("invalid secret shares")
+
+// SplitSecret splits secret into n shares, any threshold of which rebuild
+// it with CombineShares; fewer reveal nothing about it. This is Shamir's
+// scheme over GF(2^8), one random polynomial per byte. Each share is its
+// x coordinate, 1 to n, followed by one byte per secret byte.
+func SplitSecret(secret []byte, n, threshold int) ([][]byte, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, fmt.Errorf("%w: empty secret", ErrInvalidShares)
+	case threshold < 2:
+		return nil, fmt.Errorf("%w: at least 2 shares must be needed", ErrInvalidShares)
+	case n < threshold:
+		return nil, fmt.Errorf("%w: %d shares cannot meet a threshold of %d", ErrInvalidShares, n, threshold)
+	case n > maxShares:
+		return nil, fmt.Errorf("%w: at most %d shares", ErrInvalidShares, maxShares)
+	}
+
+	// The coefficients are as secret as the secret itself
+	coeffs, err := NewSecureBuffer(threshold - 1)
+	if err != nil {
+		return nil, err
+	}
+	defer coeffs.Destroy()
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, 1+len(secret))
+		shares[i][0] = byte(i + 1)
+	}
+
+	for i, b := range secret {
+		if _, err := rand.Read(coeffs.Bytes()); err != nil {
+			return nil, fmt.Errorf("failed to generate shares: %w", err)
+		}
+		for _, share := range shares {
+			share[1+i] = evalPolynomial(b, coeffs.Bytes(), share[0])
+		}
+	}
+	return shares, nil
+}
+
+// CombineShares rebuilds the secret from at least the threshold number of
+// shares made by SplitSecret. With too few shares, or shares of different
+// splits, the result is simply wrong; callers check it some other way.
+// The caller must Destroy the result.
+func CombineShares(shares [][]byte) (*SecureBuffer, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("%w: at least 2 shares are needed", ErrInvalidShares)
+	}
+
+	size := len(shares[0])
+	seen := make(map[byte]bool, len(shares))
+	for _, share := range shares {
+		if len(share) < 2 || len(share) != size {
+			return nil, fmt.Errorf("%w: shares differ in length", ErrInvalidShares)
+		}
+		if share[0] == 0 || seen[share[0]] {
+			return nil, fmt.Errorf("%w: duplicate share", ErrInvalidShares)
+		}
+		seen[share[0]] = true
+	}
+
+	secret, err := NewSecureBuffer(size - 1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Lagrange interpolation at x = 0. Subtraction is XOR in GF(2^8), so
+	// each basis polynomial is the product of x_m / (x_j ^ x_m).
+	out := secret.Bytes()
+	for j, share := range shares {
+		basis := byte(1)
+		for m, other := range shares {
+			if m != j {
+				basis = gfMul(basis, gfMul(other[0], gfInv(share[0]^other[0])))
+			}
+		}
+		for i := range out {
+			out[i] ^= gfMul(share[1+i], basis)
+		}
+	}
+	return secret, nil
+}
+
+// evalPolynomial returns secret + coeffs[0]*x + coeffs[1]*x^2 + ...
+func evalPolynomial(secret byte, coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coeffs[i]
+	}
+	return gfMul(y, x) ^ secret
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial. It takes the same
+// time for every input, unlike the usual log tables.
+func gfMul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		p ^= -(b & 1) & a
+		a = a<<1 ^ -(a>>7)&0x1b
+		b >>= 1
+	}
+	return p
+}
+
+// gfInv returns a^254, the inverse of a (and 0 for 0).
+func gfInv(a byte) byte {
+	inv, sq := byte(1), a
+	for range 7 {
+		sq = gfMul(sq, sq)
+		inv = gfMul(inv, sq)
+	}
+	return inv
+}
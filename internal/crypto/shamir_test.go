@@ -0,0 +1,102 @@
+// internal/crypto/shamir_test.go
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"testing"
+)
+
+func TestGF256Inverse(t *testing.T) {
+	for a := 1; a < 256; a++ {
+		if got := gfMul(byte(a), gfInv(byte(a))); got != 1 {
+			t.Errorf("%d * inv(%d) = %d, want 1", a, a, got)
+		}
+	}
+}
+
+// subsets returns the shares picked by each bit mask over n shares.
+func subsets(shares [][]byte) map[int][][]byte {
+	picked := map[int][][]byte{}
+	for mask := 1; mask < 1<<len(shares); mask++ {
+		for i, share := range shares {
+			if mask&(1<<i) != 0 {
+				picked[mask] = append(picked[mask], share)
+			}
+		}
+	}
+	return picked
+}
+
+func TestSplitCombine(t *testing.T) {
+	tests := []struct{ n, threshold int }{
+		{2, 2},
+		{3, 2},
+		{5, 3},
+		{6, 6},
+	}
+
+	secret := []byte("correct horse battery staple")
+	for _, tt := range tests {
+		shares, err := SplitSecret(secret, tt.n, tt.threshold)
+		if err != nil {
+			t.Fatalf("SplitSecret(%d, %d): %v", tt.n, tt.threshold, err)
+		}
+		if len(shares) != tt.n {
+			t.Fatalf("SplitSecret(%d, %d) made %d shares", tt.n, tt.threshold, len(shares))
+		}
+
+		for mask, picked := range subsets(shares) {
+			if len(picked) < 2 {
+				continue
+			}
+			got, err := CombineShares(picked)
+			if err != nil {
+				t.Fatalf("%d of %d, shares %b: %v", len(picked), tt.n, mask, err)
+			}
+			same := bytes.Equal(got.Bytes(), secret)
+			got.Destroy()
+
+			if enough := bits.OnesCount(uint(mask)) >= tt.threshold; same != enough {
+				t.Errorf("%d of %d (threshold %d), shares %b: recombined = %v", len(picked), tt.n, tt.threshold, mask, same)
+			}
+		}
+	}
+}
+
+func TestCombineSharesInvalid(t *testing.T) {
+	shares, err := SplitSecret([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string][][]byte{
+		"one share":       shares[:1],
+		"duplicate":       {shares[0], shares[0]},
+		"length mismatch": {shares[0], shares[1][:4]},
+		"zero index":      {shares[0], append([]byte{0}, shares[1][1:]...)},
+	}
+	for name, picked := range tests {
+		if _, err := CombineShares(picked); !errors.Is(err, ErrInvalidShares) {
+			t.Errorf("%s: CombineShares() = %v, want ErrInvalidShares", name, err)
+		}
+	}
+}
+
+func TestSplitSecretInvalid(t *testing.T) {
+	tests := []struct {
+		secret       []byte
+		n, threshold int
+	}{
+		{nil, 3, 2},
+		{[]byte("x"), 3, 1},
+		{[]byte("x"), 2, 3},
+		{[]byte("x"), maxShares + 1, 2},
+	}
+	for _, tt := range tests {
+		if _, err := SplitSecret(tt.secret, tt.n, tt.threshold); !errors.Is(err, ErrInvalidShares) {
+			t.Errorf("SplitSecret(%q, %d, %d) = %v, want ErrInvalidShares", tt.secret, tt.n, tt.threshold, err)
+		}
+	}
+}
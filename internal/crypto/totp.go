@@ -0,0 +1,281 @@
+// internal/crypto/totp.go
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Algorithms allowed by RFC 6238.
+const (
+	TOTPSHA1   = "SHA1"
+	TOTPSHA256 = "SHA256"
+	TOTPSHA512 = "SHA512"
+)
+
+const (
+	defaultTOTPDigits = 6
+	defaultTOTPPeriod = 30
+	maxTOTPPeriod     = 3600
+)
+
+var ErrInvalidTOTP = errors.New("invalid TOTP secret")
+
+// TOTP is a time-based one-time password generator, as set up by an
+// authenticator app.
+type TOTP struct {
+	Secret    []byte
+	Algorithm string
+	Digits    int
+	Period    int
+	Issuer    string
+	Account   string
+}
+
+var totpBase32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// ParseTOTP accepts an otpauth://totp/ URI or a bare base32 secret. Bare
+// secrets get the defaults every authenticator uses: SHA1, 6 digits, 30s.
+func ParseTOTP(input string) (*TOTP, error) {
+	return ParseTOTPBytes([]byte(input))
+}
+
+// ParseTOTPBytes is ParseTOTP for input held as bytes, such as a decrypted
+// URI. The secret is decoded from input directly and never becomes a
+// string; only the rest of the URI does.
+func ParseTOTPBytes(input []byte) (*TOTP, error) {
+	input = bytes.TrimSpace(input)
+	if len(input) == 0 {
+		return nil, fmt.Errorf("%w: empty", ErrInvalidTOTP)
+	}
+
+	if !bytes.HasPrefix(bytes.ToLower(input[:min(len(input), len("otpauth:"))]), []byte("otpauth:")) {
+		secret, err := decodeTOTPSecret(input)
+		if err != nil {
+			return nil, err
+		}
+		return &TOTP{Secret: secret, Algorithm: TOTPSHA1, Digits: defaultTOTPDigits, Period: defaultTOTPPeriod}, nil
+	}
+
+	rest, encoded := cutSecretParam(input)
+	u, err := url.Parse(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidTOTP, err)
+	}
+	if !strings.EqualFold(u.Host, "totp") {
+		return nil, fmt.Errorf("%w: only otpauth://totp/ URIs are supported", ErrInvalidTOTP)
+	}
+
+	secret, err := decodeTOTPSecret(encoded)
+	if err != nil {
+		return nil, err
+	}
+	t := &TOTP{Secret: secret, Algorithm: TOTPSHA1, Digits: defaultTOTPDigits, Period: defaultTOTPPeriod}
+
+	// The label is "Issuer:account", where both parts are optional
+	q := u.Query()
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		t.Issuer, t.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		t.Account = strings.TrimSpace(label)
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		t.Issuer = issuer
+	}
+
+	if algorithm := q.Get("algorithm"); algorithm != "" {
+		t.Algorithm = strings.ToUpper(strings.ReplaceAll(algorithm, "-", ""))
+	}
+	if digits := q.Get("digits"); digits != "" {
+		if t.Digits, err = strconv.Atoi(digits); err != nil {
+			return nil, fmt.Errorf("%w: digits %q", ErrInvalidTOTP, digits)
+		}
+	}
+	if period := q.Get("period"); period != "" {
+		if t.Period, err = strconv.Atoi(period); err != nil {
+			return nil, fmt.Errorf("%w: period %q", ErrInvalidTOTP, period)
+		}
+	}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// cutSecretParam splits the secret parameter off an otpauth URI. rest is
+// the URI without it, and secret still query-escaped; both are empty when
+// the URI has no secret. A fragment is left in rest, so the secret cannot
+// be taken from it.
+func cutSecretParam(uri []byte) (rest string, secret []byte) {
+	base, query, ok := bytes.Cut(uri, []byte("?"))
+	if !ok {
+		return string(uri), nil
+	}
+	query, fragment, hasFragment := bytes.Cut(query, []byte("#"))
+
+	var kept [][]byte
+	for _, param := range bytes.Split(query, []byte("&")) {
+		if value, ok := bytes.CutPrefix(param, []byte("secret=")); ok {
+			if secret == nil {
+				secret = value
+			}
+			continue
+		}
+		kept = append(kept, param)
+	}
+
+	rest = string(base) + "?" + string(bytes.Join(kept, []byte("&")))
+	if hasFragment {
+		rest += "#" + string(fragment)
+	}
+	return rest, secret
+}
+
+// decodeTOTPSecret reads base32 the way people copy it: any case, with
+// spaces or dashes between groups and with or without padding. Query
+// escapes are undone as well, for secrets taken from a URI.
+func decodeTOTPSecret(s []byte) ([]byte, error) {
+	clean := make([]byte, 0, len(s))
+	defer func() { clearBytes(clean) }()
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '%' && i+2 < len(s) {
+			hi, okHi := unhex(s[i+1])
+			lo, okLo := unhex(s[i+2])
+			if okHi && okLo {
+				c = hi<<4 | lo
+				i += 2
+			}
+		}
+		switch {
+		case c == ' ' || c == '+' || c == '-' || c == '=':
+			continue
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 'A'
+		}
+		clean = append(clean, c)
+	}
+	if len(clean) == 0 {
+		return nil, fmt.Errorf("%w: no secret", ErrInvalidTOTP)
+	}
+
+	secret := make([]byte, totpBase32.DecodedLen(len(clean)))
+	n, err := totpBase32.Decode(secret, clean)
+	if err != nil {
+		clearBytes(secret)
+		return nil, fmt.Errorf("%w: secret is not base32", ErrInvalidTOTP)
+	}
+	if n < 10 {
+		clearBytes(secret)
+		return nil, fmt.Errorf("%w: secret is too short", ErrInvalidTOTP)
+	}
+	return secret[:n], nil
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// Wipe clears the secret. t cannot generate codes afterwards.
+func (t *TOTP) Wipe() {
+	clearBytes(t.Secret)
+	t.Secret = nil
+}
+
+func (t *TOTP) Validate() error {
+	if len(t.Secret) == 0 {
+		return fmt.Errorf("%w: no secret", ErrInvalidTOTP)
+	}
+	if t.hash() == nil {
+		return fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidTOTP, t.Algorithm)
+	}
+	if t.Digits != 6 && t.Digits != 8 {
+		return fmt.Errorf("%w: codes must have 6 or 8 digits", ErrInvalidTOTP)
+	}
+	if t.Period < 1 || t.Period > maxTOTPPeriod {
+		return fmt.Errorf("%w: period must be between 1 and %d seconds", ErrInvalidTOTP, maxTOTPPeriod)
+	}
+	return nil
+}
+
+func (t *TOTP) hash() func() hash.Hash {
+	switch t.Algorithm {
+	case TOTPSHA1:
+		return sha1.New
+	case TOTPSHA256:
+		return sha256.New
+	case TOTPSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// URI is the otpauth:// form of t. It is what gets stored, so every
+// parameter is spelled out.
+func (t *TOTP) URI() string {
+	label := t.Account
+	if t.Issuer != "" {
+		label = t.Issuer + ":" + t.Account
+	}
+
+	q := url.Values{}
+	q.Set("secret", totpBase32.EncodeToString(t.Secret))
+	if t.Issuer != "" {
+		q.Set("issuer", t.Issuer)
+	}
+	q.Set("algorithm", t.Algorithm)
+	q.Set("digits", strconv.Itoa(t.Digits))
+	q.Set("period", strconv.Itoa(t.Period))
+
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Code returns the code valid at now and how long it stays valid.
+func (t *TOTP) Code(now time.Time) (string, time.Duration, error) {
+	if err := t.Validate(); err != nil {
+		return "", 0, err
+	}
+
+	period := int64(t.Period)
+	counter := now.Unix() / period
+	next := time.Unix((counter+1)*period, 0)
+
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+	mac := hmac.New(t.hash(), t.Secret)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range t.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", t.Digits, value%mod), next.Sub(now), nil
+}
@@ -0,0 +1,111 @@
+// internal/crypto/totp_test.go
+package crypto
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238, Appendix B.
+var rfc6238Seeds = map[string]string{
+	TOTPSHA1:   "12345678901234567890",
+	TOTPSHA256: "12345678901234567890123456789012",
+	TOTPSHA512: "1234567890123456789012345678901234567890123456789012345678901234",
+}
+
+var rfc6238Vectors = []struct {
+	unix      int64
+	algorithm string
+	code      string
+}{
+	{59, TOTPSHA1, "94287082"},
+	{59, TOTPSHA256, "46119246"},
+	{59, TOTPSHA512, "90693936"},
+	{1111111109, TOTPSHA1, "07081804"},
+	{1111111109, TOTPSHA256, "68084774"},
+	{1111111109, TOTPSHA512, "25091201"},
+	{1111111111, TOTPSHA1, "14050471"},
+	{1111111111, TOTPSHA256, "67062674"},
+	{1111111111, TOTPSHA512, "99943326"},
+	{1234567890, TOTPSHA1, "89005924"},
+	{1234567890, TOTPSHA256, "91819424"},
+	{1234567890, TOTPSHA512, "93441116"},
+	{2000000000, TOTPSHA1, "69279037"},
+	{2000000000, TOTPSHA256, "90698825"},
+	{2000000000, TOTPSHA512, "38618901"},
+	{20000000000, TOTPSHA1, "65353130"},
+	{20000000000, TOTPSHA256, "77737706"},
+	{20000000000, TOTPSHA512, "47863826"},
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		t.Run(tt.algorithm+"/"+time.Unix(tt.unix, 0).UTC().Format(time.RFC3339), func(t *testing.T) {
+			totp := &TOTP{
+				Secret:    []byte(rfc6238Seeds[tt.algorithm]),
+				Algorithm: tt.algorithm,
+				Digits:    8,
+				Period:    30,
+			}
+			code, remaining, err := totp.Code(time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Errorf("Code() = %s, want %s", code, tt.code)
+			}
+			if want := time.Duration(30-tt.unix%30) * time.Second; remaining != want {
+				t.Errorf("remaining = %v, want %v", remaining, want)
+			}
+		})
+	}
+}
+
+// TestParseTOTPRFC6238 runs the same vectors through otpauth URIs, as the
+// secrets are stored.
+func TestParseTOTPRFC6238(t *testing.T) {
+	for _, tt := range rfc6238Vectors {
+		secret := totpBase32.EncodeToString([]byte(rfc6238Seeds[tt.algorithm]))
+		uri := "otpauth://totp/Example:alice@example.com?secret=" + strings.ToLower(secret) +
+			"&issuer=Example&algorithm=" + tt.algorithm + "&digits=8&period=30"
+
+		totp, err := ParseTOTP(uri)
+		if err != nil {
+			t.Fatalf("ParseTOTP(%q): %v", uri, err)
+		}
+		if totp.Issuer != "Example" || totp.Account != "alice@example.com" {
+			t.Errorf("label = %q, %q; want Example, alice@example.com", totp.Issuer, totp.Account)
+		}
+
+		again, err := ParseTOTPBytes([]byte(totp.URI()))
+		if err != nil {
+			t.Fatalf("ParseTOTPBytes(%q): %v", totp.URI(), err)
+		}
+		code, _, err := again.Code(time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("%s at %d: Code() = %s, want %s", tt.algorithm, tt.unix, code, tt.code)
+		}
+	}
+}
+
+func TestParseTOTPInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"not base32!",
+		"JBSWY3DP", // too short
+		"otpauth://hotp/x?secret=JBSWY3DPEHPK3PXP",
+		"otpauth://totp/x?issuer=a",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&digits=7",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+		"otpauth://totp/x?secret=JBSWY3DPEHPK3PXP&period=0",
+	}
+	for _, input := range tests {
+		if _, err := ParseTOTP(input); err == nil {
+			t.Errorf("ParseTOTP(%q) succeeded", input)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+// internal/database/auth.go
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// tokenRefreshMargin is how long before expiry the auth token is renewed.
+const tokenRefreshMargin = 5 * time.Minute
+
+// ReauthFunc is asked for the password of identity when the server session
+// can no longer be refreshed.
+type ReauthFunc func(ctx context.Context, identity string) (string, error)
+
+// Reauthenticator is implemented by backends whose server login can expire
+// independently of the unlocked vault.
+type Reauthenticator interface {
+	SetReauthFunc(fn ReauthFunc)
+}
+
+var _ Reauthenticator = (*PocketBaseClient)(nil)
+
+func (p *PocketBaseClient) SetReauthFunc(fn ReauthFunc) {
+	p.reauthMu.Lock()
+	defer p.reauthMu.Unlock()
+	p.reauth = fn
+}
+
+func (p *PocketBaseClient) token() string {
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+	return p.authToken
+}
+
+func (p *PocketBaseClient) setToken(token string) {
+	p.authMu.Lock()
+	defer p.authMu.Unlock()
+	p.authToken = token
+	p.authExpiry = tokenExpiry(token)
+}
+
+// ensureFreshToken renews the token when it is about to expire. Failures
+// are ignored here; the request then gets a 401 and recoverAuth takes over.
+func (p *PocketBaseClient) ensureFreshToken(ctx context.Context) {
+	p.authMu.Lock()
+	token, expiry := p.authToken, p.authExpiry
+	p.authMu.Unlock()
+
+	if token == "" || expiry.IsZero() || time.Until(expiry) > tokenRefreshMargin {
+		return
+	}
+
+	p.reauthMu.Lock()
+	defer p.reauthMu.Unlock()
+
+	// Another request may have refreshed it while we waited
+	if p.token() != token {
+		return
+	}
+	p.refresh(ctx)
+}
+
+// recoverAuth replaces a token the server rejected, first by refreshing it
+// and then by signing in again through the ReauthFunc.
+func (p *PocketBaseClient) recoverAuth(ctx context.Context, rejected string) error {
+	p.reauthMu.Lock()
+	defer p.reauthMu.Unlock()
+
+	if p.token() != rejected {
+		return nil
+	}
+
+	if err := p.refresh(ctx); err == nil {
+		return nil
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	p.authMu.Lock()
+	identity := p.authIdentity
+	p.authMu.Unlock()
+
+	if p.reauth == nil || identity == "" {
+		return fmt.Errorf("server session expired: %w", ErrUnauthorized)
+	}
+
+	password, err := p.reauth(ctx, identity)
+	if err != nil {
+		return fmt.Errorf("server session expired: %w", err)
+	}
+
+	return p.Authenticate(ctx, identity, password)
+}
+
+func (p *PocketBaseClient) refresh(ctx context.Context) error {
+	p.authMu.Lock()
+	endpoint, token := p.authRefresh, p.authToken
+	p.authMu.Unlock()
+
+	if endpoint == "" {
+		return fmt.Errorf("no refresh endpoint")
+	}
+
+	resp, err := p.send(ctx, "POST", endpoint, nil, token)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp, "refresh token")
+	}
+
+	var authResp AuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return err
+	}
+	if authResp.Token == "" {
+		return fmt.Errorf("failed to refresh token: empty token")
+	}
+
+	p.setToken(authResp.Token)
+	return nil
+}
+
+// tokenExpiry reads the exp claim of a JWT without verifying it. It returns
+// the zero time if the token cannot be parsed.
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(claims.Exp, 0)
+}
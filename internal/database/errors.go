@@ -0,0 +1,177 @@
+// internal/database/errors.go
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"passmanager/internal/models"
+)
+
+// Backend failures wrap one of these, so callers can use errors.Is whatever
+// store they talk to.
+var (
+	ErrNotFound       = errors.New("not found")
+	ErrUnauthorized   = errors.New("not authorized")
+	ErrForbidden      = errors.New("access denied")
+	ErrConflict       = errors.New("conflicting change")
+	ErrValidation     = errors.New("invalid data")
+	ErrNotInitialized = errors.New("vault not initialized")
+)
+
+var (
+	errCredentialNotFound = fmt.Errorf("credential %w", ErrNotFound)
+	errConfigNotFound     = fmt.Errorf("vault config %w", ErrNotFound)
+)
+
+// ConflictError is returned when a credential changed after the caller
+// read it. Current holds the stored version so the two can be compared.
+type ConflictError struct {
+	Current models.Credential
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("credential %s was changed elsewhere at %s", e.Current.ID, e.Current.Updated)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrConflict
+}
+
+// FieldError is the server's complaint about a single field.
+type FieldError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIError is a request PocketBase answered with an error status. Fields
+// is set for validation failures.
+type APIError struct {
+	Op      string
+	Status  int
+	Message string
+	Fields  map[string]FieldError
+	kind    error
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("failed to %s: %s", e.Op, e.Message)
+	if details := e.fieldDetails(); details != "" {
+		msg += " (" + details + ")"
+	}
+	return msg
+}
+
+func (e *APIError) Unwrap() error {
+	return e.kind
+}
+
+func (e *APIError) fieldDetails() string {
+	names := make([]string, 0, len(e.Fields))
+	for name := range e.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s: %s", name, e.Fields[name].Message)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ValidationFields returns the per-field messages of a validation error, or
+// nil if err is not one.
+func ValidationFields(err error) map[string]string {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || len(apiErr.Fields) == 0 {
+		return nil
+	}
+
+	fields := make(map[string]string, len(apiErr.Fields))
+	for name, field := range apiErr.Fields {
+		fields[name] = field.Message
+	}
+	return fields
+}
+
+// apiError reads an error response. PocketBase answers with
+// {"status": ..., "message": ..., "data": {field: {code, message}}};
+// releases before 0.23 used "code" instead of "status".
+func apiError(resp *http.Response, op string) error {
+	body, _ := io.ReadAll(resp.Body)
+
+	var payload struct {
+		Message string                     `json:"message"`
+		Data    map[string]json.RawMessage `json:"data"`
+	}
+	apiErr := &APIError{Op: op, Status: resp.StatusCode}
+
+	if json.Unmarshal(body, &payload) == nil && payload.Message != "" {
+		apiErr.Message = payload.Message
+		for name, raw := range payload.Data {
+			var field FieldError
+			if json.Unmarshal(raw, &field) == nil && field.Message != "" {
+				if apiErr.Fields == nil {
+					apiErr.Fields = map[string]FieldError{}
+				}
+				apiErr.Fields[name] = field
+			}
+		}
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+		if apiErr.Message == "" {
+			apiErr.Message = http.StatusText(resp.StatusCode)
+		}
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadRequest:
+		if len(apiErr.Fields) > 0 {
+			apiErr.kind = ErrValidation
+		}
+	case http.StatusUnauthorized:
+		apiErr.kind = ErrUnauthorized
+	case http.StatusForbidden:
+		apiErr.kind = ErrForbidden
+	case http.StatusNotFound:
+		apiErr.kind = ErrNotFound
+	case http.StatusConflict:
+		apiErr.kind = ErrConflict
+	}
+
+	return apiErr
+}
+
+// Describe turns a backend error into a short message for the user.
+func Describe(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, ErrInvalidPassphrase):
+		return "invalid master password"
+	case errors.Is(err, ErrNotInitialized):
+		return "vault not initialized"
+	case errors.Is(err, ErrUnauthorized):
+		return "server login failed or expired, sign in again"
+	case errors.Is(err, ErrForbidden):
+		return "this account is not allowed to do that (check the collection API rules or sign in as a superuser)"
+	case errors.Is(err, ErrConflict):
+		return "the record was changed elsewhere"
+	case errors.Is(err, ErrValidation):
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return "rejected by the server: " + apiErr.fieldDetails()
+		}
+		return "rejected by the server"
+	}
+	return err.Error()
+}
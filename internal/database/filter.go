@@ -0,0 +1,358 @@
+// internal/database/filter.go
+package database
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"passmanager/internal/models"
+)
+
+// Field is a credential field that filters can refer to. Only the values
+// declared below exist, so user input can never end up as a field name.
+type Field struct {
+	name string
+}
+
+var (
+	FieldTitle    = Field{"title"}
+	FieldUsername = Field{"username"}
+	FieldURL      = Field{"url"}
+	FieldCategory = Field{"category"}
+	FieldCreated  = Field{"created"}
+	FieldUpdated  = Field{"updated"}
+
+	// Blind indexes of credentials with encrypted metadata
+	FieldDomainIndex   = Field{"domain_index"}
+	FieldCategoryIndex = Field{"category_index"}
+)
+
+func (f Field) value(cred models.Credential) string {
+	switch f {
+	case FieldTitle:
+		return cred.Title
+	case FieldUsername:
+		return cred.Username
+	case FieldURL:
+		return cred.URL
+	case FieldCategory:
+		return cred.Category
+	case FieldCreated:
+		return cred.Created
+	case FieldUpdated:
+		return cred.Updated
+	case FieldDomainIndex:
+		return cred.DomainIndex
+	case FieldCategoryIndex:
+		return cred.CategoryIndex
+	}
+	return ""
+}
+
+// Condition is a single test against a credential. Each backend renders it
+// in its own query language; Match evaluates it in memory.
+type Condition interface {
+	pocketBase() string
+	sql() (string, []any)
+	Match(cred models.Credential) bool
+}
+
+// Filter combines conditions with AND (All) or OR (Any). The zero Filter
+// matches every credential. A Filter is itself a Condition, so filters nest.
+type Filter struct {
+	any   bool
+	conds []Condition
+}
+
+func All(conds ...Condition) Filter {
+	// Empty filters match everything, so they add nothing to an AND
+	var kept []Condition
+	for _, cond := range conds {
+		if f, ok := cond.(Filter); ok && f.IsEmpty() {
+			continue
+		}
+		kept = append(kept, cond)
+	}
+	return Filter{conds: kept}
+}
+
+func Any(conds ...Condition) Filter {
+	// ...and make an OR match everything
+	for _, cond := range conds {
+		if f, ok := cond.(Filter); ok && f.IsEmpty() {
+			return Filter{}
+		}
+	}
+	return Filter{any: true, conds: conds}
+}
+
+// Search matches credentials whose title, username, URL or category
+// contains term, ignoring case. An empty term matches everything.
+func Search(term string) Filter {
+	if term == "" {
+		return Filter{}
+	}
+	return Any(
+		Contains(FieldTitle, term),
+		Contains(FieldUsername, term),
+		Contains(FieldURL, term),
+		Contains(FieldCategory, term),
+	)
+}
+
+func (f Filter) IsEmpty() bool {
+	return len(f.conds) == 0
+}
+
+func (f Filter) joiner() (string, string) {
+	if f.any {
+		return " || ", " OR "
+	}
+	return " && ", " AND "
+}
+
+// PocketBase renders the filter in PocketBase's filter syntax, with every
+// value quoted and escaped.
+func (f Filter) PocketBase() string {
+	return f.pocketBase()
+}
+
+func (f Filter) pocketBase() string {
+	if f.IsEmpty() {
+		return ""
+	}
+
+	pbJoin, _ := f.joiner()
+	parts := make([]string, len(f.conds))
+	for i, cond := range f.conds {
+		parts[i] = cond.pocketBase()
+	}
+	return "(" + strings.Join(parts, pbJoin) + ")"
+}
+
+// SQL renders the filter as a WHERE clause body with positional arguments.
+func (f Filter) SQL() (string, []any) {
+	return f.sql()
+}
+
+func (f Filter) sql() (string, []any) {
+	if f.IsEmpty() {
+		return "", nil
+	}
+
+	_, sqlJoin := f.joiner()
+	parts := make([]string, len(f.conds))
+	var args []any
+	for i, cond := range f.conds {
+		var condArgs []any
+		parts[i], condArgs = cond.sql()
+		args = append(args, condArgs...)
+	}
+	return "(" + strings.Join(parts, sqlJoin) + ")", args
+}
+
+func (f Filter) Match(cred models.Credential) bool {
+	if f.IsEmpty() {
+		return true
+	}
+
+	for _, cond := range f.conds {
+		matched := cond.Match(cred)
+		if f.any && matched {
+			return true
+		}
+		if !f.any && !matched {
+			return false
+		}
+	}
+	return !f.any
+}
+
+type containsCondition struct {
+	field Field
+	value string
+}
+
+// Contains matches when field contains value, ignoring case.
+func Contains(field Field, value string) Condition {
+	return containsCondition{field: field, value: value}
+}
+
+func (c containsCondition) pocketBase() string {
+	// Supplying our own wildcards stops PocketBase from treating % or _ in
+	// the value as patterns
+	return fmt.Sprintf("%s ~ %s", c.field.name, quotePocketBase("%"+escapeLike(c.value)+"%"))
+}
+
+func (c containsCondition) sql() (string, []any) {
+	return c.field.name + ` LIKE ? ESCAPE '\'`, []any{"%" + escapeLike(c.value) + "%"}
+}
+
+func (c containsCondition) Match(cred models.Credential) bool {
+	return strings.Contains(strings.ToLower(c.field.value(cred)), strings.ToLower(c.value))
+}
+
+type equalsCondition struct {
+	field Field
+	value string
+}
+
+// Equals matches when field is exactly value.
+func Equals(field Field, value string) Condition {
+	return equalsCondition{field: field, value: value}
+}
+
+func (c equalsCondition) pocketBase() string {
+	// PocketBase cannot express a literal ending in a backslash, because
+	// the closing quote would read as escaped. Fall back to a wider LIKE
+	// match; EachCredential re-checks every record with Match.
+	if strings.HasSuffix(c.value, `\`) {
+		trimmed := strings.TrimRight(c.value, `\`)
+		return fmt.Sprintf("%s ~ %s", c.field.name, quotePocketBase(escapeLike(trimmed)+"%"))
+	}
+	return fmt.Sprintf("%s = %s", c.field.name, quotePocketBase(c.value))
+}
+
+func (c equalsCondition) sql() (string, []any) {
+	return c.field.name + " = ?", []any{c.value}
+}
+
+func (c equalsCondition) Match(cred models.Credential) bool {
+	return c.field.value(cred) == c.value
+}
+
+type rangeCondition struct {
+	field    Field
+	from, to time.Time
+}
+
+// Between matches timestamps from `from` up to and including `to`. A zero
+// time leaves that end of the range open.
+func Between(field Field, from, to time.Time) Condition {
+	return rangeCondition{field: field, from: from, to: to}
+}
+
+type bound struct {
+	op, value string
+}
+
+func (c rangeCondition) bounds() []bound {
+	var bounds []bound
+	if !c.from.IsZero() {
+		bounds = append(bounds, bound{">=", formatTimestamp(c.from)})
+	}
+	if !c.to.IsZero() {
+		bounds = append(bounds, bound{"<=", formatTimestamp(c.to)})
+	}
+	return bounds
+}
+
+func (c rangeCondition) pocketBase() string {
+	bounds := c.bounds()
+	if len(bounds) == 0 {
+		return "id != ''"
+	}
+
+	parts := make([]string, len(bounds))
+	for i, b := range bounds {
+		parts[i] = fmt.Sprintf("%s %s %s", c.field.name, b.op, quotePocketBase(b.value))
+	}
+	return "(" + strings.Join(parts, " && ") + ")"
+}
+
+func (c rangeCondition) sql() (string, []any) {
+	bounds := c.bounds()
+	if len(bounds) == 0 {
+		return "1 = 1", nil
+	}
+
+	parts := make([]string, len(bounds))
+	args := make([]any, len(bounds))
+	for i, b := range bounds {
+		parts[i] = fmt.Sprintf("%s %s ?", c.field.name, b.op)
+		args[i] = b.value
+	}
+	return "(" + strings.Join(parts, " AND ") + ")", args
+}
+
+func (c rangeCondition) Match(cred models.Credential) bool {
+	// Timestamps share one fixed-width format, so they compare as strings
+	value := c.field.value(cred)
+	for _, b := range c.bounds() {
+		if b.op == ">=" && value < b.value {
+			return false
+		}
+		if b.op == "<=" && value > b.value {
+			return false
+		}
+	}
+	return true
+}
+
+// CategoryIn matches credentials in any of the given categories. With no
+// categories it matches nothing.
+func CategoryIn(categories ...string) Condition {
+	if len(categories) == 0 {
+		return Nothing()
+	}
+	conds := make([]Condition, len(categories))
+	for i, category := range categories {
+		conds[i] = Equals(FieldCategory, category)
+	}
+	return Any(conds...)
+}
+
+type encryptedCondition struct {
+	encrypted bool
+}
+
+// MetadataEncrypted matches credentials whose metadata is, or with false
+// is not, encrypted.
+func MetadataEncrypted(encrypted bool) Condition {
+	return encryptedCondition{encrypted: encrypted}
+}
+
+func (c encryptedCondition) pocketBase() string {
+	return fmt.Sprintf("metadata_encrypted = %t", c.encrypted)
+}
+
+func (c encryptedCondition) sql() (string, []any) {
+	return "metadata_encrypted = ?", []any{c.encrypted}
+}
+
+func (c encryptedCondition) Match(cred models.Credential) bool {
+	return cred.MetadataEncrypted == c.encrypted
+}
+
+type nothingCondition struct{}
+
+// Nothing matches no credential. Unlike an empty Any, which matches
+// everything, it is what an empty set of choices should select.
+func Nothing() Condition {
+	return nothingCondition{}
+}
+
+func (nothingCondition) pocketBase() string {
+	return "id = ''"
+}
+
+func (nothingCondition) sql() (string, []any) {
+	return "1 = 0", nil
+}
+
+func (nothingCondition) Match(models.Credential) bool {
+	return false
+}
+
+// quotePocketBase wraps value in single quotes for a PocketBase filter.
+// PocketBase only unescapes \' inside literals; other backslashes are kept
+// as they are.
+func quotePocketBase(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
+
+// escapeLike escapes the LIKE wildcards so values match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,165 @@
+// internal/database/filter_test.go
+package database
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+
+	"passmanager/internal/models"
+)
+
+func TestFilterEscaping(t *testing.T) {
+	tests := []struct {
+		name     string
+		cond     Condition
+		wantSQL  string
+		wantArgs []any
+		wantPB   string
+	}{
+		{
+			name:     "quote",
+			cond:     Contains(FieldTitle, "it's"),
+			wantSQL:  `(title LIKE ? ESCAPE '\')`,
+			wantArgs: []any{"%it's%"},
+			wantPB:   `(title ~ '%it\'s%')`,
+		},
+		{
+			name:     "percent",
+			cond:     Contains(FieldTitle, "100%"),
+			wantSQL:  `(title LIKE ? ESCAPE '\')`,
+			wantArgs: []any{`%100\%%`},
+			wantPB:   `(title ~ '%100\%%')`,
+		},
+		{
+			name:     "underscore",
+			cond:     Contains(FieldTitle, "a_b"),
+			wantSQL:  `(title LIKE ? ESCAPE '\')`,
+			wantArgs: []any{`%a\_b%`},
+			wantPB:   `(title ~ '%a\_b%')`,
+		},
+		{
+			name:     "backslash",
+			cond:     Contains(FieldTitle, `c:\dir`),
+			wantSQL:  `(title LIKE ? ESCAPE '\')`,
+			wantArgs: []any{`%c:\\dir%`},
+			wantPB:   `(title ~ '%c:\\dir%')`,
+		},
+		{
+			name:     "equals quote",
+			cond:     Equals(FieldCategory, "o'brien"),
+			wantSQL:  `(category = ?)`,
+			wantArgs: []any{"o'brien"},
+			wantPB:   `(category = 'o\'brien')`,
+		},
+		{
+			name:     "equals trailing backslash",
+			cond:     Equals(FieldCategory, `tools\`),
+			wantSQL:  `(category = ?)`,
+			wantArgs: []any{`tools\`},
+			wantPB:   `(category ~ 'tools%')`,
+		},
+		{
+			name:    "no categories",
+			cond:    CategoryIn(),
+			wantSQL: `(1 = 0)`,
+			wantPB:  `(id = '')`,
+		},
+		{
+			name:     "metadata encrypted",
+			cond:     MetadataEncrypted(false),
+			wantSQL:  `(metadata_encrypted = ?)`,
+			wantArgs: []any{false},
+			wantPB:   `(metadata_encrypted = false)`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := All(tt.cond)
+
+			gotSQL, gotArgs := filter.SQL()
+			if gotSQL != tt.wantSQL || !reflect.DeepEqual(gotArgs, tt.wantArgs) {
+				t.Errorf("SQL() = %q, %q; want %q, %q", gotSQL, gotArgs, tt.wantSQL, tt.wantArgs)
+			}
+			if got := filter.PocketBase(); got != tt.wantPB {
+				t.Errorf("PocketBase() = %q; want %q", got, tt.wantPB)
+			}
+		})
+	}
+}
+
+// TestFilterSQLAgreesWithMatch runs each filter against SQLite and checks
+// it selects exactly the credentials Match accepts.
+func TestFilterSQLAgreesWithMatch(t *testing.T) {
+	ctx := context.Background()
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "vault.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	titles := []string{"it's", "its", "100%", "1000", "a_b", "axb", `c:\dir`, `c:dir`, "plain"}
+	categories := []string{"o'brien", "obrien", `tools\`, "tools", "100%", "1000", "a_b", "axb", "work"}
+	var creds []models.Credential
+	for i, title := range titles {
+		created, err := store.CreateCredential(ctx, models.Credential{Title: title, Category: categories[i], MetadataEncrypted: i%2 == 0})
+		if err != nil {
+			t.Fatal(err)
+		}
+		creds = append(creds, *created)
+	}
+
+	filters := map[string]Filter{
+		"quote":            All(Contains(FieldTitle, "'")),
+		"percent":          All(Contains(FieldTitle, "%")),
+		"underscore":       All(Contains(FieldTitle, "_")),
+		"backslash":        All(Contains(FieldTitle, `\`)),
+		"equals quote":     All(Equals(FieldCategory, "o'brien")),
+		"equals backslash": All(Equals(FieldCategory, `tools\`)),
+		"categories":       All(CategoryIn("100%", "a_b")),
+		"no categories":    All(CategoryIn()),
+		"search":           Search("_"),
+		"encrypted":        All(MetadataEncrypted(true)),
+		"not encrypted":    Any(MetadataEncrypted(false), Equals(FieldTitle, "plain")),
+	}
+
+	for name, filter := range filters {
+		t.Run(name, func(t *testing.T) {
+			got, err := store.ListCredentials(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotIDs, wantIDs []string
+			for _, cred := range got {
+				gotIDs = append(gotIDs, cred.ID)
+			}
+			for _, cred := range creds {
+				if filter.Match(cred) {
+					wantIDs = append(wantIDs, cred.ID)
+				}
+			}
+			slices.Sort(gotIDs)
+			slices.Sort(wantIDs)
+			if !slices.Equal(gotIDs, wantIDs) {
+				t.Errorf("SQL selected %v, Match accepts %v", gotIDs, wantIDs)
+			}
+			if name != "no categories" && len(wantIDs) == 0 {
+				t.Error("filter matches nothing, so it tests nothing")
+			}
+		})
+	}
+}
+
+func TestCategoryInEmptyMatchesNothing(t *testing.T) {
+	cred := models.Credential{Title: "anything", Category: "general"}
+	if CategoryIn().Match(cred) {
+		t.Error("CategoryIn() matched a credential")
+	}
+	if All(Search("any"), CategoryIn()).Match(cred) {
+		t.Error("a filter including CategoryIn() matched a credential")
+	}
+}
@@ -0,0 +1,648 @@
+// internal/database/localfile.go
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"passmanager/internal/config"
+	"passmanager/internal/crypto"
+	"passmanager/internal/models"
+)
+
+// localVaultVersion 2 added the KDF parameters and versioned ciphertexts.
+// Version 3 encrypts the file with its own subkey of the password-derived
+// key instead of that key itself. Older files are still read, and are
+// moved to the subkey when opened with the password.
+//
+// The file shares its salt and KDF settings with the vault config, so the
+// key derived once at unlock opens both. Files created before that had a
+// salt of their own and are moved to the config's when opened.
+const localVaultVersion = 3
+
+// fileKeyVersion is the first version encrypted with crypto.FileKey.
+const fileKeyVersion = 3
+
+var (
+	ErrInvalidPassphrase = errors.New("invalid master password")
+	ErrNoRecovery        = errors.New("no recovery key is set up for this vault")
+)
+
+// LocalFileStore keeps the whole vault in a single file encrypted with a key
+// derived from the master password. Every operation takes a lock on a
+// sibling .lock file, so several processes can share the same vault.
+type LocalFileStore struct {
+	mu     sync.Mutex
+	path   string
+	salt   []byte
+	kdf    string
+	crypto *crypto.CryptoService
+
+	// The file key wrapped for the vault's recovery key, and which public
+	// key that was, so it is only redone when either changes
+	recovery    string
+	recoveryFor string
+}
+
+// localVaultFile is the on-disk envelope. Only the salt, KDF parameters,
+// whether a key file is needed and the file key wrapped for recovery are
+// stored in the clear; everything else lives in Data.
+type localVaultFile struct {
+	Version  int    `json:"version"`
+	Salt     string `json:"salt"`
+	KDF      string `json:"kdf,omitempty"`
+	KeyFile  bool   `json:"key_file,omitempty"`
+	Recovery string `json:"recovery,omitempty"`
+	Data     string `json:"data"`
+}
+
+type localVault struct {
+	Config      *models.VaultConfig `json:"config,omitempty"`
+	Credentials []models.Credential `json:"credentials"`
+}
+
+var (
+	_ VaultStore      = (*LocalFileStore)(nil)
+	_ PassphraseStore = (*LocalFileStore)(nil)
+)
+
+// CreateLocalFileStore creates a new, empty vault file at path, keyed by
+// the FileKey subkey of key. The vault config is wrapped with the same key.
+func CreateLocalFileStore(path string, key *crypto.DerivedKey) (*LocalFileStore, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("vault file already exists: %s", path)
+	}
+
+	fileKey, err := key.FileKey()
+	if err != nil {
+		return nil, err
+	}
+
+	store := &LocalFileStore{
+		path:   path,
+		salt:   append([]byte(nil), key.Salt...),
+		kdf:    key.KDF,
+		crypto: fileKey,
+	}
+
+	err = store.withLock(context.Background(), true, func() error {
+		return store.write(&localVault{Credentials: []models.Credential{}})
+	})
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// LocalFileNeedsKeyFile reports whether the vault file at path was set up
+// with a key file, so that it can be asked for before trying to open it.
+func LocalFileNeedsKeyFile(path string) (bool, error) {
+	file, err := readHeader(path)
+	if err != nil {
+		return false, err
+	}
+	return file.KeyFile, nil
+}
+
+// LocalFileRecoverable reports whether the vault file at path can be opened
+// with a recovery key.
+func LocalFileRecoverable(path string) (bool, error) {
+	file, err := readHeader(path)
+	if err != nil {
+		return false, err
+	}
+	return file.Recovery != "", nil
+}
+
+func readHeader(path string) (*localVaultFile, error) {
+	store := &LocalFileStore{path: path}
+
+	var file *localVaultFile
+	err := store.withLock(context.Background(), false, func() error {
+		var err error
+		file, err = store.readFile()
+		return err
+	})
+	return file, err
+}
+
+// OpenLocalFileStore opens an existing vault file and checks that the
+// passphrase decrypts it. It also returns the key derived from passphrase,
+// for vault.UnlockDerived; the caller must Destroy it.
+func OpenLocalFileStore(path string, passphrase []byte) (*LocalFileStore, *crypto.DerivedKey, error) {
+	store := &LocalFileStore{path: path}
+
+	var key *crypto.DerivedKey
+	var move bool
+	var cfg *models.VaultConfig
+	err := store.withLock(context.Background(), false, func() error {
+		file, err := store.readFile()
+		if err != nil {
+			return err
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(file.Salt)
+		if err != nil {
+			return fmt.Errorf("corrupt vault file: %w", err)
+		}
+
+		if key, err = crypto.NewDerivedKey(passphrase, salt, file.KDF); err != nil {
+			return err
+		}
+
+		store.salt = salt
+		store.kdf = file.KDF
+		if file.Version >= fileKeyVersion {
+			store.crypto, err = key.FileKey()
+		} else {
+			// Older files were encrypted with the derived key itself
+			err = key.Use(func(derived []byte) error {
+				var err error
+				store.crypto, err = crypto.NewCryptoServiceFromKey(derived)
+				return err
+			})
+		}
+		if err != nil {
+			return err
+		}
+
+		vault, err := store.decode(file)
+		if err != nil {
+			return err
+		}
+		cfg = vault.Config
+		move = file.Version < fileKeyVersion || (cfg != nil && !key.Matches(cfg.Salt, cfg.KDF))
+		return nil
+	})
+	if err == nil && move {
+		key, err = store.moveToConfigKey(key, passphrase, cfg)
+	}
+	if err != nil {
+		key.Destroy()
+		store.Close()
+		return nil, nil, err
+	}
+
+	return store, key, nil
+}
+
+// moveToConfigKey re-encrypts a file from before fileKeyVersion, or with
+// a salt of its own, under the file key for cfg's salt and KDF. This runs
+// the KDF a second time, once. It returns the key now in use, and destroys
+// key if that is another.
+func (l *LocalFileStore) moveToConfigKey(key *crypto.DerivedKey, passphrase []byte, cfg *models.VaultConfig) (*crypto.DerivedKey, error) {
+	if cfg != nil && !key.Matches(cfg.Salt, cfg.KDF) {
+		salt, err := base64.StdEncoding.DecodeString(cfg.Salt)
+		if err != nil {
+			return key, fmt.Errorf("corrupt vault config: %w", err)
+		}
+		configKey, err := crypto.NewDerivedKey(passphrase, salt, cfg.KDF)
+		if err != nil {
+			return key, err
+		}
+		key.Destroy()
+		key = configKey
+	}
+
+	fileKey, err := key.FileKey()
+	if err != nil {
+		return key, err
+	}
+
+	err = l.withLock(context.Background(), true, func() error {
+		file, err := l.readFile()
+		if err != nil {
+			return err
+		}
+
+		old, oldSalt, oldKDF := l.crypto, l.salt, l.kdf
+		if file.Version >= fileKeyVersion && key.Matches(file.Salt, file.KDF) {
+			// Another process moved it first
+			l.crypto, l.salt, l.kdf = fileKey, key.Salt, key.KDF
+			if _, err := l.decode(file); err != nil {
+				l.crypto, l.salt, l.kdf = old, oldSalt, oldKDF
+				return err
+			}
+			old.SecureClear()
+			return nil
+		}
+
+		vault, err := l.decode(file)
+		if err != nil {
+			return err
+		}
+		l.crypto, l.salt, l.kdf, l.recoveryFor = fileKey, key.Salt, key.KDF, ""
+		if err := l.write(vault); err != nil {
+			l.crypto, l.salt, l.kdf = old, oldSalt, oldKDF
+			return err
+		}
+		old.SecureClear()
+		return nil
+	})
+	if err != nil {
+		fileKey.SecureClear()
+	}
+	return key, err
+}
+
+// OpenLocalFileStoreRecovery opens an existing vault file with its recovery
+// key instead of the master password. Set a new password with
+// ChangePassphrase before the store is used any further; that also moves
+// an older file to its file key.
+func OpenLocalFileStoreRecovery(path string, recoveryKey []byte) (*LocalFileStore, error) {
+	store := &LocalFileStore{path: path}
+
+	err := store.withLock(context.Background(), false, func() error {
+		file, err := store.readFile()
+		if err != nil {
+			return err
+		}
+		if file.Recovery == "" {
+			return ErrNoRecovery
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(file.Salt)
+		if err != nil {
+			return fmt.Errorf("corrupt vault file: %w", err)
+		}
+
+		store.salt = salt
+		store.kdf = file.KDF
+		if store.crypto, err = crypto.RecoveryUnwrap(recoveryKey, file.Recovery); err != nil {
+			return err
+		}
+
+		_, err = store.decode(file)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func (l *LocalFileStore) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.crypto != nil {
+		l.crypto.SecureClear()
+	}
+	return nil
+}
+
+func (l *LocalFileStore) withLock(ctx context.Context, exclusive bool, fn func() error) error {
+	// Everything happens locally and quickly, so cancellation is only
+	// checked before starting
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0700); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(l.path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open lock file: %w", err)
+	}
+	defer lock.Close()
+
+	if err := lockFile(lock, exclusive); err != nil {
+		return fmt.Errorf("failed to lock vault file: %w", err)
+	}
+	defer unlockFile(lock)
+
+	return fn()
+}
+
+func (l *LocalFileStore) readFile() (*localVaultFile, error) {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file localVaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("corrupt vault file: %w", err)
+	}
+
+	if file.Version < 1 || file.Version > localVaultVersion {
+		return nil, fmt.Errorf("unsupported vault file version %d", file.Version)
+	}
+
+	return &file, nil
+}
+
+func (l *LocalFileStore) decode(file *localVaultFile) (*localVault, error) {
+	if file.Salt != base64.StdEncoding.EncodeToString(l.salt) || file.KDF != l.kdf {
+		return nil, fmt.Errorf("vault file was re-keyed by another process, unlock it again")
+	}
+
+	plaintext, err := l.crypto.Decrypt(file.Data)
+	if err != nil {
+		return nil, ErrInvalidPassphrase
+	}
+	defer plaintext.Destroy()
+
+	var vault localVault
+	if err := json.Unmarshal(plaintext.Bytes(), &vault); err != nil {
+		return nil, fmt.Errorf("corrupt vault file: %w", err)
+	}
+
+	return &vault, nil
+}
+
+func (l *LocalFileStore) read() (*localVault, error) {
+	file, err := l.readFile()
+	if err != nil {
+		return nil, err
+	}
+	return l.decode(file)
+}
+
+func (l *LocalFileStore) write(vault *localVault) error {
+	plaintext, err := json.Marshal(vault)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := l.crypto.EncryptBytes(plaintext)
+	clear(plaintext)
+	if err != nil {
+		return err
+	}
+
+	recovery, err := l.wrapForRecovery(vault.Config)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(localVaultFile{
+		Version:  localVaultVersion,
+		Salt:     base64.StdEncoding.EncodeToString(l.salt),
+		KDF:      l.kdf,
+		KeyFile:  vault.Config != nil && vault.Config.KeyFile,
+		Recovery: recovery,
+		Data:     encrypted,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return config.WriteFileAtomic(l.path, data, 0600)
+}
+
+// wrapForRecovery returns the file key wrapped for cfg's recovery key, or
+// "" if the vault has none.
+func (l *LocalFileStore) wrapForRecovery(cfg *models.VaultConfig) (string, error) {
+	if cfg == nil || cfg.RecoveryPublicKey == "" {
+		return "", nil
+	}
+	if l.recoveryFor != cfg.RecoveryPublicKey {
+		recovery, err := crypto.RecoveryWrap(cfg.RecoveryPublicKey, l.crypto)
+		if err != nil {
+			return "", err
+		}
+		l.recovery, l.recoveryFor = recovery, cfg.RecoveryPublicKey
+	}
+	return l.recovery, nil
+}
+
+// update runs fn against the decrypted vault and writes the result back
+// while holding the exclusive lock.
+func (l *LocalFileStore) update(ctx context.Context, fn func(vault *localVault) error) error {
+	return l.withLock(ctx, true, func() error {
+		vault, err := l.read()
+		if err != nil {
+			return err
+		}
+		if err := fn(vault); err != nil {
+			return err
+		}
+		return l.write(vault)
+	})
+}
+
+func (l *LocalFileStore) view(ctx context.Context, fn func(vault *localVault) error) error {
+	return l.withLock(ctx, false, func() error {
+		vault, err := l.read()
+		if err != nil {
+			return err
+		}
+		return fn(vault)
+	})
+}
+
+func (l *LocalFileStore) CreateCredential(ctx context.Context, cred models.Credential) (*models.Credential, error) {
+	err := l.update(ctx, func(vault *localVault) error {
+		if cred.ID == "" {
+			id, err := NewRecordID()
+			if err != nil {
+				return err
+			}
+			cred.ID = id
+		}
+
+		now := timestampNow()
+		cred.Created = now
+		cred.Updated = now
+		vault.Credentials = append(vault.Credentials, cred)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &cred, nil
+}
+
+func (l *LocalFileStore) GetCredential(ctx context.Context, id string) (*models.Credential, error) {
+	var found *models.Credential
+	err := l.view(ctx, func(vault *localVault) error {
+		for i := range vault.Credentials {
+			if vault.Credentials[i].ID == id {
+				found = &vault.Credentials[i]
+				return nil
+			}
+		}
+		return errCredentialNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+func (l *LocalFileStore) ListCredentials(ctx context.Context, filter Filter) ([]models.Credential, error) {
+	var creds []models.Credential
+	err := l.view(ctx, func(vault *localVault) error {
+		for _, cred := range vault.Credentials {
+			if filter.Match(cred) {
+				creds = append(creds, cred)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Newest first, like the PocketBase backend
+	sort.SliceStable(creds, func(i, j int) bool {
+		return creds[i].Created > creds[j].Created
+	})
+
+	return creds, nil
+}
+
+func (l *LocalFileStore) EachCredential(ctx context.Context, filter Filter, fn CredentialFunc) error {
+	creds, err := l.ListCredentials(ctx, filter)
+	return eachInList(creds, err, fn)
+}
+
+func (l *LocalFileStore) UpdateCredential(ctx context.Context, id string, cred models.Credential) (*models.Credential, error) {
+	var updated models.Credential
+	err := l.update(ctx, func(vault *localVault) error {
+		for i := range vault.Credentials {
+			if vault.Credentials[i].ID == id {
+				current := vault.Credentials[i]
+				if cred.Updated != "" && cred.Updated != current.Updated {
+					return &ConflictError{Current: current}
+				}
+
+				cred.ID = id
+				cred.Created = current.Created
+				cred.Updated = nextTimestamp(current.Updated)
+				vault.Credentials[i] = cred
+				updated = cred
+				return nil
+			}
+		}
+		return errCredentialNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
+
+func (l *LocalFileStore) DeleteCredential(ctx context.Context, id string) error {
+	return l.update(ctx, func(vault *localVault) error {
+		for i := range vault.Credentials {
+			if vault.Credentials[i].ID == id {
+				vault.Credentials = append(vault.Credentials[:i], vault.Credentials[i+1:]...)
+				return nil
+			}
+		}
+		return errCredentialNotFound
+	})
+}
+
+func (l *LocalFileStore) GetCredentialCount(ctx context.Context) (int, error) {
+	count := 0
+	err := l.view(ctx, func(vault *localVault) error {
+		count = len(vault.Credentials)
+		return nil
+	})
+	return count, err
+}
+
+func (l *LocalFileStore) SaveVaultConfig(ctx context.Context, cfg models.VaultConfig) error {
+	return l.update(ctx, func(vault *localVault) error {
+		id, err := NewRecordID()
+		if err != nil {
+			return err
+		}
+
+		now := timestampNow()
+		cfg.ID = id
+		cfg.Created = now
+		cfg.Updated = now
+		vault.Config = &cfg
+		return nil
+	})
+}
+
+func (l *LocalFileStore) GetVaultConfig(ctx context.Context) (*models.VaultConfig, error) {
+	var cfg *models.VaultConfig
+	err := l.view(ctx, func(vault *localVault) error {
+		if vault.Config == nil {
+			return ErrNotInitialized
+		}
+		cfg = vault.Config
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (l *LocalFileStore) UpdateVaultConfig(ctx context.Context, id string, cfg models.VaultConfig) error {
+	return l.update(ctx, func(vault *localVault) error {
+		return applyVaultConfig(vault, id, cfg)
+	})
+}
+
+func applyVaultConfig(vault *localVault, id string, cfg models.VaultConfig) error {
+	if vault.Config == nil || vault.Config.ID != id {
+		return errConfigNotFound
+	}
+
+	cfg.ID = id
+	cfg.Created = vault.Config.Created
+	cfg.Updated = timestampNow()
+	vault.Config = &cfg
+	return nil
+}
+
+// ChangePassphrase stores the new vault config and re-encrypts the file
+// under the file key for key in a single atomic write.
+func (l *LocalFileStore) ChangePassphrase(ctx context.Context, key *crypto.DerivedKey, cfg models.VaultConfig) error {
+	newCrypto, err := key.FileKey()
+	if err != nil {
+		return err
+	}
+	newSalt := append([]byte(nil), key.Salt...)
+
+	err = l.withLock(ctx, true, func() error {
+		vault, err := l.read()
+		if err != nil {
+			return err
+		}
+		if err := applyVaultConfig(vault, cfg.ID, cfg); err != nil {
+			return err
+		}
+
+		oldSalt, oldKDF, oldCrypto, oldRecovery := l.salt, l.kdf, l.crypto, l.recoveryFor
+		l.salt, l.kdf, l.crypto, l.recoveryFor = newSalt, key.KDF, newCrypto, ""
+		if err := l.write(vault); err != nil {
+			l.salt, l.kdf, l.crypto, l.recoveryFor = oldSalt, oldKDF, oldCrypto, oldRecovery
+			return err
+		}
+
+		oldCrypto.SecureClear()
+		return nil
+	})
+	if err != nil {
+		newCrypto.SecureClear()
+	}
+	return err
+}
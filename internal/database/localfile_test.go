@@ -0,0 +1,116 @@
+// internal/database/localfile_test.go
+package database
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/models"
+)
+
+var (
+	testPassphrase = []byte("correct horse battery staple")
+	// Cheap settings, so the tests do not wait on the KDF
+	testKDF = crypto.KDFParams{Algorithm: crypto.KDFArgon2id, Time: 1, Memory: 64, Threads: 1}.String()
+)
+
+func testKey(t *testing.T) *crypto.DerivedKey {
+	t.Helper()
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := crypto.NewDerivedKey(testPassphrase, salt, testKDF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(key.Destroy)
+	return key
+}
+
+func saltOf(key *crypto.DerivedKey) string {
+	return base64.StdEncoding.EncodeToString(key.Salt)
+}
+
+func TestLocalFileSharedKey(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.json")
+
+	key := testKey(t)
+	store, err := CreateLocalFileStore(path, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := models.VaultConfig{Salt: saltOf(key), KDF: key.KDF}
+	if err := store.SaveVaultConfig(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, opened, err := OpenLocalFileStore(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	defer opened.Destroy()
+	if !opened.Matches(cfg.Salt, cfg.KDF) {
+		t.Error("the key from OpenLocalFileStore does not match the vault config")
+	}
+
+	if _, _, err := OpenLocalFileStore(path, []byte("wrong")); !errors.Is(err, ErrInvalidPassphrase) {
+		t.Errorf("OpenLocalFileStore() with the wrong passphrase = %v, want ErrInvalidPassphrase", err)
+	}
+}
+
+// Files created before the config and the file shared a salt are moved to
+// the config's salt when opened.
+func TestLocalFileMovesToConfigSalt(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.json")
+
+	store, err := CreateLocalFileStore(path, testKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configKey := testKey(t)
+	cfg := models.VaultConfig{Salt: saltOf(configKey), KDF: configKey.KDF}
+	if err := store.SaveVaultConfig(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateCredential(ctx, models.Credential{Title: "example"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, opened, err := OpenLocalFileStore(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opened.Matches(cfg.Salt, cfg.KDF) {
+		t.Error("the key from OpenLocalFileStore does not match the vault config")
+	}
+	opened.Destroy()
+	store.Close()
+
+	file, err := readHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Salt != cfg.Salt {
+		t.Errorf("file salt = %s, want the config's %s", file.Salt, cfg.Salt)
+	}
+
+	store, opened, err = OpenLocalFileStore(path, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	defer opened.Destroy()
+	if count, err := store.GetCredentialCount(ctx); err != nil || count != 1 {
+		t.Errorf("GetCredentialCount() = %d, %v after the move, want 1", count, err)
+	}
+}
@@ -3,20 +3,33 @@ package database
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"passmanager/internal/models"
 	"strings"
+	"sync"
 	"time"
 )
 
 type PocketBaseClient struct {
 	baseURL    string
 	httpClient *http.Client
-	authToken  string
+	retry      RetryPolicy
+
+	// authMu guards the token fields; reauthMu serialises refreshing and
+	// signing in again so concurrent requests only do it once.
+	authMu       sync.Mutex
+	authToken    string
+	authExpiry   time.Time
+	authRefresh  string
+	authIdentity string
+	reauthMu     sync.Mutex
+	reauth       ReauthFunc
 }
 
 type AuthResponse struct {
@@ -28,10 +41,16 @@ type AuthResponse struct {
 }
 
 type ListResponse struct {
+	Page       int                 `json:"page"`
+	PerPage    int                 `json:"perPage"`
+	TotalPages int                 `json:"totalPages"`
 	Items      []models.Credential `json:"items"`
 	TotalItems int                 `json:"totalItems"`
 }
 
+// listPageSize is how many records ListCredentials requests at a time.
+const listPageSize = 200
+
 type ConfigListResponse struct {
 	Items []models.VaultConfig `json:"items"`
 }
@@ -44,11 +63,16 @@ func NewPocketBaseClient(baseURL string) *PocketBaseClient {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		retry: DefaultRetryPolicy(),
 	}
 }
 
-func (p *PocketBaseClient) TestConnection() error {
-	resp, err := p.httpClient.Get(p.baseURL + "/api/health")
+func (p *PocketBaseClient) SetRetryPolicy(policy RetryPolicy) {
+	p.retry = policy
+}
+
+func (p *PocketBaseClient) TestConnection(ctx context.Context) error {
+	resp, err := p.doRequest(ctx, "GET", "/api/health", nil)
 	if err != nil {
 		return fmt.Errorf("cannot reach PocketBase: %w", err)
 	}
@@ -60,107 +84,186 @@ func (p *PocketBaseClient) TestConnection() error {
 	return nil
 }
 
-func (p *PocketBaseClient) Authenticate(email, password string) error {
+func (p *PocketBaseClient) Authenticate(ctx context.Context, email, password string) error {
 	authData := map[string]string{
 		"identity": email,
 		"password": password,
 	}
 
-	jsonData, err := json.Marshal(authData)
-	if err != nil {
-		return err
-	}
-
+	// Only superusers: the collections have no API rules, so any other
+	// account would be refused every request after signing in
 	endpoints := []string{
 		"/api/collections/_superusers/auth-with-password",
-		"/api/collections/users/auth-with-password",
 		"/api/admins/auth-with-password",
 	}
 
-	var lastErr error
+	jsonData, err := json.Marshal(authData)
+	if err != nil {
+		return err
+	}
+
+	var authErr error
 	for _, endpoint := range endpoints {
-		resp, err := p.httpClient.Post(
-			p.baseURL+endpoint,
-			"application/json",
-			bytes.NewBuffer(jsonData),
-		)
+		resp, err := p.send(ctx, "POST", endpoint, jsonData, "")
 		if err != nil {
-			lastErr = err
+			if ctx.Err() != nil {
+				return err
+			}
+			authErr = err
 			continue
 		}
 
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			err := apiError(resp, "authenticate")
+			resp.Body.Close()
 
-		if resp.StatusCode == http.StatusOK {
-			var authResp AuthResponse
-			if err := json.Unmarshal(body, &authResp); err != nil {
-				lastErr = err
-				continue
+			// A missing endpoint only means this server version does not
+			// have it, so keep the first more telling error
+			if authErr == nil || errors.Is(authErr, ErrNotFound) {
+				authErr = err
 			}
-			p.authToken = authResp.Token
-			return nil
+			continue
+		}
+
+		var authResp AuthResponse
+		err = json.NewDecoder(resp.Body).Decode(&authResp)
+		resp.Body.Close()
+		if err != nil {
+			authErr = err
+			continue
 		}
 
-		lastErr = fmt.Errorf("endpoint %s failed: %s", endpoint, string(body))
+		p.setToken(authResp.Token)
+		p.authMu.Lock()
+		p.authIdentity = email
+		p.authRefresh = strings.TrimSuffix(endpoint, "auth-with-password") + "auth-refresh"
+		p.authMu.Unlock()
+		return nil
 	}
 
-	return fmt.Errorf("authentication failed: %v", lastErr)
+	// PocketBase answers bad credentials with 400
+	var apiErr *APIError
+	if errors.As(authErr, &apiErr) && apiErr.Status < http.StatusInternalServerError {
+		apiErr.kind = ErrUnauthorized
+	}
+	return authErr
 }
 
-func (p *PocketBaseClient) doRequest(method, endpoint string, body interface{}) (*http.Response, error) {
-	var reqBody io.Reader
+// doRequest sends an authenticated request. The token is refreshed shortly
+// before it expires, and a 401 response triggers a refresh or a fresh
+// sign-in followed by one more attempt.
+func (p *PocketBaseClient) doRequest(ctx context.Context, method, endpoint string, body interface{}) (*http.Response, error) {
+	var jsonData []byte
 	if body != nil {
-		jsonData, err := json.Marshal(body)
+		var err error
+		jsonData, err = json.Marshal(body)
 		if err != nil {
 			return nil, err
 		}
-		reqBody = bytes.NewBuffer(jsonData)
 	}
 
-	req, err := http.NewRequest(method, fmt.Sprintf("%s%s", p.baseURL, endpoint), reqBody)
-	if err != nil {
-		return nil, err
+	p.ensureFreshToken(ctx)
+
+	token := p.token()
+	resp, err := p.send(ctx, method, endpoint, jsonData, token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || token == "" {
+		return resp, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	if p.authToken != "" {
-		req.Header.Set("Authorization", "Bearer "+p.authToken)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	if err := p.recoverAuth(ctx, token); err != nil {
+		return nil, err
 	}
+	return p.send(ctx, method, endpoint, jsonData, p.token())
+}
+
+// send performs a single logical request, retrying according to the
+// client's RetryPolicy. Cancelling ctx aborts both the request and any wait
+// between attempts.
+func (p *PocketBaseClient) send(ctx context.Context, method, endpoint string, jsonData []byte, token string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		var reqBody io.Reader
+		if jsonData != nil {
+			reqBody = bytes.NewReader(jsonData)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, method, fmt.Sprintf("%s%s", p.baseURL, endpoint), reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := p.httpClient.Do(req)
+		canRetry := attempt < p.retry.MaxRetries && ctx.Err() == nil
+
+		if err != nil {
+			if !canRetry || !idempotentMethod(method) {
+				return nil, err
+			}
+			if err := sleepContext(ctx, p.retry.backoff(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		retry := resp.StatusCode == http.StatusTooManyRequests ||
+			(retryableStatus(resp.StatusCode) && idempotentMethod(method))
+		if !canRetry || !retry {
+			return resp, nil
+		}
+
+		delay, ok := retryAfter(resp)
+		if !ok {
+			delay = p.retry.backoff(attempt)
+		}
 
-	return p.httpClient.Do(req)
+		// Drain the body so the connection can be reused
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
 }
 
-func (p *PocketBaseClient) CreateCredential(cred models.Credential) (*models.Credential, error) {
-	resp, err := p.doRequest("POST", "/api/collections/credentials/records", cred)
+func (p *PocketBaseClient) CreateCredential(ctx context.Context, cred models.Credential) (*models.Credential, error) {
+	resp, err := p.doRequest(ctx, "POST", "/api/collections/credentials/records", cred)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to create credential: %s", string(body))
+		return nil, apiError(resp, "create credential")
 	}
 
 	var created models.Credential
-	if err := json.Unmarshal(body, &created); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
 		return nil, err
 	}
 
 	return &created, nil
 }
 
-func (p *PocketBaseClient) GetCredential(id string) (*models.Credential, error) {
-	resp, err := p.doRequest("GET", fmt.Sprintf("/api/collections/credentials/records/%s", id), nil)
+func (p *PocketBaseClient) GetCredential(ctx context.Context, id string) (*models.Credential, error) {
+	resp, err := p.doRequest(ctx, "GET", fmt.Sprintf("/api/collections/credentials/records/%s", id), nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errCredentialNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("credential not found")
+		return nil, apiError(resp, "get credential")
 	}
 
 	var cred models.Credential
@@ -171,120 +274,194 @@ func (p *PocketBaseClient) GetCredential(id string) (*models.Credential, error)
 	return &cred, nil
 }
 
-func (p *PocketBaseClient) ListCredentials(search string) ([]models.Credential, error) {
-	endpoint := "/api/collections/credentials/records?perPage=500&sort=-created"
-	if search != "" {
-		endpoint += "&filter=" + url.QueryEscape(fmt.Sprintf(
-			"title~'%s' || username~'%s' || url~'%s' || category~'%s'",
-			search, search, search, search))
+func (p *PocketBaseClient) ListCredentials(ctx context.Context, filter Filter) ([]models.Credential, error) {
+	var creds []models.Credential
+	err := p.EachCredential(ctx, filter, func(cred models.Credential, loaded, total int) error {
+		creds = append(creds, cred)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+// EachCredential walks every page of matching credentials, newest first,
+// calling fn with each record and how far through the result set it is.
+func (p *PocketBaseClient) EachCredential(ctx context.Context, filter Filter, fn CredentialFunc) error {
+	query := ""
+	if !filter.IsEmpty() {
+		query = "&filter=" + url.QueryEscape(filter.PocketBase())
+	}
+
+	loaded := 0
+	for page := 1; ; page++ {
+		// Sorting on id as well keeps page boundaries stable for records
+		// created in the same millisecond
+		endpoint := fmt.Sprintf("/api/collections/credentials/records?page=%d&perPage=%d&sort=-created,id%s",
+			page, listPageSize, query)
+
+		listResp, err := p.listPage(ctx, endpoint)
+		if err != nil {
+			return err
+		}
+
+		for _, cred := range listResp.Items {
+			// Some conditions are widened when rendered for PocketBase, so
+			// check each record against the exact filter
+			if !filter.Match(cred) {
+				continue
+			}
+
+			loaded++
+			if err := fn(cred, loaded, listResp.TotalItems); err != nil {
+				return err
+			}
+		}
+
+		if len(listResp.Items) == 0 || page >= listResp.TotalPages {
+			return nil
+		}
 	}
+}
 
-	resp, err := p.doRequest("GET", endpoint, nil)
+func (p *PocketBaseClient) listPage(ctx context.Context, endpoint string) (*ListResponse, error) {
+	resp, err := p.doRequest(ctx, "GET", endpoint, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp, "list credentials")
+	}
+
 	var listResp ListResponse
 	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
 		return nil, err
 	}
 
-	return listResp.Items, nil
+	return &listResp, nil
 }
 
-func (p *PocketBaseClient) UpdateCredential(id string, cred models.Credential) (*models.Credential, error) {
-	resp, err := p.doRequest("PATCH", fmt.Sprintf("/api/collections/credentials/records/%s", id), cred)
+func (p *PocketBaseClient) UpdateCredential(ctx context.Context, id string, cred models.Credential) (*models.Credential, error) {
+	// PocketBase has no conditional update, so compare versions first. That
+	// leaves a short window between the read and the write, which is
+	// acceptable for edits made by hand.
+	if cred.Updated != "" {
+		current, err := p.GetCredential(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if current.Updated != cred.Updated {
+			return nil, &ConflictError{Current: *current}
+		}
+	}
+
+	resp, err := p.doRequest(ctx, "PATCH", fmt.Sprintf("/api/collections/credentials/records/%s", id), cred)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errCredentialNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to update credential: %s", string(body))
+		return nil, apiError(resp, "update credential")
 	}
 
 	var updated models.Credential
-	if err := json.Unmarshal(body, &updated); err != nil {
+	if err := json.NewDecoder(resp.Body).Decode(&updated); err != nil {
 		return nil, err
 	}
 
 	return &updated, nil
 }
 
-func (p *PocketBaseClient) DeleteCredential(id string) error {
-	resp, err := p.doRequest("DELETE", fmt.Sprintf("/api/collections/credentials/records/%s", id), nil)
+func (p *PocketBaseClient) DeleteCredential(ctx context.Context, id string) error {
+	resp, err := p.doRequest(ctx, "DELETE", fmt.Sprintf("/api/collections/credentials/records/%s", id), nil)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return errCredentialNotFound
+	}
 	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to delete credential")
+		return apiError(resp, "delete credential")
 	}
 
 	return nil
 }
 
-func (p *PocketBaseClient) SaveVaultConfig(config models.VaultConfig) error {
-	resp, err := p.doRequest("POST", "/api/collections/vault_config/records", config)
+func (p *PocketBaseClient) SaveVaultConfig(ctx context.Context, config models.VaultConfig) error {
+	resp, err := p.doRequest(ctx, "POST", "/api/collections/vault_config/records", config)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to save config: %s", string(body))
+		return apiError(resp, "save config")
 	}
 
 	return nil
 }
 
-func (p *PocketBaseClient) GetVaultConfig() (*models.VaultConfig, error) {
-	resp, err := p.doRequest("GET", "/api/collections/vault_config/records?perPage=1", nil)
+func (p *PocketBaseClient) GetVaultConfig(ctx context.Context) (*models.VaultConfig, error) {
+	resp, err := p.doRequest(ctx, "GET", "/api/collections/vault_config/records?perPage=1", nil)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp, "load vault config")
+	}
+
 	var listResp ConfigListResponse
 	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
 		return nil, err
 	}
 
 	if len(listResp.Items) == 0 {
-		return nil, fmt.Errorf("vault not initialized")
+		return nil, ErrNotInitialized
 	}
 
 	return &listResp.Items[0], nil
 }
 
-func (p *PocketBaseClient) UpdateVaultConfig(id string, config models.VaultConfig) error {
-	resp, err := p.doRequest("PATCH", fmt.Sprintf("/api/collections/vault_config/records/%s", id), config)
+func (p *PocketBaseClient) UpdateVaultConfig(ctx context.Context, id string, config models.VaultConfig) error {
+	resp, err := p.doRequest(ctx, "PATCH", fmt.Sprintf("/api/collections/vault_config/records/%s", id), config)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return errConfigNotFound
+	}
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to update config: %s", string(body))
+		return apiError(resp, "update config")
 	}
 
 	return nil
 }
 
-func (p *PocketBaseClient) GetCredentialCount() (int, error) {
-	resp, err := p.doRequest("GET", "/api/collections/credentials/records?perPage=1", nil)
+func (p *PocketBaseClient) GetCredentialCount(ctx context.Context) (int, error) {
+	resp, err := p.doRequest(ctx, "GET", "/api/collections/credentials/records?perPage=1", nil)
 	if err != nil {
 		return 0, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return 0, apiError(resp, "count credentials")
+	}
+
 	var listResp ListResponse
 	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
 		return 0, err
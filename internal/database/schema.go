@@ -0,0 +1,255 @@
+// internal/database/schema.go
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// schemaField describes a field the app needs in a PocketBase collection.
+type schemaField struct {
+	Name     string
+	Type     string
+	Required bool
+	Max      int
+}
+
+type collectionSpec struct {
+	Name   string
+	Fields []schemaField
+}
+
+// vaultSchema is the set of collections the app relies on. New fields are
+// added to existing deployments by EnsureSchema, so only append to these.
+var vaultSchema = []collectionSpec{
+	{
+		Name: "vault_config",
+		Fields: []schemaField{
+			{Name: "salt", Type: "text", Required: true},
+			{Name: "password_hash", Type: "text", Required: true},
+			{Name: "wrapped_key", Type: "text"},
+			{Name: "kdf", Type: "text"},
+			{Name: "cipher_version", Type: "number"},
+			{Name: "encrypt_metadata", Type: "bool"},
+			{Name: "key_file", Type: "bool"},
+			{Name: "recovery_public_key", Type: "text"},
+			{Name: "recovery_wrapped_key", Type: "text"},
+			{Name: "rotation", Type: "text"},
+		},
+	},
+	{
+		Name: "credentials",
+		Fields: []schemaField{
+			{Name: "title", Type: "text", Required: true},
+			{Name: "username", Type: "text"},
+			{Name: "encrypted_password", Type: "text", Required: true},
+			{Name: "url", Type: "text"},
+			{Name: "notes", Type: "text", Max: 100000},
+			{Name: "totp", Type: "text"},
+			{Name: "category", Type: "text"},
+			{Name: "metadata_encrypted", Type: "bool"},
+			{Name: "domain_index", Type: "text"},
+			{Name: "category_index", Type: "text"},
+		},
+	},
+}
+
+// remoteCollection holds the parts of a collection we inspect. PocketBase
+// 0.23 and later list fields under "fields"; older releases use "schema".
+// Fields are kept raw so they can be sent back untouched when patching.
+type remoteCollection struct {
+	ID     string                   `json:"id"`
+	Name   string                   `json:"name"`
+	Fields []map[string]interface{} `json:"fields"`
+	Schema []map[string]interface{} `json:"schema"`
+}
+
+func (c *remoteCollection) legacy() bool {
+	return c.Fields == nil && c.Schema != nil
+}
+
+func (c *remoteCollection) fieldList() []map[string]interface{} {
+	if c.legacy() {
+		return c.Schema
+	}
+	return c.Fields
+}
+
+func (c *remoteCollection) hasField(name string) bool {
+	for _, field := range c.fieldList() {
+		if field["name"] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldDefinition renders a field in the shape the server expects.
+func fieldDefinition(field schemaField, legacy bool) map[string]interface{} {
+	def := map[string]interface{}{
+		"name":     field.Name,
+		"type":     field.Type,
+		"required": field.Required,
+	}
+
+	if legacy {
+		options := map[string]interface{}{}
+		if field.Max > 0 {
+			options["max"] = field.Max
+		}
+		def["options"] = options
+	} else if field.Max > 0 {
+		def["max"] = field.Max
+	}
+
+	return def
+}
+
+// autodateFields are the created/updated timestamps. Older PocketBase
+// releases add them to every collection; newer ones only when asked.
+func autodateFields() []map[string]interface{} {
+	return []map[string]interface{}{
+		{"name": "created", "type": "autodate", "onCreate": true, "onUpdate": false},
+		{"name": "updated", "type": "autodate", "onCreate": true, "onUpdate": true},
+	}
+}
+
+// EnsureSchema creates any missing collections and adds fields that
+// existing collections lack. It needs superuser access and returns a
+// description of each change it made.
+func (p *PocketBaseClient) EnsureSchema(ctx context.Context) ([]string, error) {
+	var changes []string
+
+	for _, spec := range vaultSchema {
+		remote, err := p.getCollection(ctx, spec.Name)
+		if err != nil {
+			return changes, err
+		}
+
+		if remote == nil {
+			if err := p.createCollection(ctx, spec); err != nil {
+				return changes, err
+			}
+			changes = append(changes, fmt.Sprintf("created collection '%s'", spec.Name))
+
+			// Re-read it so fields the server ignored are caught below
+			remote, err = p.getCollection(ctx, spec.Name)
+			if err != nil {
+				return changes, err
+			}
+			if remote == nil {
+				return changes, fmt.Errorf("collection '%s' missing after creation", spec.Name)
+			}
+		}
+
+		added, err := p.upgradeCollection(ctx, remote, spec)
+		if err != nil {
+			return changes, err
+		}
+		for _, name := range added {
+			changes = append(changes, fmt.Sprintf("added field '%s' to '%s'", name, spec.Name))
+		}
+	}
+
+	return changes, nil
+}
+
+// getCollection returns nil without an error when the collection does not
+// exist.
+func (p *PocketBaseClient) getCollection(ctx context.Context, name string) (*remoteCollection, error) {
+	resp, err := p.doRequest(ctx, "GET", "/api/collections/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp, fmt.Sprintf("read collection '%s'", name))
+	}
+
+	var collection remoteCollection
+	if err := json.NewDecoder(resp.Body).Decode(&collection); err != nil {
+		return nil, err
+	}
+
+	return &collection, nil
+}
+
+func (p *PocketBaseClient) createCollection(ctx context.Context, spec collectionSpec) error {
+	fields := make([]map[string]interface{}, 0, len(spec.Fields)+2)
+	for _, field := range spec.Fields {
+		fields = append(fields, fieldDefinition(field, false))
+	}
+	fields = append(fields, autodateFields()...)
+
+	// API rules are left unset, which limits access to superusers
+	payload := map[string]interface{}{
+		"name":   spec.Name,
+		"type":   "base",
+		"fields": fields,
+	}
+
+	resp, err := p.doRequest(ctx, "POST", "/api/collections", payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp, fmt.Sprintf("create collection '%s'", spec.Name))
+	}
+
+	return nil
+}
+
+// upgradeCollection adds the spec's missing fields to remote, keeping every
+// existing field exactly as the server returned it.
+func (p *PocketBaseClient) upgradeCollection(ctx context.Context, remote *remoteCollection, spec collectionSpec) ([]string, error) {
+	legacy := remote.legacy()
+	fields := remote.fieldList()
+
+	var added []string
+	for _, field := range spec.Fields {
+		if !remote.hasField(field.Name) {
+			fields = append(fields, fieldDefinition(field, legacy))
+			added = append(added, field.Name)
+		}
+	}
+
+	if !legacy {
+		for _, field := range autodateFields() {
+			name := field["name"].(string)
+			if !remote.hasField(name) {
+				fields = append(fields, field)
+				added = append(added, name)
+			}
+		}
+	}
+
+	if len(added) == 0 {
+		return nil, nil
+	}
+
+	key := "fields"
+	if legacy {
+		key = "schema"
+	}
+
+	resp, err := p.doRequest(ctx, "PATCH", "/api/collections/"+remote.ID, map[string]interface{}{key: fields})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp, fmt.Sprintf("update collection '%s'", spec.Name))
+	}
+
+	return added, nil
+}
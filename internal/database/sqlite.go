@@ -0,0 +1,363 @@
+// internal/database/sqlite.go
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"passmanager/internal/models"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps the vault in an embedded SQLite database. Secrets are
+// encrypted per field exactly as with PocketBase; the database itself holds
+// the same records, just with real indexes behind them.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ VaultStore = (*SQLiteStore)(nil)
+
+// sqliteMigrations are applied in order. PRAGMA user_version records how
+// many of them a database has already seen, so only append to this list.
+var sqliteMigrations = []string{
+	`CREATE TABLE credentials (
+		id                 TEXT PRIMARY KEY,
+		title              TEXT NOT NULL,
+		username           TEXT NOT NULL DEFAULT '',
+		encrypted_password TEXT NOT NULL,
+		url                TEXT NOT NULL DEFAULT '',
+		notes              TEXT NOT NULL DEFAULT '',
+		category           TEXT NOT NULL DEFAULT '',
+		created            TEXT NOT NULL,
+		updated            TEXT NOT NULL
+	);
+	CREATE INDEX idx_credentials_category ON credentials (category);
+	CREATE INDEX idx_credentials_updated ON credentials (updated);
+	CREATE INDEX idx_credentials_created ON credentials (created);
+
+	CREATE TABLE vault_config (
+		id            TEXT PRIMARY KEY,
+		salt          TEXT NOT NULL,
+		password_hash TEXT NOT NULL,
+		created       TEXT NOT NULL,
+		updated       TEXT NOT NULL
+	);`,
+	`ALTER TABLE vault_config ADD COLUMN wrapped_key TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE vault_config ADD COLUMN kdf TEXT NOT NULL DEFAULT '';
+	ALTER TABLE vault_config ADD COLUMN cipher_version INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE credentials ADD COLUMN metadata_encrypted INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE credentials ADD COLUMN domain_index TEXT NOT NULL DEFAULT '';
+	ALTER TABLE credentials ADD COLUMN category_index TEXT NOT NULL DEFAULT '';
+	CREATE INDEX idx_credentials_domain_index ON credentials (domain_index);
+	CREATE INDEX idx_credentials_category_index ON credentials (category_index);
+	ALTER TABLE vault_config ADD COLUMN encrypt_metadata INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE credentials ADD COLUMN totp TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE vault_config ADD COLUMN key_file INTEGER NOT NULL DEFAULT 0;`,
+	`ALTER TABLE vault_config ADD COLUMN recovery_public_key TEXT NOT NULL DEFAULT '';
+	ALTER TABLE vault_config ADD COLUMN recovery_wrapped_key TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE vault_config ADD COLUMN rotation TEXT NOT NULL DEFAULT '';`,
+}
+
+const credentialColumns = "id, title, username, encrypted_password, url, notes, totp, category, metadata_encrypted, domain_index, category_index, created, updated"
+
+// OpenSQLiteStore opens (or creates) the database at path and brings its
+// schema up to date.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	// Secrets are encrypted, but titles and usernames are not, and the WAL
+	// holds recent pages of the database too
+	for _, name := range []string{path, path + "-wal", path + "-shm"} {
+		if err := createPrivate(name); err != nil {
+			return nil, fmt.Errorf("failed to open database: %w", err)
+		}
+	}
+
+	// A URI, so that the path may contain ? or #
+	uriPath := filepath.ToSlash(path)
+	if filepath.VolumeName(path) != "" {
+		uriPath = "/" + uriPath // file:///C:/...
+	}
+	dsn := url.URL{
+		Scheme:   "file",
+		Path:     uriPath,
+		RawQuery: "_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)",
+	}
+	db, err := sql.Open("sqlite", dsn.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	store := &SQLiteStore{db: db}
+	if err := store.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// createPrivate creates the file at path readable by its owner only, or
+// makes an existing one so. SQLite leaves the files it finds in place.
+func createPrivate(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Chmod(path, 0600)
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this build supports", version)
+	}
+
+	for i := version; i < len(sqliteMigrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(sqliteMigrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCredential(row rowScanner) (*models.Credential, error) {
+	var cred models.Credential
+	err := row.Scan(
+		&cred.ID,
+		&cred.Title,
+		&cred.Username,
+		&cred.EncryptedPassword,
+		&cred.URL,
+		&cred.Notes,
+		&cred.TOTP,
+		&cred.Category,
+		&cred.MetadataEncrypted,
+		&cred.DomainIndex,
+		&cred.CategoryIndex,
+		&cred.Created,
+		&cred.Updated,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &cred, nil
+}
+
+func (s *SQLiteStore) CreateCredential(ctx context.Context, cred models.Credential) (*models.Credential, error) {
+	if cred.ID == "" {
+		id, err := NewRecordID()
+		if err != nil {
+			return nil, err
+		}
+		cred.ID = id
+	}
+
+	now := timestampNow()
+	cred.Created = now
+	cred.Updated = now
+
+	_, err := s.db.ExecContext(ctx,
+		"INSERT INTO credentials ("+credentialColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		cred.ID, cred.Title, cred.Username, cred.EncryptedPassword,
+		cred.URL, cred.Notes, cred.TOTP, cred.Category, cred.MetadataEncrypted, cred.DomainIndex,
+		cred.CategoryIndex, cred.Created, cred.Updated,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create credential: %w", err)
+	}
+
+	return &cred, nil
+}
+
+func (s *SQLiteStore) GetCredential(ctx context.Context, id string) (*models.Credential, error) {
+	row := s.db.QueryRowContext(ctx, "SELECT "+credentialColumns+" FROM credentials WHERE id = ?", id)
+
+	cred, err := scanCredential(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errCredentialNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+func (s *SQLiteStore) ListCredentials(ctx context.Context, filter Filter) ([]models.Credential, error) {
+	query := "SELECT " + credentialColumns + " FROM credentials"
+
+	// LIKE is case-insensitive for ASCII, matching PocketBase's ~ operator
+	where, args := filter.SQL()
+	if where != "" {
+		query += " WHERE " + where
+	}
+	query += " ORDER BY created DESC"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var creds []models.Credential
+	for rows.Next() {
+		cred, err := scanCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+		creds = append(creds, *cred)
+	}
+
+	return creds, rows.Err()
+}
+
+func (s *SQLiteStore) EachCredential(ctx context.Context, filter Filter, fn CredentialFunc) error {
+	creds, err := s.ListCredentials(ctx, filter)
+	return eachInList(creds, err, fn)
+}
+
+func (s *SQLiteStore) UpdateCredential(ctx context.Context, id string, cred models.Credential) (*models.Credential, error) {
+	expected := cred.Updated
+	cred.ID = id
+	cred.Updated = nextTimestamp(expected)
+
+	// The version check is part of the UPDATE, so it is atomic
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE credentials SET title = ?, username = ?, encrypted_password = ?,
+			url = ?, notes = ?, totp = ?, category = ?, metadata_encrypted = ?,
+			domain_index = ?, category_index = ?, updated = ?
+			WHERE id = ? AND (? = '' OR updated = ?)`,
+		cred.Title, cred.Username, cred.EncryptedPassword,
+		cred.URL, cred.Notes, cred.TOTP, cred.Category, cred.MetadataEncrypted,
+		cred.DomainIndex, cred.CategoryIndex, cred.Updated, id, expected, expected,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update credential: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		current, err := s.GetCredential(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return nil, &ConflictError{Current: *current}
+	}
+
+	return s.GetCredential(ctx, id)
+}
+
+func (s *SQLiteStore) DeleteCredential(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, "DELETE FROM credentials WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete credential: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errCredentialNotFound
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) GetCredentialCount(ctx context.Context) (int, error) {
+	var count int
+	if err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM credentials").Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (s *SQLiteStore) SaveVaultConfig(ctx context.Context, config models.VaultConfig) error {
+	id, err := NewRecordID()
+	if err != nil {
+		return err
+	}
+
+	now := timestampNow()
+	_, err = s.db.ExecContext(ctx,
+		"INSERT INTO vault_config (id, salt, password_hash, wrapped_key, kdf, cipher_version, encrypt_metadata, key_file, recovery_public_key, recovery_wrapped_key, rotation, created, updated) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		id, config.Salt, config.PasswordHash, config.WrappedKey, config.KDF, config.CipherVersion, config.EncryptMetadata, config.KeyFile, config.RecoveryPublicKey, config.RecoveryWrappedKey, config.Rotation, now, now,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStore) GetVaultConfig(ctx context.Context) (*models.VaultConfig, error) {
+	var config models.VaultConfig
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, salt, password_hash, wrapped_key, kdf, cipher_version, encrypt_metadata, key_file, recovery_public_key, recovery_wrapped_key, rotation, created, updated FROM vault_config ORDER BY created LIMIT 1",
+	).Scan(&config.ID, &config.Salt, &config.PasswordHash, &config.WrappedKey, &config.KDF, &config.CipherVersion, &config.EncryptMetadata, &config.KeyFile, &config.RecoveryPublicKey, &config.RecoveryWrappedKey, &config.Rotation, &config.Created, &config.Updated)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotInitialized
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func (s *SQLiteStore) UpdateVaultConfig(ctx context.Context, id string, config models.VaultConfig) error {
+	result, err := s.db.ExecContext(ctx,
+		"UPDATE vault_config SET salt = ?, password_hash = ?, wrapped_key = ?, kdf = ?, cipher_version = ?, encrypt_metadata = ?, key_file = ?, recovery_public_key = ?, recovery_wrapped_key = ?, rotation = ?, updated = ? WHERE id = ?",
+		config.Salt, config.PasswordHash, config.WrappedKey, config.KDF, config.CipherVersion, config.EncryptMetadata, config.KeyFile, config.RecoveryPublicKey, config.RecoveryWrappedKey, config.Rotation, timestampNow(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update config: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errConfigNotFound
+	}
+
+	return nil
+}
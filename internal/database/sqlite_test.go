@@ -0,0 +1,56 @@
+// internal/database/sqlite_test.go
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"passmanager/internal/models"
+)
+
+func TestSQLiteStorePath(t *testing.T) {
+	ctx := context.Background()
+
+	for _, name := range []string{"vault.db", "what?.db", "#1 vault.db", "100% vault.db"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, name)
+
+			store, err := OpenSQLiteStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			if _, err := store.CreateCredential(ctx, models.Credential{Title: "example"}); err != nil {
+				t.Fatal(err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]bool{name: true, name + "-wal": true, name + "-shm": true}
+			for _, entry := range entries {
+				if !want[entry.Name()] {
+					t.Errorf("unexpected file %q next to the database", entry.Name())
+					continue
+				}
+				delete(want, entry.Name())
+
+				info, err := entry.Info()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if perm := info.Mode().Perm(); runtime.GOOS != "windows" && perm != 0600 {
+					t.Errorf("%s has mode %v, want 0600", entry.Name(), perm)
+				}
+			}
+			for missing := range want {
+				t.Errorf("%s was not created", missing)
+			}
+		})
+	}
+}
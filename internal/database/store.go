@@ -0,0 +1,111 @@
+// internal/database/store.go
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"time"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/models"
+)
+
+// VaultStore is the storage backend the session and commands talk to.
+// PocketBaseClient is one implementation; anything that can persist
+// credentials and the vault configuration can stand in for it.
+type VaultStore interface {
+	// CreateCredential keeps cred.ID if it is set, otherwise assigns one
+	CreateCredential(ctx context.Context, cred models.Credential) (*models.Credential, error)
+	GetCredential(ctx context.Context, id string) (*models.Credential, error)
+	ListCredentials(ctx context.Context, filter Filter) ([]models.Credential, error)
+	EachCredential(ctx context.Context, filter Filter, fn CredentialFunc) error
+	// UpdateCredential fails with a *ConflictError when cred.Updated is set
+	// and the stored record has changed since then. Leave it empty to
+	// overwrite unconditionally.
+	UpdateCredential(ctx context.Context, id string, cred models.Credential) (*models.Credential, error)
+	DeleteCredential(ctx context.Context, id string) error
+	GetCredentialCount(ctx context.Context) (int, error)
+
+	GetVaultConfig(ctx context.Context) (*models.VaultConfig, error)
+	SaveVaultConfig(ctx context.Context, config models.VaultConfig) error
+	UpdateVaultConfig(ctx context.Context, id string, config models.VaultConfig) error
+}
+
+// CredentialFunc receives each record from EachCredential along with how
+// many records have been delivered so far and the total expected. Returning
+// an error stops the walk.
+type CredentialFunc func(cred models.Credential, loaded, total int) error
+
+// PassphraseStore is implemented by backends that encrypt their storage
+// with the master password. When the password changes, the new vault config
+// and the key derived from the new password with config's salt and KDF must
+// be persisted together.
+type PassphraseStore interface {
+	ChangePassphrase(ctx context.Context, key *crypto.DerivedKey, config models.VaultConfig) error
+}
+
+var _ VaultStore = (*PocketBaseClient)(nil)
+
+const recordIDAlphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+// NewRecordID returns a random 15 character ID in the same shape as the
+// IDs PocketBase generates. Callers that encrypt fields bound to the record
+// ID set it on the credential before CreateCredential.
+func NewRecordID() (string, error) {
+	buf := make([]byte, 15)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	// 252 is the largest multiple of 36 below 256; rejecting anything above
+	// it keeps every character equally likely.
+	id := make([]byte, 0, len(buf))
+	for len(id) < len(buf) {
+		for _, b := range buf {
+			if b < 252 && len(id) < len(buf) {
+				id = append(id, recordIDAlphabet[int(b)%len(recordIDAlphabet)])
+			}
+		}
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+	}
+
+	return string(id), nil
+}
+
+// eachInList feeds an already loaded result set through fn, for backends
+// that have no paging of their own.
+func eachInList(creds []models.Credential, err error, fn CredentialFunc) error {
+	if err != nil {
+		return err
+	}
+
+	for i, cred := range creds {
+		if err := fn(cred, i+1, len(creds)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// timestampFormat is the layout PocketBase uses for its datetime fields.
+const timestampFormat = "2006-01-02 15:04:05.000Z"
+
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampFormat)
+}
+
+func timestampNow() string {
+	return formatTimestamp(time.Now())
+}
+
+// nextTimestamp returns the current time, or just after prev if the clock
+// has not moved past it, so every update changes the version.
+func nextTimestamp(prev string) string {
+	now := time.Now().UTC()
+	if last, err := time.Parse(timestampFormat, prev); err == nil && !now.After(last) {
+		now = last.Add(time.Millisecond)
+	}
+	return formatTimestamp(now)
+}
@@ -1,6 +1,8 @@
 // internal/models/credential.go
 package models
 
+import "passmanager/internal/crypto"
+
 type Credential struct {
 	ID                string `json:"id,omitempty"`
 	Title             string `json:"title"`
@@ -8,25 +10,48 @@ type Credential struct {
 	EncryptedPassword string `json:"encrypted_password"`
 	URL               string `json:"url,omitempty"`
 	Notes             string `json:"notes,omitempty"`
+	TOTP              string `json:"totp,omitempty"` // encrypted otpauth:// URI
 	Category          string `json:"category,omitempty"`
+	// With MetadataEncrypted set, Title, Username, URL and Category are
+	// ciphertexts and the indexes allow exact matching on the server
+	MetadataEncrypted bool   `json:"metadata_encrypted"`
+	DomainIndex       string `json:"domain_index"`
+	CategoryIndex     string `json:"category_index"`
 	Created           string `json:"created,omitempty"`
 	Updated           string `json:"updated,omitempty"`
 }
 
 type VaultConfig struct {
-	ID           string `json:"id,omitempty"`
-	Salt         string `json:"salt"`
-	PasswordHash string `json:"password_hash"`
-	Created      string `json:"created,omitempty"`
-	Updated      string `json:"updated,omitempty"`
+	ID              string `json:"id,omitempty"`
+	Salt            string `json:"salt"`
+	PasswordHash    string `json:"password_hash"`
+	WrappedKey      string `json:"wrapped_key,omitempty"`
+	KDF             string `json:"kdf,omitempty"`
+	CipherVersion   int    `json:"cipher_version,omitempty"` // oldest ciphertext format still in use
+	EncryptMetadata bool   `json:"encrypt_metadata"`
+	KeyFile         bool   `json:"key_file"` // unlocking also needs a key file
+	Created         string `json:"created,omitempty"`
+	Updated         string `json:"updated,omitempty"`
+
+	// Set when a recovery key can unlock the vault: its public half, and
+	// the data key wrapped for it
+	RecoveryPublicKey  string `json:"recovery_public_key"`
+	RecoveryWrappedKey string `json:"recovery_wrapped_key"`
+
+	// When a data key rotation started, until it is committed or rolled
+	// back. The journal is only on the device running it; this tells the
+	// others sharing the vault to leave its keys alone meanwhile.
+	Rotation string `json:"rotation"`
 }
 
 type AppSettings struct {
 	SessionTimeout   int    `json:"session_timeout_minutes"`
 	ClipboardTimeout int    `json:"clipboard_timeout_seconds"`
 	DefaultCategory  string `json:"default_category"`
-	PasswordLength   int    `json:"password_length"`
-	IncludeSymbols   bool   `json:"include_symbols"`
+	MaxRetries       int    `json:"max_retries"`
+
+	// Used for generated passwords unless a different one is asked for
+	PasswordPolicy crypto.PasswordPolicy `json:"password_policy"`
 }
 
 func DefaultSettings() *AppSettings {
@@ -34,7 +59,7 @@ func DefaultSettings() *AppSettings {
 		SessionTimeout:   5,
 		ClipboardTimeout: 30,
 		DefaultCategory:  "general",
-		PasswordLength:   20,
-		IncludeSymbols:   true,
+		MaxRetries:       3,
+		PasswordPolicy:   crypto.DefaultPolicy(),
 	}
-}
\ No newline at end of file
+}
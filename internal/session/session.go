@@ -2,21 +2,30 @@
 package session
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"sync"
 	"time"
 
 	"passmanager/internal/crypto"
 	"passmanager/internal/database"
+	"passmanager/internal/models"
 )
 
 type Session struct {
 	mu              sync.RWMutex
 	isAuthenticated bool
 	cryptoService   *crypto.CryptoService
-	dbClient        *database.PocketBaseClient
+	store           database.VaultStore
 	lastActivity    time.Time
 	timeout         time.Duration
 	salt            []byte
+	vaultConfig     models.VaultConfig
+	reauthPrompt    database.ReauthFunc
+
+	// Decrypted secrets handed out while unlocked, wiped on logout
+	secrets []*crypto.SecureBuffer
 }
 
 var (
@@ -33,15 +42,40 @@ func GetSession() *Session {
 	return currentSession
 }
 
-func (s *Session) Login(client *database.PocketBaseClient, cryptoSvc *crypto.CryptoService, salt []byte) {
+func (s *Session) Login(store database.VaultStore, cryptoSvc *crypto.CryptoService, salt []byte) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	s.isAuthenticated = true
-	s.dbClient = client
+	s.store = store
 	s.cryptoService = cryptoSvc
 	s.salt = salt
 	s.lastActivity = time.Now()
+
+	if r, ok := store.(database.Reauthenticator); ok {
+		r.SetReauthFunc(s.reauthenticate)
+	}
+}
+
+// SetReauthPrompt sets how the user is asked to sign in to the server again
+// when its login expires during an unlocked session.
+func (s *Session) SetReauthPrompt(prompt database.ReauthFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reauthPrompt = prompt
+}
+
+// reauthenticate only renews the server login; the unlocked crypto state is
+// left alone, so the vault stays open.
+func (s *Session) reauthenticate(ctx context.Context, identity string) (string, error) {
+	s.mu.RLock()
+	prompt, authenticated := s.reauthPrompt, s.isAuthenticated
+	s.mu.RUnlock()
+
+	if !authenticated || prompt == nil {
+		return "", fmt.Errorf("vault is locked")
+	}
+	return prompt(ctx, identity)
 }
 
 func (s *Session) Logout() {
@@ -51,10 +85,35 @@ func (s *Session) Logout() {
 	if s.cryptoService != nil {
 		s.cryptoService.SecureClear()
 	}
+	for _, secret := range s.secrets {
+		secret.Destroy()
+	}
+	if closer, ok := s.store.(io.Closer); ok {
+		closer.Close()
+	}
 	s.isAuthenticated = false
 	s.cryptoService = nil
-	s.dbClient = nil
+	s.store = nil
 	s.salt = nil
+	s.vaultConfig = models.VaultConfig{}
+	s.secrets = nil
+}
+
+// Track makes secret part of the session, so it is destroyed when the vault
+// locks if it has not been already. It returns secret for convenience.
+func (s *Session) Track(secret *crypto.SecureBuffer) *crypto.SecureBuffer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop buffers that were already destroyed so the list stays short
+	live := s.secrets[:0]
+	for _, tracked := range s.secrets {
+		if !tracked.Destroyed() {
+			live = append(live, tracked)
+		}
+	}
+	s.secrets = append(live, secret)
+	return secret
 }
 
 func (s *Session) IsAuthenticated() bool {
@@ -89,11 +148,11 @@ func (s *Session) GetCrypto() *crypto.CryptoService {
 	return s.cryptoService
 }
 
-func (s *Session) GetDB() *database.PocketBaseClient {
+func (s *Session) GetDB() database.VaultStore {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	s.lastActivity = time.Now()
-	return s.dbClient
+	return s.store
 }
 
 func (s *Session) GetSalt() []byte {
@@ -102,6 +161,20 @@ func (s *Session) GetSalt() []byte {
 	return s.salt
 }
 
+// SetVaultConfig records the config of the unlocked vault, which decides
+// how records are encrypted and which ciphertext formats are accepted.
+func (s *Session) SetVaultConfig(cfg models.VaultConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vaultConfig = cfg
+}
+
+func (s *Session) GetVaultConfig() models.VaultConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.vaultConfig
+}
+
 func (s *Session) SetTimeout(duration time.Duration) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
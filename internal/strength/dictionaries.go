@@ -0,0 +1,78 @@
+// internal/strength/dictionaries.go
+package strength
+
+import (
+	"embed"
+	"strings"
+	"sync"
+)
+
+// Frequency lists from zxcvbn, most common first. The rank of a word is
+// its line number. They are MIT licensed; see data/LICENSE.
+//
+//go:embed data/*.txt
+var dataFiles embed.FS
+
+const (
+	DictPasswords   = "passwords"
+	DictEnglish     = "english"
+	DictMaleNames   = "male_names"
+	DictFemaleNames = "female_names"
+	DictSurnames    = "surnames"
+	DictUserInputs  = "user_inputs"
+)
+
+type rankedDict struct {
+	name   string
+	ranks  map[string]int
+	maxLen int
+}
+
+var (
+	dictsOnce sync.Once
+	dicts     []rankedDict
+)
+
+func builtinDicts() []rankedDict {
+	dictsOnce.Do(func() {
+		for _, name := range []string{DictPasswords, DictEnglish, DictMaleNames, DictFemaleNames, DictSurnames} {
+			data, err := dataFiles.ReadFile("data/" + name + ".txt")
+			if err != nil {
+				continue
+			}
+			dicts = append(dicts, newRankedDict(name, strings.Split(strings.TrimSpace(string(data)), "\n")))
+		}
+	})
+	return dicts
+}
+
+func newRankedDict(name string, words []string) rankedDict {
+	d := rankedDict{name: name, ranks: make(map[string]int, len(words))}
+	for i, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if word == "" {
+			continue
+		}
+		if _, ok := d.ranks[word]; !ok {
+			d.ranks[word] = i + 1
+			d.maxLen = max(d.maxLen, len([]rune(word)))
+		}
+	}
+	return d
+}
+
+// l33tTable lists the characters commonly substituted for each letter.
+var l33tTable = map[rune][]rune{
+	'a': {'4', '@'},
+	'b': {'8'},
+	'c': {'(', '{', '[', '<'},
+	'e': {'3'},
+	'g': {'6', '9'},
+	'i': {'1', '!', '|'},
+	'l': {'1', '|', '7'},
+	'o': {'0'},
+	's': {'$', '5'},
+	't': {'+', '7'},
+	'x': {'%'},
+	'z': {'2'},
+}
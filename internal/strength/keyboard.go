@@ -0,0 +1,91 @@
+// internal/strength/keyboard.go
+package strength
+
+import "sync"
+
+// A keyboard maps each key to its neighbours, indexed by direction, so a
+// change of direction in a pattern counts as a turn.
+type keyboard struct {
+	name      string
+	neighbors map[rune]map[rune]int
+	shifted   map[rune]bool
+	starts    float64 // number of keys
+	degree    float64 // average number of neighbours
+}
+
+var (
+	keyboardsOnce sync.Once
+	keyboards     []*keyboard
+)
+
+func allKeyboards() []*keyboard {
+	keyboardsOnce.Do(func() {
+		keyboards = []*keyboard{
+			// Each row sits half a key to the right of the one above
+			newKeyboard("qwerty", true,
+				[]string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"},
+				[]string{"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?"}),
+			newKeyboard("keypad", false,
+				[]string{"/*-", "789+", "456", "123", "0."},
+				nil),
+		}
+	})
+	return keyboards
+}
+
+func newKeyboard(name string, slanted bool, rows, shiftedRows []string) *keyboard {
+	kb := &keyboard{name: name, neighbors: map[rune]map[rune]int{}, shifted: map[rune]bool{}}
+
+	grid := make([][]rune, len(rows))
+	shiftGrid := make([][]rune, len(rows))
+	for r, row := range rows {
+		grid[r] = []rune(row)
+		if shiftedRows != nil {
+			shiftGrid[r] = []rune(shiftedRows[r])
+		}
+	}
+
+	// Neighbour offsets as (row, column) pairs, in a fixed order
+	dirs := [][2]int{{0, -1}, {0, 1}, {-1, -1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 0}, {1, 1}}
+	if slanted {
+		dirs = [][2]int{{0, -1}, {0, 1}, {-1, 0}, {-1, 1}, {1, -1}, {1, 0}}
+	}
+
+	keyAt := func(r, c int) []rune {
+		if r < 0 || r >= len(grid) || c < 0 || c >= len(grid[r]) {
+			return nil
+		}
+		keys := []rune{grid[r][c]}
+		if shiftGrid[r] != nil && c < len(shiftGrid[r]) {
+			keys = append(keys, shiftGrid[r][c])
+		}
+		return keys
+	}
+
+	positions, degrees := 0, 0
+	for r := range grid {
+		for c := range grid[r] {
+			positions++
+			for d, dir := range dirs {
+				if keyAt(r+dir[0], c+dir[1]) != nil {
+					degrees++
+				}
+				for _, key := range keyAt(r, c) {
+					if kb.neighbors[key] == nil {
+						kb.neighbors[key] = map[rune]int{}
+					}
+					for _, n := range keyAt(r+dir[0], c+dir[1]) {
+						kb.neighbors[key][n] = d
+					}
+				}
+			}
+			if keys := keyAt(r, c); len(keys) > 1 {
+				kb.shifted[keys[1]] = true
+			}
+		}
+	}
+
+	kb.starts = float64(len(kb.neighbors))
+	kb.degree = float64(degrees) / float64(positions)
+	return kb
+}
@@ -0,0 +1,503 @@
+// internal/strength/matching.go
+package strength
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type Pattern string
+
+const (
+	PatternDictionary Pattern = "dictionary"
+	PatternSpatial    Pattern = "spatial"
+	PatternRepeat     Pattern = "repeat"
+	PatternSequence   Pattern = "sequence"
+	PatternDate       Pattern = "date"
+	PatternYear       Pattern = "year"
+	PatternBruteforce Pattern = "bruteforce"
+)
+
+// Match is a part of a password that an attacker could guess as a unit.
+type Match struct {
+	Pattern    Pattern
+	Token      string
+	Dictionary string // for PatternDictionary
+
+	i, j    int // token is the password's runes i..j inclusive
+	guesses float64
+
+	// Dictionary matches
+	word     string
+	rank     int
+	reversed bool
+	l33tSubs map[rune]rune // substituted character to the letter it stands for
+
+	// Spatial matches
+	graph   *keyboard
+	turns   int
+	shifted int
+
+	// Repeat matches
+	baseToken   string
+	baseGuesses float64
+	repeats     int
+
+	// Sequence matches
+	ascending bool
+
+	// Date and year matches
+	year      int
+	separator string
+}
+
+func (m Match) length() int {
+	return m.j - m.i + 1
+}
+
+// omnimatch finds every pattern in password. userDict holds words such as
+// the account's email that make a password easier to guess.
+func omnimatch(password []rune, userDict *rankedDict) []Match {
+	dicts := builtinDicts()
+	if userDict != nil {
+		dicts = append(append([]rankedDict(nil), dicts...), *userDict)
+	}
+
+	var matches []Match
+	matches = append(matches, dictionaryMatches(password, dicts)...)
+	matches = append(matches, reverseDictionaryMatches(password, dicts)...)
+	matches = append(matches, l33tMatches(password, dicts)...)
+	matches = append(matches, spatialMatches(password)...)
+	matches = append(matches, repeatMatches(password, userDict)...)
+	matches = append(matches, sequenceMatches(password)...)
+	matches = append(matches, dateMatches(password)...)
+	matches = append(matches, yearMatches(password)...)
+
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].i != matches[b].i {
+			return matches[a].i < matches[b].i
+		}
+		return matches[a].j < matches[b].j
+	})
+	return matches
+}
+
+func lowerRunes(s []rune) []rune {
+	lower := make([]rune, len(s))
+	for i, r := range s {
+		lower[i] = unicode.ToLower(r)
+	}
+	return lower
+}
+
+func dictionaryMatches(password []rune, dicts []rankedDict) []Match {
+	lower := lowerRunes(password)
+
+	var matches []Match
+	for _, dict := range dicts {
+		for i := range lower {
+			for j := i; j < len(lower) && j-i < dict.maxLen; j++ {
+				word := string(lower[i : j+1])
+				if rank, ok := dict.ranks[word]; ok {
+					matches = append(matches, Match{
+						Pattern:    PatternDictionary,
+						Token:      string(password[i : j+1]),
+						Dictionary: dict.name,
+						i:          i,
+						j:          j,
+						word:       word,
+						rank:       rank,
+					})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func reverseDictionaryMatches(password []rune, dicts []rankedDict) []Match {
+	reversed := reverseRunes(password)
+
+	var matches []Match
+	for _, m := range dictionaryMatches(reversed, dicts) {
+		// Single characters and palindromes are already found forwards
+		if m.length() < 2 || m.word == reverseString(m.word) {
+			continue
+		}
+		m.Token = reverseString(m.Token)
+		m.i, m.j = len(password)-1-m.j, len(password)-1-m.i
+		m.reversed = true
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// l33tMatches finds dictionary words written with substitutions such as
+// "p@ssw0rd".
+func l33tMatches(password []rune, dicts []rankedDict) []Match {
+	var matches []Match
+	for _, subs := range l33tSubstitutions(password) {
+		translated := make([]rune, len(password))
+		for i, r := range password {
+			if letter, ok := subs[r]; ok {
+				translated[i] = letter
+			} else {
+				translated[i] = r
+			}
+		}
+
+		for _, m := range dictionaryMatches(translated, dicts) {
+			token := password[m.i : m.j+1]
+
+			// Only the substitutions that appear in this token count
+			used := map[rune]rune{}
+			for _, r := range token {
+				if letter, ok := subs[r]; ok {
+					used[r] = letter
+				}
+			}
+			if len(used) == 0 || m.length() < 2 {
+				continue
+			}
+
+			m.Token = string(token)
+			m.l33tSubs = used
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// l33tSubstitutions returns the ways the substitutable characters in
+// password could be read back as letters. Characters like '1' that stand
+// for more than one letter give several alternatives.
+func l33tSubstitutions(password []rune) []map[rune]rune {
+	candidates := map[rune][]rune{}
+	for letter, subs := range l33tTable {
+		for _, sub := range subs {
+			if strings.ContainsRune(string(password), sub) {
+				candidates[sub] = append(candidates[sub], letter)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	keys := make([]rune, 0, len(candidates))
+	for sub := range candidates {
+		keys = append(keys, sub)
+		sort.Slice(candidates[sub], func(a, b int) bool { return candidates[sub][a] < candidates[sub][b] })
+	}
+	sort.Slice(keys, func(a, b int) bool { return keys[a] < keys[b] })
+
+	const maxVariants = 64
+	variants := []map[rune]rune{{}}
+	for _, sub := range keys {
+		var next []map[rune]rune
+		for _, variant := range variants {
+			for _, letter := range candidates[sub] {
+				if len(next) >= maxVariants {
+					break
+				}
+				v := make(map[rune]rune, len(variant)+1)
+				for k, l := range variant {
+					v[k] = l
+				}
+				v[sub] = letter
+				next = append(next, v)
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+// spatialMatches finds runs of neighbouring keys such as "qwerty" or
+// "zxcvfr".
+func spatialMatches(password []rune) []Match {
+	var matches []Match
+	for _, kb := range allKeyboards() {
+		i := 0
+		for i < len(password)-2 {
+			j := i
+			turns, lastDir := 0, -1
+			shifted := 0
+			if kb.shifted[password[i]] {
+				shifted++
+			}
+
+			for j+1 < len(password) {
+				dir, ok := kb.neighbors[password[j]][password[j+1]]
+				if !ok {
+					break
+				}
+				if dir != lastDir {
+					turns++
+					lastDir = dir
+				}
+				if kb.shifted[password[j+1]] {
+					shifted++
+				}
+				j++
+			}
+
+			if j-i >= 2 {
+				matches = append(matches, Match{
+					Pattern: PatternSpatial,
+					Token:   string(password[i : j+1]),
+					i:       i,
+					j:       j,
+					graph:   kb,
+					turns:   turns,
+					shifted: shifted,
+				})
+				i = j
+			} else {
+				i++
+			}
+		}
+	}
+	return matches
+}
+
+// repeatMatches finds a character or group of characters repeated back to
+// back, such as "aaa" or "abcabc".
+func repeatMatches(password []rune, userDict *rankedDict) []Match {
+	var matches []Match
+	i := 0
+	for i < len(password)-1 {
+		bestBase, bestRepeats := 0, 0
+		for base := 1; i+2*base <= len(password); base++ {
+			repeats := 1
+			for i+(repeats+1)*base <= len(password) &&
+				string(password[i+repeats*base:i+(repeats+1)*base]) == string(password[i:i+base]) {
+				repeats++
+			}
+			if repeats >= 2 && base*repeats > bestBase*bestRepeats {
+				bestBase, bestRepeats = base, repeats
+			}
+		}
+
+		if bestRepeats < 2 {
+			i++
+			continue
+		}
+
+		j := i + bestBase*bestRepeats - 1
+		base := password[i : i+bestBase]
+		matches = append(matches, Match{
+			Pattern:     PatternRepeat,
+			Token:       string(password[i : j+1]),
+			i:           i,
+			j:           j,
+			baseToken:   string(base),
+			baseGuesses: mostGuessableSequence(base, omnimatch(base, userDict)).guesses,
+			repeats:     bestRepeats,
+		})
+		i = j + 1
+	}
+	return matches
+}
+
+const maxSequenceDelta = 5
+
+// sequenceMatches finds runs with a constant step such as "abcd", "9753"
+// or "ZYX".
+func sequenceMatches(password []rune) []Match {
+	if len(password) < 3 {
+		return nil
+	}
+
+	var matches []Match
+	emit := func(i, j, delta int) {
+		if j-i < 2 || delta == 0 || abs(delta) > maxSequenceDelta {
+			return
+		}
+		for k := i + 1; k <= j; k++ {
+			if !sameClass(password[k], password[i]) {
+				return
+			}
+		}
+		matches = append(matches, Match{
+			Pattern:   PatternSequence,
+			Token:     string(password[i : j+1]),
+			i:         i,
+			j:         j,
+			ascending: delta > 0,
+		})
+	}
+
+	i := 0
+	lastDelta := 0
+	for k := 1; k < len(password); k++ {
+		delta := int(password[k]) - int(password[k-1])
+		if k == 1 {
+			lastDelta = delta
+		}
+		if delta == lastDelta {
+			continue
+		}
+		emit(i, k-1, lastDelta)
+		i = k - 1
+		lastDelta = delta
+	}
+	emit(i, len(password)-1, lastDelta)
+	return matches
+}
+
+func sameClass(a, b rune) bool {
+	switch {
+	case unicode.IsLower(a):
+		return unicode.IsLower(b)
+	case unicode.IsUpper(a):
+		return unicode.IsUpper(b)
+	case unicode.IsDigit(a):
+		return unicode.IsDigit(b)
+	}
+	return false
+}
+
+var (
+	dateWithSeparator = regexp.MustCompile(`^(\d{1,4})([\s/\\_.-])(\d{1,2})([\s/\\_.-])(\d{1,4})$`)
+	yearPattern       = regexp.MustCompile(`19\d\d|20\d\d`)
+)
+
+// dateMatches finds dates such as "13/05/1987", "130587" or "1987-05-13".
+func dateMatches(password []rune) []Match {
+	var matches []Match
+	for i := range password {
+		for j := i + 3; j < len(password) && j-i < 10; j++ {
+			token := string(password[i : j+1])
+
+			if m := dateWithSeparator.FindStringSubmatch(token); m != nil {
+				if m[2] != m[4] {
+					continue
+				}
+				if year, ok := readDate(m[1], m[3], m[5]); ok {
+					matches = append(matches, Match{Pattern: PatternDate, Token: token, i: i, j: j, year: year, separator: m[2]})
+				}
+				continue
+			}
+
+			if len(token) <= 8 && allDigits(token) {
+				if year, ok := splitDate(token); ok {
+					matches = append(matches, Match{Pattern: PatternDate, Token: token, i: i, j: j, year: year})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// splitDate tries every way of reading digits as a day, a month and a two
+// or four digit year at either end.
+func splitDate(digits string) (int, bool) {
+	for _, yearLen := range []int{4, 2} {
+		rest := len(digits) - yearLen
+		if rest < 2 || rest > 4 {
+			continue
+		}
+		for split := 1; split < rest; split++ {
+			first, second := digits[:split], digits[split:rest]
+			if year, ok := readDate(first, second, digits[rest:]); ok {
+				return year, true
+			}
+			first, second = digits[yearLen:yearLen+split], digits[yearLen+split:]
+			if year, ok := readDate(digits[:yearLen], first, second); ok {
+				return year, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// readDate checks that a, b and c are a day, month and year in some order
+// with the year first or last, and returns the year.
+func readDate(a, b, c string) (int, bool) {
+	for _, parts := range [][3]string{{c, a, b}, {a, b, c}} {
+		yearStr, x, y := parts[0], parts[1], parts[2]
+		if len(x) > 2 || len(y) > 2 {
+			continue
+		}
+		year, ok := normalizeYear(atoi(yearStr), len(yearStr))
+		if !ok {
+			continue
+		}
+		if validDayMonth(atoi(x), atoi(y)) || validDayMonth(atoi(y), atoi(x)) {
+			return year, true
+		}
+	}
+	return 0, false
+}
+
+func normalizeYear(year, digits int) (int, bool) {
+	switch digits {
+	case 2:
+		if year < 50 {
+			return 2000 + year, true
+		}
+		return 1900 + year, true
+	case 4:
+		return year, year >= 1000 && year <= 2050
+	}
+	return 0, false
+}
+
+func validDayMonth(day, month int) bool {
+	return day >= 1 && day <= 31 && month >= 1 && month <= 12
+}
+
+func yearMatches(password []rune) []Match {
+	s := string(password)
+
+	var matches []Match
+	for _, loc := range yearPattern.FindAllStringIndex(s, -1) {
+		i := len([]rune(s[:loc[0]]))
+		token := s[loc[0]:loc[1]]
+		matches = append(matches, Match{
+			Pattern: PatternYear,
+			Token:   token,
+			i:       i,
+			j:       i + len(token) - 1,
+			year:    atoi(token),
+		})
+	}
+	return matches
+}
+
+func reverseRunes(s []rune) []rune {
+	r := make([]rune, len(s))
+	for i, c := range s {
+		r[len(s)-1-i] = c
+	}
+	return r
+}
+
+func reverseString(s string) string {
+	return string(reverseRunes([]rune(s)))
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
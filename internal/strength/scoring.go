@@ -0,0 +1,284 @@
+// internal/strength/scoring.go
+package strength
+
+import (
+	"math"
+	"time"
+	"unicode"
+)
+
+// Constants from zxcvbn's scoring model.
+const (
+	bruteforceCardinality    = 10
+	minGuessesBeforeGrowing  = 10000
+	minSubmatchGuessesSingle = 10
+	minSubmatchGuessesMulti  = 50
+	minYearSpace             = 20
+	daysPerYear              = 365
+	dateSeparatorMultiplier  = 4
+)
+
+type guessSequence struct {
+	guesses  float64
+	sequence []Match
+}
+
+// mostGuessableSequence picks the non-overlapping matches, with brute force
+// filling the gaps, that an attacker would need the fewest guesses for.
+func mostGuessableSequence(password []rune, matches []Match) guessSequence {
+	n := len(password)
+	if n == 0 {
+		return guessSequence{guesses: 1}
+	}
+
+	byEnd := make([][]Match, n)
+	for _, m := range matches {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	// For each end position k and sequence length l: the best last match,
+	// the product of guesses so far, and the total guesses
+	best := make([]map[int]Match, n)
+	pi := make([]map[int]float64, n)
+	g := make([]map[int]float64, n)
+	for k := range best {
+		best[k], pi[k], g[k] = map[int]Match{}, map[int]float64{}, map[int]float64{}
+	}
+
+	update := func(m Match, l int) {
+		k := m.j
+		product := estimateGuesses(&m, n)
+		if l > 1 {
+			product *= pi[m.i-1][l-1]
+		}
+		total := factorial(l)*product + math.Pow(minGuessesBeforeGrowing, float64(l-1))
+
+		for otherL, otherG := range g[k] {
+			if otherL <= l && otherG <= total {
+				return
+			}
+		}
+		best[k][l], pi[k][l], g[k][l] = m, product, total
+	}
+
+	bruteforce := func(i, j int) Match {
+		return Match{Pattern: PatternBruteforce, Token: string(password[i : j+1]), i: i, j: j}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			if m.i > 0 {
+				for l := range best[m.i-1] {
+					update(m, l+1)
+				}
+			} else {
+				update(m, 1)
+			}
+		}
+
+		update(bruteforce(0, k), 1)
+		for i := 1; i <= k; i++ {
+			for l, last := range best[i-1] {
+				// Two brute force runs in a row are really one
+				if last.Pattern == PatternBruteforce {
+					continue
+				}
+				update(bruteforce(i, k), l+1)
+			}
+		}
+	}
+
+	// Walk back from the cheapest full sequence
+	bestL, bestG := 0, math.Inf(1)
+	for l, total := range g[n-1] {
+		if total < bestG || (total == bestG && l < bestL) {
+			bestL, bestG = l, total
+		}
+	}
+
+	var sequence []Match
+	for k, l := n-1, bestL; k >= 0 && l > 0; l-- {
+		m := best[k][l]
+		sequence = append([]Match{m}, sequence...)
+		k = m.i - 1
+	}
+
+	return guessSequence{guesses: bestG, sequence: sequence}
+}
+
+// estimateGuesses returns how many guesses finding m's token takes, and
+// records it on m. passwordLen is needed because parts of a longer password
+// are never counted as trivially guessable.
+func estimateGuesses(m *Match, passwordLen int) float64 {
+	if m.guesses > 0 {
+		return m.guesses
+	}
+
+	minGuesses := 1.0
+	if m.length() < passwordLen {
+		minGuesses = minSubmatchGuessesMulti
+		if m.length() == 1 {
+			minGuesses = minSubmatchGuessesSingle
+		}
+	}
+
+	var guesses float64
+	switch m.Pattern {
+	case PatternBruteforce:
+		guesses = math.Pow(bruteforceCardinality, float64(m.length()))
+		if m.length() == 1 {
+			guesses = max(guesses, minSubmatchGuessesSingle+1)
+		} else {
+			guesses = max(guesses, minSubmatchGuessesMulti+1)
+		}
+	case PatternDictionary:
+		guesses = float64(m.rank) * uppercaseVariations(m.Token) * l33tVariations(m)
+		if m.reversed {
+			guesses *= 2
+		}
+	case PatternSpatial:
+		guesses = spatialGuesses(m)
+	case PatternRepeat:
+		guesses = m.baseGuesses * float64(m.repeats)
+	case PatternSequence:
+		guesses = sequenceGuesses(m)
+	case PatternYear:
+		guesses = yearSpace(m.year)
+	case PatternDate:
+		guesses = yearSpace(m.year) * daysPerYear
+		if m.separator != "" {
+			guesses *= dateSeparatorMultiplier
+		}
+	}
+
+	m.guesses = max(guesses, minGuesses)
+	return m.guesses
+}
+
+func yearSpace(year int) float64 {
+	return float64(max(abs(year-time.Now().Year()), minYearSpace))
+}
+
+// uppercaseVariations counts the capitalisations an attacker would try
+// before this one; a capital first or last letter is barely any harder.
+func uppercaseVariations(token string) float64 {
+	upper, lower := 0, 0
+	runes := []rune(token)
+	for _, r := range runes {
+		switch {
+		case unicode.IsUpper(r):
+			upper++
+		case unicode.IsLower(r):
+			lower++
+		}
+	}
+
+	if upper == 0 {
+		return 1
+	}
+	first, last := unicode.IsUpper(runes[0]), unicode.IsUpper(runes[len(runes)-1])
+	if lower == 0 || (upper == 1 && (first || last)) {
+		return 2
+	}
+
+	variations := 0.0
+	for i := 1; i <= min(upper, lower); i++ {
+		variations += binomial(upper+lower, i)
+	}
+	return variations
+}
+
+func l33tVariations(m *Match) float64 {
+	if len(m.l33tSubs) == 0 {
+		return 1
+	}
+
+	variations := 1.0
+	for sub, letter := range m.l33tSubs {
+		subbed, unsubbed := 0, 0
+		for _, r := range m.Token {
+			switch unicode.ToLower(r) {
+			case sub:
+				subbed++
+			case letter:
+				unsubbed++
+			}
+		}
+
+		if subbed == 0 || unsubbed == 0 {
+			// Either all or none of them substituted
+			variations *= 2
+			continue
+		}
+		possible := 0.0
+		for i := 1; i <= min(subbed, unsubbed); i++ {
+			possible += binomial(subbed+unsubbed, i)
+		}
+		variations *= possible
+	}
+	return variations
+}
+
+func spatialGuesses(m *Match) float64 {
+	length := m.length()
+
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= min(m.turns, i-1); j++ {
+			guesses += binomial(i-1, j-1) * m.graph.starts * math.Pow(m.graph.degree, float64(j))
+		}
+	}
+
+	if m.shifted > 0 {
+		unshifted := length - m.shifted
+		if unshifted == 0 {
+			guesses *= 2
+		} else {
+			variations := 0.0
+			for i := 1; i <= min(m.shifted, unshifted); i++ {
+				variations += binomial(m.shifted+unshifted, i)
+			}
+			guesses *= variations
+		}
+	}
+	return guesses
+}
+
+func sequenceGuesses(m *Match) float64 {
+	first := []rune(m.Token)[0]
+
+	var base float64
+	switch {
+	case first == 'a' || first == 'A' || first == 'z' || first == 'Z' || first == '0' || first == '1' || first == '9':
+		// Obvious starting points
+		base = 4
+	case unicode.IsDigit(first):
+		base = 10
+	default:
+		base = 26
+	}
+
+	if !m.ascending {
+		base *= 2
+	}
+	return base * float64(m.length())
+}
+
+func binomial(n, k int) float64 {
+	if k < 0 || k > n {
+		return 0
+	}
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result *= float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+func factorial(n int) float64 {
+	result := 1.0
+	for i := 2; i <= n; i++ {
+		result *= float64(i)
+	}
+	return result
+}
@@ -0,0 +1,263 @@
+// internal/strength/strength.go
+//
+// Package strength estimates how hard a password is to guess, following
+// the approach of zxcvbn: it finds dictionary words, keyboard patterns,
+// repeats, sequences and dates, and adds up what guessing each part takes.
+package strength
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Scores from 0 to 4, as in zxcvbn.
+const (
+	ScoreVeryWeak = iota
+	ScoreWeak
+	ScoreFair
+	ScoreStrong
+	ScoreVeryStrong
+)
+
+// Only this much of a password is analysed; anything longer is at least as
+// strong as its first part.
+const maxAnalysedLength = 100
+
+// Guesses per second assumed for CrackTime: an offline attack on a slow
+// password hash.
+const offlineGuessesPerSecond = 1e4
+
+// Result is the estimate for one password.
+type Result struct {
+	Score       int
+	Guesses     float64
+	Warning     string
+	Suggestions []string
+
+	// The parts the estimate is based on, in order
+	Sequence []Match
+}
+
+// Estimate rates password. userInputs are words an attacker would try
+// first, such as the user's email or the site the password is for.
+func Estimate(password string, userInputs ...string) Result {
+	runes := []rune(password)
+	if len(runes) > maxAnalysedLength {
+		runes = runes[:maxAnalysedLength]
+	}
+
+	best := mostGuessableSequence(runes, omnimatch(runes, userInputDict(userInputs)))
+
+	result := Result{
+		Score:    score(best.guesses),
+		Guesses:  best.guesses,
+		Sequence: best.sequence,
+	}
+	result.Warning, result.Suggestions = feedback(result.Score, best.sequence)
+	return result
+}
+
+// EstimateSecret is Estimate for a password held as bytes, such as the
+// contents of a SecureBuffer. The matchers only work on strings, so the
+// password is copied for the length of the call; none of it is kept in the
+// result, which comes without a Sequence.
+func EstimateSecret(password []byte, userInputs ...string) Result {
+	result := Estimate(string(password), userInputs...)
+	result.Sequence = nil
+	return result
+}
+
+// userInputDict splits inputs into words, so an email address also
+// contributes its local part and domain.
+func userInputDict(inputs []string) *rankedDict {
+	var words []string
+	for _, input := range inputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			continue
+		}
+		words = append(words, input)
+		words = append(words, strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})...)
+	}
+	if len(words) == 0 {
+		return nil
+	}
+
+	d := newRankedDict(DictUserInputs, words)
+	return &d
+}
+
+func score(guesses float64) int {
+	// A small margin so borderline guesses land in the lower bucket
+	const delta = 5
+	switch {
+	case guesses < 1e3+delta:
+		return ScoreVeryWeak
+	case guesses < 1e6+delta:
+		return ScoreWeak
+	case guesses < 1e8+delta:
+		return ScoreFair
+	case guesses < 1e10+delta:
+		return ScoreStrong
+	}
+	return ScoreVeryStrong
+}
+
+// Label names the score.
+func (r Result) Label() string {
+	return [...]string{"Very weak", "Weak", "Fair", "Strong", "Very strong"}[r.Score]
+}
+
+// GuessesLog10 is the order of magnitude of Guesses.
+func (r Result) GuessesLog10() float64 {
+	return math.Log10(r.Guesses)
+}
+
+// CrackTime describes how long an offline attack against a slow hash would
+// take to find the password.
+func (r Result) CrackTime() string {
+	seconds := r.Guesses / offlineGuessesPerSecond
+
+	const (
+		minute  = 60
+		hour    = 60 * minute
+		day     = 24 * hour
+		month   = 31 * day
+		year    = 12 * month
+		century = 100 * year
+	)
+
+	plural := func(n float64, unit string) string {
+		rounded := math.Round(n)
+		if rounded == 1 {
+			return "1 " + unit
+		}
+		return fmt.Sprintf("%.0f %ss", rounded, unit)
+	}
+
+	switch {
+	case seconds < 1:
+		return "less than a second"
+	case seconds < minute:
+		return plural(seconds, "second")
+	case seconds < hour:
+		return plural(seconds/minute, "minute")
+	case seconds < day:
+		return plural(seconds/hour, "hour")
+	case seconds < month:
+		return plural(seconds/day, "day")
+	case seconds < year:
+		return plural(seconds/month, "month")
+	case seconds < century:
+		return plural(seconds/year, "year")
+	}
+	return "centuries"
+}
+
+var (
+	startUpper = regexp.MustCompile(`^\p{Lu}[^\p{Lu}]+$`)
+	allUpper   = regexp.MustCompile(`^[^\p{Ll}]+$`)
+)
+
+// feedback explains what makes a weak password easy to guess, based on its
+// longest recognised part.
+func feedback(score int, sequence []Match) (string, []string) {
+	if len(sequence) == 0 {
+		return "", []string{
+			"Use a few words, avoid common phrases",
+			"No need for symbols, digits, or uppercase letters",
+		}
+	}
+	if score > ScoreFair {
+		return "", nil
+	}
+
+	longest := sequence[0]
+	for _, m := range sequence[1:] {
+		if len([]rune(m.Token)) > len([]rune(longest.Token)) {
+			longest = m
+		}
+	}
+
+	warning, suggestions := matchFeedback(longest, len(sequence) == 1)
+	return warning, append([]string{"Add another word or two. Uncommon words are better."}, suggestions...)
+}
+
+func matchFeedback(m Match, isSole bool) (string, []string) {
+	switch m.Pattern {
+	case PatternDictionary:
+		return dictionaryFeedback(m, isSole)
+
+	case PatternSpatial:
+		warning := "Short keyboard patterns are easy to guess"
+		if m.turns == 1 {
+			warning = "Straight rows of keys are easy to guess"
+		}
+		return warning, []string{"Use a longer keyboard pattern with more turns"}
+
+	case PatternRepeat:
+		warning := `Repeats like "abcabcabc" are only slightly harder to guess than "abc"`
+		if len([]rune(m.baseToken)) == 1 {
+			warning = `Repeats like "aaa" are easy to guess`
+		}
+		return warning, []string{"Avoid repeated words and characters"}
+
+	case PatternSequence:
+		return "Sequences like abc or 6543 are easy to guess", []string{"Avoid sequences"}
+
+	case PatternYear:
+		return "Recent years are easy to guess", []string{"Avoid recent years", "Avoid years that are associated with you"}
+
+	case PatternDate:
+		return "Dates are often easy to guess", []string{"Avoid dates and years that are associated with you"}
+	}
+	return "", nil
+}
+
+func dictionaryFeedback(m Match, isSole bool) (string, []string) {
+	var warning string
+	switch m.Dictionary {
+	case DictPasswords:
+		switch {
+		case isSole && len(m.l33tSubs) == 0 && !m.reversed && m.rank <= 10:
+			warning = "This is a top-10 common password"
+		case isSole && len(m.l33tSubs) == 0 && !m.reversed && m.rank <= 100:
+			warning = "This is a top-100 common password"
+		case isSole && len(m.l33tSubs) == 0 && !m.reversed:
+			warning = "This is a very common password"
+		case math.Log10(m.guesses) <= 4:
+			warning = "This is similar to a commonly used password"
+		}
+	case DictEnglish:
+		if isSole {
+			warning = "A word by itself is easy to guess"
+		}
+	case DictMaleNames, DictFemaleNames, DictSurnames:
+		warning = "Common names and surnames are easy to guess"
+		if isSole {
+			warning = "Names and surnames by themselves are easy to guess"
+		}
+	case DictUserInputs:
+		warning = "Details like your email or the site's name are easy to guess"
+	}
+
+	var suggestions []string
+	switch {
+	case startUpper.MatchString(m.Token):
+		suggestions = append(suggestions, "Capitalization doesn't help very much")
+	case allUpper.MatchString(m.Token) && strings.ToLower(m.Token) != m.Token:
+		suggestions = append(suggestions, "All-uppercase is almost as easy to guess as all-lowercase")
+	}
+	if m.reversed && len([]rune(m.Token)) >= 4 {
+		suggestions = append(suggestions, "Reversed words aren't much harder to guess")
+	}
+	if len(m.l33tSubs) > 0 {
+		suggestions = append(suggestions, "Predictable substitutions like '@' instead of 'a' don't help very much")
+	}
+	return warning, suggestions
+}
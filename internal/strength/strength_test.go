@@ -0,0 +1,197 @@
+// internal/strength/strength_test.go
+package strength
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		password string
+		minScore int
+		maxScore int
+		pattern  Pattern // a part the estimate must have found
+	}{
+		// Common passwords
+		{"password", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"123456", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"iloveyou", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"jessica", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"QWERTYUIOP", ScoreVeryWeak, ScoreWeak, PatternDictionary},
+
+		// Keyboard walks
+		{"asdfghjkl;", ScoreVeryWeak, ScoreWeak, PatternSpatial},
+		{"qazwsxedc", ScoreVeryWeak, ScoreWeak, ""},
+		{"1q2w3e4r5t", ScoreVeryWeak, ScoreWeak, ""},
+
+		// Dates
+		{"12/25/1990", ScoreVeryWeak, ScoreWeak, PatternDate},
+		{"1990-12-25", ScoreVeryWeak, ScoreWeak, PatternDate},
+		{"19901225", ScoreVeryWeak, ScoreWeak, PatternDate},
+		{"14/07/1789", ScoreVeryWeak, ScoreWeak, PatternDate},
+		{"2001", ScoreVeryWeak, ScoreVeryWeak, PatternYear},
+
+		// Repeats and sequences
+		{"aaaaaaaaaa", ScoreVeryWeak, ScoreVeryWeak, PatternRepeat},
+		{"ZZZZZZ", ScoreVeryWeak, ScoreVeryWeak, PatternRepeat},
+		{"abcabcabc", ScoreVeryWeak, ScoreVeryWeak, PatternRepeat},
+		{"abcdefgh", ScoreVeryWeak, ScoreVeryWeak, PatternSequence},
+		{"9876543210", ScoreVeryWeak, ScoreVeryWeak, PatternSequence},
+
+		// l33t substitutions and reversed words
+		{"p@ssw0rd", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"P4$$w0rd", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"dr4g0n", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+		{"n0t3b00k", ScoreVeryWeak, ScoreWeak, PatternDictionary},
+		{"drowssap", ScoreVeryWeak, ScoreVeryWeak, PatternDictionary},
+
+		// Strong passwords
+		{"hyperbole-wildcat-quintet-envoy-macaroni", ScoreVeryStrong, ScoreVeryStrong, PatternDictionary},
+		{"ramp-ruby-oval-mosaic-quiet-tundra", ScoreVeryStrong, ScoreVeryStrong, ""},
+		{"kX9#mQ2$vL7!pR4w", ScoreVeryStrong, ScoreVeryStrong, PatternBruteforce},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			result := Estimate(tt.password)
+			if result.Score < tt.minScore || result.Score > tt.maxScore {
+				t.Errorf("Score = %d (%.1f guesses log10), want %d to %d", result.Score, result.GuessesLog10(), tt.minScore, tt.maxScore)
+			}
+			if tt.pattern != "" && !hasPattern(result, tt.pattern) {
+				t.Errorf("Sequence %v has no %s match", patterns(result), tt.pattern)
+			}
+			if result.Score <= ScoreFair && result.Warning == "" && len(result.Suggestions) == 0 {
+				t.Error("a weak password came without feedback")
+			}
+		})
+	}
+}
+
+func hasPattern(result Result, pattern Pattern) bool {
+	for _, m := range result.Sequence {
+		if m.Pattern == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func patterns(result Result) []Pattern {
+	var found []Pattern
+	for _, m := range result.Sequence {
+		found = append(found, m.Pattern)
+	}
+	return found
+}
+
+func TestEstimateMatchDetails(t *testing.T) {
+	l33t := Estimate("p@ssw0rd").Sequence
+	if len(l33t) != 1 || l33t[0].word != "password" || len(l33t[0].l33tSubs) == 0 {
+		t.Errorf("p@ssw0rd: want password with l33t substitutions, got %+v", l33t)
+	}
+
+	reversed := Estimate("drowssap").Sequence
+	if len(reversed) != 1 || !reversed[0].reversed || reversed[0].word != "password" {
+		t.Errorf("drowssap: want password reversed, got %+v", reversed)
+	}
+
+	for _, password := range []string{"13/45/1990", "00000000"} {
+		if hasPattern(Estimate(password), PatternDate) {
+			t.Errorf("%s was read as a date", password)
+		}
+	}
+}
+
+func TestEstimateUserInputs(t *testing.T) {
+	inputs := []string{"alice@example.com", "GitHub"}
+
+	tests := []string{"alicegithub", "github2024", "4l1c3", "Example!"}
+	for _, password := range tests {
+		t.Run(password, func(t *testing.T) {
+			without := Estimate(password)
+			with := Estimate(password, inputs...)
+			if with.Guesses >= without.Guesses {
+				t.Errorf("guesses with user inputs %.0f, without %.0f; want fewer with", with.Guesses, without.Guesses)
+			}
+			if with.Score > ScoreWeak {
+				t.Errorf("Score = %d with user inputs, want at most %d", with.Score, ScoreWeak)
+			}
+
+			found := false
+			for _, m := range with.Sequence {
+				found = found || m.Dictionary == DictUserInputs
+			}
+			if !found {
+				t.Errorf("no user input match in %+v", with.Sequence)
+			}
+		})
+	}
+}
+
+func TestEstimateSecret(t *testing.T) {
+	for _, password := range []string{"password", "Correct-staple-orbit-1984x"} {
+		want := Estimate(password, "alice")
+		got := EstimateSecret([]byte(password), "alice")
+		if got.Score != want.Score || got.Guesses != want.Guesses || got.Warning != want.Warning {
+			t.Errorf("EstimateSecret(%q) = %d, %.0f, %q; Estimate gives %d, %.0f, %q",
+				password, got.Score, got.Guesses, got.Warning, want.Score, want.Guesses, want.Warning)
+		}
+		if got.Sequence != nil {
+			t.Errorf("EstimateSecret(%q) kept the matched parts", password)
+		}
+	}
+}
+
+func TestEstimateLongPassword(t *testing.T) {
+	long := strings.Repeat("kX9#mQ2$vL7!pR4w", 50)
+	result := Estimate(long)
+	if result.Score != ScoreVeryStrong {
+		t.Errorf("Score = %d, want %d", result.Score, ScoreVeryStrong)
+	}
+	for _, m := range result.Sequence {
+		if m.j >= maxAnalysedLength {
+			t.Fatalf("matched beyond the first %d characters: %+v", maxAnalysedLength, m)
+		}
+	}
+}
+
+func TestScoreBands(t *testing.T) {
+	tests := []struct {
+		guesses float64
+		want    int
+	}{
+		{1, ScoreVeryWeak},
+		{1e3, ScoreVeryWeak},
+		{1e3 + 10, ScoreWeak},
+		{1e6, ScoreWeak},
+		{1e6 + 10, ScoreFair},
+		{1e8 + 10, ScoreStrong},
+		{1e10, ScoreStrong},
+		{1e10 + 10, ScoreVeryStrong},
+		{1e20, ScoreVeryStrong},
+	}
+	for _, tt := range tests {
+		if got := score(tt.guesses); got != tt.want {
+			t.Errorf("score(%g) = %d, want %d", tt.guesses, got, tt.want)
+		}
+	}
+}
+
+func TestCrackTime(t *testing.T) {
+	tests := []struct {
+		guesses float64
+		want    string
+	}{
+		{1, "less than a second"},
+		{1e4, "1 second"},
+		{3e5, "30 seconds"},
+		{36e6, "1 hour"},
+		{1e20, "centuries"},
+	}
+	for _, tt := range tests {
+		if got := (Result{Guesses: tt.guesses}).CrackTime(); got != tt.want {
+			t.Errorf("CrackTime() for %g guesses = %q, want %q", tt.guesses, got, tt.want)
+		}
+	}
+}
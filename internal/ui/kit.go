@@ -0,0 +1,164 @@
+// internal/ui/kit.go
+package ui
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"passmanager/internal/config"
+	"passmanager/internal/models"
+	"passmanager/internal/vault"
+)
+
+// EmergencyKit is what someone needs to get into the vault without the
+// master password, meant to be printed and kept somewhere safe.
+type EmergencyKit struct {
+	Created       time.Time
+	Backend       string
+	PocketBaseURL string
+	AdminEmail    string
+	VaultPath     string // local and sqlite backends
+	VaultID       string
+	KeyFile       bool
+	RecoveryKey   string
+}
+
+// NewEmergencyKit describes the vault of cfg and vaultConfig with its
+// recovery key.
+func NewEmergencyKit(cfg *config.Config, vaultConfig models.VaultConfig, recoveryKey []byte) EmergencyKit {
+	kit := EmergencyKit{
+		Created:       time.Now(),
+		Backend:       cfg.Backend,
+		PocketBaseURL: cfg.PocketBaseURL,
+		AdminEmail:    cfg.AdminEmail,
+		VaultID:       vaultConfig.ID,
+		KeyFile:       vaultConfig.KeyFile,
+		RecoveryKey:   vault.FormatRecoveryKey(recoveryKey),
+	}
+	switch cfg.Backend {
+	case config.BackendLocal:
+		kit.VaultPath = config.GetVaultPath()
+	case config.BackendSQLite:
+		kit.VaultPath = config.GetSQLitePath()
+	}
+	return kit
+}
+
+func (k EmergencyKit) details() [][2]string {
+	rows := [][2]string{{"Storage", k.Backend}}
+	switch k.Backend {
+	case config.BackendPocketBase:
+		rows = append(rows, [2]string{"PocketBase URL", k.PocketBaseURL}, [2]string{"Admin email", k.AdminEmail})
+	default:
+		rows = append(rows, [2]string{"Vault file", k.VaultPath})
+	}
+	rows = append(rows, [2]string{"Vault ID", k.VaultID})
+	if k.KeyFile {
+		rows = append(rows, [2]string{"Key file", "required to unlock with the password, not with the recovery key"})
+	}
+	return rows
+}
+
+var kitSteps = []string{
+	"Run `passmanager recover`, or choose to recover when unlocking fails.",
+	"Enter the recovery key above when asked for it.",
+	"Choose a new master password. The recovery key keeps working afterwards.",
+}
+
+// Text is the kit as plain text.
+func (k EmergencyKit) Text() string {
+	var b strings.Builder
+	b.WriteString("PASSMANAGER EMERGENCY KIT\n")
+	fmt.Fprintf(&b, "Created %s\n\n", k.Created.Format("2006-01-02"))
+	b.WriteString("Anyone with this document can open your vault. Print it and keep it\n")
+	b.WriteString("somewhere safe, then delete the file.\n\n")
+
+	for _, row := range k.details() {
+		fmt.Fprintf(&b, "%-16s%s\n", row[0]+":", row[1])
+	}
+
+	b.WriteString("\nRECOVERY KEY\n")
+	fmt.Fprintf(&b, "%s\n\n", k.RecoveryKey)
+	b.WriteString("Master password (optional): ______________________________\n\n")
+
+	b.WriteString("TO RECOVER THE VAULT\n")
+	for i, step := range kitSteps {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, step)
+	}
+	return b.String()
+}
+
+var kitTemplate = template.Must(template.New("kit").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PassManager Emergency Kit</title>
+<style>
+body { font-family: sans-serif; max-width: 42em; margin: 2em auto; color: #111; }
+h1 { font-size: 1.6em; margin-bottom: 0; }
+.warning { border: 2px solid #b00; padding: .6em 1em; }
+th { text-align: left; padding-right: 1.5em; }
+.key { font-family: monospace; font-size: 1.2em; word-spacing: .3em; border: 1px solid #999; padding: .8em; }
+.write-in { border-bottom: 1px solid #111; display: inline-block; width: 20em; }
+@media print { .warning { border-color: #000; } }
+</style>
+</head>
+<body>
+<h1>PassManager Emergency Kit</h1>
+<p>Created {{.Created}}</p>
+<p class="warning">Anyone with this document can open your vault. Print it and keep it somewhere safe, then delete the file.</p>
+<table>
+{{range .Details}}<tr><th>{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+<h2>Recovery key</h2>
+<p class="key">{{.RecoveryKey}}</p>
+<img src="{{.QRCode}}" alt="Recovery key QR code" width="200" height="200">
+<p>Master password (optional): <span class="write-in">&nbsp;</span></p>
+<h2>To recover the vault</h2>
+<ol>
+{{range .Steps}}<li>{{.}}</li>
+{{end}}</ol>
+</body>
+</html>
+`))
+
+// HTML is the kit as a printable web page with the recovery key as a QR
+// code.
+func (k EmergencyKit) HTML() (string, error) {
+	png, err := QRCodePNG(k.RecoveryKey)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	err = kitTemplate.Execute(&b, map[string]any{
+		"Created":     k.Created.Format("2006-01-02"),
+		"Details":     k.details(),
+		"RecoveryKey": k.RecoveryKey,
+		"QRCode":      template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+		"Steps":       kitSteps,
+	})
+	return b.String(), err
+}
+
+// SaveEmergencyKit writes the kit to path. A .html path gets the HTML
+// version, anything else plain text.
+func SaveEmergencyKit(path string, kit EmergencyKit) error {
+	content := kit.Text()
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".html" || ext == ".htm" {
+		var err error
+		if content, err = kit.HTML(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(path, []byte(content), 0600)
+}
@@ -3,8 +3,12 @@ package ui
 
 import (
 	"fmt"
+	"os"
+
+	"passmanager/internal/crypto"
 
 	"github.com/manifoldco/promptui"
+	"golang.org/x/term"
 )
 
 type MenuItem struct {
@@ -24,8 +28,10 @@ func MainMenu() (string, error) {
 		{Name: "Search Credentials", Description: "Find a specific password", Icon: "🔍"},
 		{Name: "Get Credential", Description: "Retrieve a password by ID", Icon: "🔑"},
 		{Name: "Generate Password", Description: "Create a secure password", Icon: "🎲"},
+		{Name: "Password Health", Description: "Find weak passwords", Icon: "🩺"},
 		{Name: "Delete Credential", Description: "Remove a stored password", Icon: "🗑️ "},
 		{Name: "Change Master Password", Description: "Update your master password", Icon: "🔐"},
+		{Name: "Rotate Vault Key", Description: "Re-encrypt everything with a new key", Icon: "♻️ "},
 		{Name: "Lock Vault", Description: "Lock and require re-authentication", Icon: "🔒"},
 		{Name: "Settings", Description: "Configure application settings", Icon: "⚙️ "},
 		{Name: "Help", Description: "Show help information", Icon: "❓"},
@@ -43,7 +49,7 @@ func MainMenu() (string, error) {
 		Label:     fmt.Sprintf("\n%s%s Main Menu %s", Bold+Cyan, "🔐", Reset),
 		Items:     items,
 		Templates: templates,
-		Size:      11,
+		Size:      13,
 		HideHelp:  true,
 	}
 
@@ -96,4 +102,17 @@ func PasswordPrompt(label string) (string, error) {
 	}
 
 	return prompt.Run()
-}
\ No newline at end of file
+}
+
+// SecretPrompt reads a secret without echoing it and keeps it in locked
+// memory; unlike PasswordPrompt it never becomes a string. The caller must
+// Destroy the result.
+func SecretPrompt(label string) (*crypto.SecureBuffer, error) {
+	fmt.Printf("%s?%s %s%s:%s ", Cyan, Reset, Bold, label, Reset)
+	input, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.SecureBytes(input)
+}
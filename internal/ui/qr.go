@@ -0,0 +1,50 @@
+// internal/ui/qr.go
+package ui
+
+import (
+	"strings"
+
+	"rsc.io/qr"
+)
+
+// qrQuietZone is the light border scanners need around a code, in modules.
+const qrQuietZone = 2
+
+// QRCode draws text as a QR code for the terminal, two modules per line
+// using half blocks. Light modules are drawn as blocks, which suits the
+// usual light-on-dark terminal; on a light background it comes out
+// inverted, which not every scanner accepts.
+func QRCode(text string) (string, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for y := -qrQuietZone; y < code.Size+qrQuietZone; y += 2 {
+		for x := -qrQuietZone; x < code.Size+qrQuietZone; x++ {
+			top, bottom := !code.Black(x, y), !code.Black(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// QRCodePNG renders text as a QR code image, for printing.
+func QRCodePNG(text string) ([]byte, error) {
+	code, err := qr.Encode(text, qr.M)
+	if err != nil {
+		return nil, err
+	}
+	return code.PNG(), nil
+}
@@ -0,0 +1,58 @@
+// internal/ui/shares.go
+package ui
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"passmanager/internal/config"
+	"passmanager/internal/vault"
+)
+
+// ShareCard is a recovery share as shown on screen: a heading, its QR code
+// and its text.
+func ShareCard(share vault.Share, total int) (string, error) {
+	code, err := QRCode(share.String())
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sRecovery share %d of %d%s\n", Bold, share.Index(), total, Reset)
+	fmt.Fprintf(&b, "%s\n", Subtle(fmt.Sprintf("%d shares are needed to recover the vault.", share.Threshold)))
+	b.WriteString(code)
+	fmt.Fprintf(&b, "%s\n", share)
+	return b.String(), nil
+}
+
+// SaveShares writes each share to dir as share-N.txt and share-N.png, and
+// returns the paths written.
+func SaveShares(dir string, shares []vault.Share) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, share := range shares {
+		base := filepath.Join(dir, fmt.Sprintf("share-%d", share.Index()))
+
+		text := fmt.Sprintf("PassManager recovery share %d of %d\n%d shares are needed to recover the vault.\n\n%s\n",
+			share.Index(), len(shares), share.Threshold, share)
+		if err := config.WriteFileAtomic(base+".txt", []byte(text), 0600); err != nil {
+			return paths, err
+		}
+		paths = append(paths, base+".txt")
+
+		png, err := QRCodePNG(share.String())
+		if err != nil {
+			return paths, err
+		}
+		if err := config.WriteFileAtomic(base+".png", png, 0600); err != nil {
+			return paths, err
+		}
+		paths = append(paths, base+".png")
+	}
+	return paths, nil
+}
@@ -0,0 +1,126 @@
+// internal/vault/fields.go
+package vault
+
+import (
+	"errors"
+	"fmt"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/models"
+)
+
+// Names of the encrypted credential fields. They are part of what each
+// ciphertext is bound to, so they must never change.
+const (
+	FieldPassword = "password"
+	FieldNotes    = "notes"
+	FieldTOTP     = "totp"
+)
+
+var ErrUnboundCiphertext = errors.New("ciphertext is not bound to its record")
+
+type secretField struct {
+	name  string
+	value *string
+}
+
+// secretFields lists the encrypted fields of cred.
+func secretFields(cred *models.Credential) []secretField {
+	fields := []secretField{
+		{FieldPassword, &cred.EncryptedPassword},
+		{FieldNotes, &cred.Notes},
+		{FieldTOTP, &cred.TOTP},
+	}
+	if cred.MetadataEncrypted {
+		fields = append(fields, metadataFields(cred)...)
+	}
+	return fields
+}
+
+// Seal encrypts a credential field so that it only decrypts as that field
+// of record id. Someone with write access to the backend cannot move it to
+// another record or field.
+func Seal(key *crypto.CryptoService, id, field, plaintext string) (string, error) {
+	if id == "" {
+		return "", errors.New("record ID must be set before encrypting its fields")
+	}
+	return key.EncryptBound(plaintext, fieldAAD(id, field))
+}
+
+// SealSecret is Seal for a plaintext held in a SecureBuffer.
+func SealSecret(key *crypto.CryptoService, id, field string, plaintext *crypto.SecureBuffer) (string, error) {
+	if id == "" {
+		return "", errors.New("record ID must be set before encrypting its fields")
+	}
+	var encrypted string
+	err := plaintext.Use(func(secret []byte) error {
+		var err error
+		encrypted, err = key.EncryptBoundBytes(secret, fieldAAD(id, field))
+		return err
+	})
+	return encrypted, err
+}
+
+// Open decrypts a field written by Seal. Ciphertexts in a format older than
+// minVersion are refused, so once a vault is fully migrated an unbound one
+// cannot be swapped back in. Pass the vault config's CipherVersion.
+//
+// The result is an ordinary string, so Open is for fields that are shown
+// as text anyway, such as the title. Secrets go through OpenSecret.
+func Open(key *crypto.CryptoService, id, field, encrypted string, minVersion int) (string, error) {
+	plaintext, err := OpenSecret(key, id, field, encrypted, minVersion)
+	if err != nil {
+		return "", err
+	}
+	defer plaintext.Destroy()
+	return plaintext.UnsafeString(), nil
+}
+
+// OpenSecret is Open with the plaintext left in a SecureBuffer, for secrets
+// that should not linger in memory. The caller must Destroy it.
+func OpenSecret(key *crypto.CryptoService, id, field, encrypted string, minVersion int) (*crypto.SecureBuffer, error) {
+	if err := checkVersion(field, encrypted, minVersion); err != nil {
+		return nil, err
+	}
+	return key.DecryptBound(encrypted, fieldAAD(id, field))
+}
+
+// opens reports whether a field decrypts, without keeping the plaintext.
+func opens(key *crypto.CryptoService, id, field, encrypted string, minVersion int) error {
+	plaintext, err := OpenSecret(key, id, field, encrypted, minVersion)
+	plaintext.Destroy()
+	return err
+}
+
+func checkVersion(field, encrypted string, minVersion int) error {
+	version, err := crypto.CipherVersion(encrypted)
+	if err != nil {
+		return err
+	}
+	if version < minVersion {
+		return fmt.Errorf("%s: %w", field, ErrUnboundCiphertext)
+	}
+	return nil
+}
+
+// sealChecked seals plaintext and makes sure the result opens before it
+// replaces anything.
+func sealChecked(key *crypto.CryptoService, id, field string, plaintext *crypto.SecureBuffer) (string, error) {
+	encrypted, err := SealSecret(key, id, field, plaintext)
+	if err != nil {
+		return "", err
+	}
+	check, err := OpenSecret(key, id, field, encrypted, crypto.CipherCurrent)
+	if err != nil {
+		return "", errors.New("verification failed")
+	}
+	defer check.Destroy()
+	if !check.Equal(plaintext) {
+		return "", errors.New("verification failed")
+	}
+	return encrypted, nil
+}
+
+func fieldAAD(id, field string) []byte {
+	return []byte("passmanager/credential/" + id + "/" + field)
+}
@@ -0,0 +1,100 @@
+// internal/vault/journal.go
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"passmanager/internal/config"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// Journal records a data key rotation in progress. It is written before any
+// record is touched, so an interrupted rotation can be resumed or rolled
+// back the next time the vault is unlocked.
+type Journal struct {
+	VaultID string `json:"vault_id"`
+	Started string `json:"started"`
+
+	// Config is the vault config to commit once every record is migrated.
+	Config models.VaultConfig `json:"config"`
+
+	// NewKey is the new key wrapped with the old one, so finishing or
+	// undoing the rotation only needs the vault unlocked.
+	NewKey string `json:"new_key"`
+
+	// Migrated counts the records the last run left under the new key, as
+	// of the last time the journal was written.
+	Migrated int               `json:"migrated_count"`
+	Failed   map[string]string `json:"failed,omitempty"`
+}
+
+func JournalPath() string {
+	return filepath.Join(config.GetConfigDir(), "rekey.journal")
+}
+
+// LoadJournal returns nil without an error when no change is in progress.
+func LoadJournal() (*Journal, error) {
+	data, err := os.ReadFile(JournalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var journal Journal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("corrupt rekey journal: %w", err)
+	}
+	return &journal, nil
+}
+
+// CheckRotation returns ErrRekeyInProgress while a data key rotation of the
+// vault in store is under way, and ErrRekeyElsewhere if another device
+// sharing the vault started it. Changing the vault's keys meanwhile would be
+// undone when the rotation commits, so every such change checks this first.
+// A journal left by a different vault does not count.
+func CheckRotation(ctx context.Context, store database.VaultStore) error {
+	cfg, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	if journal, _ := LoadJournal(); journal != nil && journal.VaultID == cfg.ID {
+		return ErrRekeyInProgress
+	}
+	if cfg.Rotation != "" {
+		return ErrRekeyElsewhere
+	}
+	return nil
+}
+
+// Committed reports whether the new config already reached the store, which
+// means only the journal itself was left behind.
+func (j *Journal) Committed(current models.VaultConfig) bool {
+	return current.Salt == j.Config.Salt &&
+		current.PasswordHash == j.Config.PasswordHash &&
+		current.WrappedKey == j.Config.WrappedKey
+}
+
+func (j *Journal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(JournalPath(), data, 0600)
+}
+
+func RemoveJournal() error {
+	err := os.Remove(JournalPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,313 @@
+// internal/vault/keys.go
+package vault
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// Credentials are encrypted with a random data key. The vault config keeps
+// that key wrapped by a key derived from the master password, so changing
+// the password only re-wraps it. Vaults from before this used the derived
+// key for the data directly; they have no WrappedKey.
+//
+// The password-derived key itself is only split into subkeys: one wraps the
+// data key and one is stored as the verifier. Vaults that stored a hash of
+// the derived key and wrapped with it directly keep working and are moved
+// over by the upgrade at unlock.
+
+var (
+	ErrInvalidPassword = errors.New("invalid master password")
+	ErrKeyFileRequired = errors.New("this vault requires a key file")
+)
+
+// WithKeyFile returns what the KDF runs over: password followed by the hash
+// of the file at keyFile, or the password alone if keyFile is empty. The
+// caller must Destroy the result.
+func WithKeyFile(password []byte, keyFile string) (*crypto.SecureBuffer, error) {
+	var hash *crypto.SecureBuffer
+	if keyFile != "" {
+		var err error
+		if hash, err = crypto.KeyFileHash(keyFile); err != nil {
+			return nil, err
+		}
+		defer hash.Destroy()
+	}
+
+	secret, err := crypto.NewSecureBuffer(len(password) + hash.Len())
+	if err != nil {
+		return nil, err
+	}
+	n := copy(secret.Bytes(), password)
+	copy(secret.Bytes()[n:], hash.Bytes())
+	return secret, nil
+}
+
+// NewPasswordKey derives a key from password with a new salt and the given
+// KDF settings. The caller must Destroy it.
+func NewPasswordKey(password []byte, kdf crypto.KDFParams) (*crypto.DerivedKey, error) {
+	salt, err := crypto.GenerateSalt()
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewDerivedKey(password, salt, kdf.String())
+}
+
+// NewConfig returns the config for a new vault protected by key, from
+// NewPasswordKey, along with its freshly generated data key. A local vault
+// file is created with the same key.
+func NewConfig(key *crypto.DerivedKey) (models.VaultConfig, *crypto.CryptoService, error) {
+	dataKey, err := crypto.GenerateKey()
+	if err != nil {
+		return models.VaultConfig{}, nil, err
+	}
+
+	// Nothing has been written in an older format yet
+	cfg := models.VaultConfig{CipherVersion: crypto.CipherCurrent}
+	cfg, err = wrap(cfg, dataKey, key)
+	if err != nil {
+		dataKey.SecureClear()
+		return models.VaultConfig{}, nil, err
+	}
+
+	return cfg, dataKey, nil
+}
+
+// Unlock checks password against cfg and returns the vault's data key.
+func Unlock(password []byte, cfg models.VaultConfig) (*crypto.CryptoService, error) {
+	key, err := configKey(password, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+	return unlockKey(key, cfg)
+}
+
+// UnlockDerived is Unlock with the key OpenLocalFileStore already derived
+// from password, so the KDF only runs again if cfg has moved on since. key
+// may be nil for the other backends.
+func UnlockDerived(password []byte, key *crypto.DerivedKey, cfg models.VaultConfig) (*crypto.CryptoService, error) {
+	if key == nil || !key.Matches(cfg.Salt, cfg.KDF) {
+		return Unlock(password, cfg)
+	}
+	return unlockKey(key, cfg)
+}
+
+func unlockKey(key *crypto.DerivedKey, cfg models.VaultConfig) (*crypto.CryptoService, error) {
+	passKey, err := passwordKey(key, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.WrappedKey == "" {
+		return passKey, nil
+	}
+	defer passKey.SecureClear()
+
+	dataKey, err := passKey.UnwrapKey(cfg.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap vault key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// CheckPassword reports whether password unlocks cfg.
+func CheckPassword(password []byte, cfg models.VaultConfig) error {
+	key, err := configKey(password, cfg)
+	if err != nil {
+		return err
+	}
+	defer key.Destroy()
+
+	passKey, err := passwordKey(key, cfg)
+	if err != nil {
+		return err
+	}
+	passKey.SecureClear()
+	return nil
+}
+
+// NeedsUpgrade reports whether cfg predates the wrapped data key or separate
+// verifier, or uses KDF settings below the minimum.
+func NeedsUpgrade(cfg models.VaultConfig) bool {
+	if cfg.WrappedKey == "" || crypto.LegacyVerifier(cfg.PasswordHash) {
+		return true
+	}
+	kdf, err := crypto.ParseKDF(cfg.KDF)
+	return err == nil && (cfg.KDF == "" || kdf.Outdated())
+}
+
+// ChangePassword wraps dataKey under newPassword with a new salt and the
+// vault's KDF settings, and saves the config. Nothing else in the vault
+// changes. Calling it with the current password upgrades an old vault: the
+// key it already uses becomes its data key.
+func ChangePassword(ctx context.Context, store database.VaultStore, cfg models.VaultConfig, dataKey *crypto.CryptoService, newPassword []byte) (models.VaultConfig, error) {
+	// Committing a rotation would put the old password back
+	if err := CheckRotation(ctx, store); err != nil {
+		return cfg, err
+	}
+
+	key, err := NewPasswordKey(newPassword, vaultKDF(cfg))
+	if err != nil {
+		return cfg, err
+	}
+	defer key.Destroy()
+
+	next, err := wrap(cfg, dataKey, key)
+	if err != nil {
+		return cfg, err
+	}
+
+	if ps, ok := store.(database.PassphraseStore); ok {
+		// The local vault file is keyed by the master password as well
+		err = ps.ChangePassphrase(ctx, key, next)
+	} else {
+		err = store.UpdateVaultConfig(ctx, next.ID, next)
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	return next, nil
+}
+
+// SetKeyFile adds a key file to the vault, replaces it, or with an empty
+// keyFile removes it. The data key is re-wrapped under password and the new
+// key file; the current password must have been checked already.
+func SetKeyFile(ctx context.Context, store database.VaultStore, cfg models.VaultConfig, dataKey *crypto.CryptoService, password []byte, keyFile string) (models.VaultConfig, error) {
+	secret, err := WithKeyFile(password, keyFile)
+	if err != nil {
+		return cfg, err
+	}
+	defer secret.Destroy()
+
+	next := cfg
+	next.KeyFile = keyFile != ""
+	updated, err := ChangePassword(ctx, store, next, dataKey, secret.Bytes())
+	if err != nil {
+		return cfg, err
+	}
+	return updated, nil
+}
+
+// SetKDF re-wraps dataKey under secret with new KDF settings, such as
+// ones from crypto.Calibrate. secret must have been checked already.
+func SetKDF(ctx context.Context, store database.VaultStore, cfg models.VaultConfig, dataKey *crypto.CryptoService, secret []byte, kdf crypto.KDFParams) (models.VaultConfig, error) {
+	if kdf.Outdated() {
+		return cfg, fmt.Errorf("key derivation settings %s are below the minimum", kdf)
+	}
+	next := cfg
+	next.KDF = kdf.String()
+	return ChangePassword(ctx, store, next, dataKey, secret)
+}
+
+// RotateKey starts moving the vault to a new random data key. password is
+// needed to wrap the new key; the salt and password hash stay the same.
+func RotateKey(ctx context.Context, store database.VaultStore, dataKey *crypto.CryptoService, cfg models.VaultConfig, password []byte) (*Rekey, error) {
+	key, err := configKey(password, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Destroy()
+
+	passKey, err := passwordKey(key, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer passKey.SecureClear()
+
+	newKey, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	next := cfg
+	if next.WrappedKey, err = passKey.WrapKey(newKey); err != nil {
+		newKey.SecureClear()
+		return nil, err
+	}
+	if next.RecoveryPublicKey != "" {
+		if next.RecoveryWrappedKey, err = crypto.RecoveryWrap(next.RecoveryPublicKey, newKey); err != nil {
+			newKey.SecureClear()
+			return nil, err
+		}
+	}
+
+	return Begin(ctx, store, dataKey, newKey, cfg, next)
+}
+
+// configKey derives the key password gives with cfg's salt and KDF.
+func configKey(password []byte, cfg models.VaultConfig) (*crypto.DerivedKey, error) {
+	salt, err := base64.StdEncoding.DecodeString(cfg.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt vault config: %w", err)
+	}
+	return crypto.NewDerivedKey(password, salt, cfg.KDF)
+}
+
+// passwordKey checks key against the stored verifier and returns the key
+// that wraps the data key.
+func passwordKey(key *crypto.DerivedKey, cfg models.VaultConfig) (*crypto.CryptoService, error) {
+	var passKey *crypto.CryptoService
+	err := key.Use(func(key []byte) error {
+		if !crypto.VerifyKey(key, cfg.PasswordHash) {
+			return ErrInvalidPassword
+		}
+
+		var err error
+		if crypto.LegacyVerifier(cfg.PasswordHash) {
+			passKey, err = crypto.NewCryptoServiceFromKey(key)
+		} else {
+			passKey, err = crypto.WrappingKey(key)
+		}
+		return err
+	})
+	return passKey, err
+}
+
+// wrap protects dataKey with key, from NewPasswordKey, and stores its salt,
+// KDF settings and verifier in cfg.
+func wrap(cfg models.VaultConfig, dataKey *crypto.CryptoService, key *crypto.DerivedKey) (models.VaultConfig, error) {
+	var verifier, wrapped string
+	err := key.Use(func(key []byte) error {
+		var err error
+		if verifier, err = crypto.KeyVerifier(key); err != nil {
+			return err
+		}
+
+		wrapKey, err := crypto.WrappingKey(key)
+		if err != nil {
+			return err
+		}
+		defer wrapKey.SecureClear()
+
+		wrapped, err = wrapKey.WrapKey(dataKey)
+		return err
+	})
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.Salt = base64.StdEncoding.EncodeToString(key.Salt)
+	cfg.PasswordHash = verifier
+	cfg.WrappedKey = wrapped
+	cfg.KDF = key.KDF
+	return cfg, nil
+}
+
+// vaultKDF returns the KDF settings cfg keeps across password changes: its
+// own, or the defaults if it has none yet or they are outdated.
+func vaultKDF(cfg models.VaultConfig) crypto.KDFParams {
+	if cfg.KDF != "" {
+		if kdf, err := crypto.ParseKDF(cfg.KDF); err == nil && !kdf.Outdated() {
+			return kdf
+		}
+	}
+	return crypto.DefaultKDF()
+}
@@ -0,0 +1,80 @@
+// internal/vault/keys_test.go
+package vault
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+)
+
+// TestLocalUnlockDerivesOnce checks that the key OpenLocalFileStore derives
+// also unlocks the vault config, before and after the KDF settings change,
+// so kdf tune's timing holds for the local backend. UnlockDerived gets no
+// password, so it could not have derived another key.
+func TestLocalUnlockDerivesOnce(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("HOME", t.TempDir())
+	path := filepath.Join(t.TempDir(), "vault.enc")
+
+	passKey, err := NewPasswordKey(testPassword, crypto.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer passKey.Destroy()
+	store, err := database.CreateLocalFileStore(path, passKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, dataKey, err := NewConfig(passKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dataKey.SecureClear()
+	if err := store.SaveVaultConfig(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	unlock := func() {
+		t.Helper()
+		store, key, err := database.OpenLocalFileStore(path, testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		defer key.Destroy()
+
+		saved, err := store.GetVaultConfig(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlocked, err := UnlockDerived(nil, key, *saved)
+		if err != nil {
+			t.Fatalf("UnlockDerived() = %v", err)
+		}
+		defer unlocked.SecureClear()
+		if !sameKey(t, dataKey, unlocked) {
+			t.Error("unlocked a different data key")
+		}
+	}
+	unlock()
+
+	store, key, err := database.OpenLocalFileStore(path, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Destroy()
+	saved, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tuned := crypto.KDFParams{Algorithm: crypto.KDFArgon2id, Time: 2, Memory: 19 * 1024, Threads: 1}
+	if _, err := SetKDF(ctx, store, *saved, dataKey, testPassword, tuned); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	unlock()
+}
@@ -0,0 +1,290 @@
+// internal/vault/metadata.go
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// Names of the metadata fields, for binding when they are encrypted.
+const (
+	FieldTitle    = "title"
+	FieldUsername = "username"
+	FieldURL      = "url"
+	FieldCategory = "category"
+)
+
+// Purposes of the blind indexes, so equal values in different indexes do
+// not hash alike.
+const (
+	indexDomain   = "domain"
+	indexCategory = "category"
+)
+
+func metadataFields(cred *models.Credential) []secretField {
+	return []secretField{
+		{FieldTitle, &cred.Title},
+		{FieldUsername, &cred.Username},
+		{FieldURL, &cred.URL},
+		{FieldCategory, &cred.Category},
+	}
+}
+
+// Domain returns the host of rawURL as the domain index sees it: lower
+// case, without port or a leading "www.". A bare host is accepted too.
+func Domain(rawURL string) string {
+	rawURL = strings.TrimSpace(rawURL)
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+func normalizeCategory(category string) string {
+	return strings.ToLower(strings.TrimSpace(category))
+}
+
+// SealMetadata encrypts the title, username, URL and category of cred in
+// place and sets its blind indexes. cred.ID must already be set.
+func SealMetadata(key *crypto.CryptoService, cred *models.Credential) error {
+	if cred.MetadataEncrypted {
+		return nil
+	}
+
+	domain, category := Domain(cred.URL), cred.Category
+	for _, field := range metadataFields(cred) {
+		if *field.value == "" {
+			continue
+		}
+		encrypted, err := Seal(key, cred.ID, field.name, *field.value)
+		if err != nil {
+			return err
+		}
+		*field.value = encrypted
+	}
+
+	cred.MetadataEncrypted = true
+	return setIndexes(key, cred, domain, category)
+}
+
+// OpenMetadata returns cred with its title, username, URL and category in
+// the clear. Credentials stored without encrypted metadata come back as
+// they are.
+func OpenMetadata(key *crypto.CryptoService, cred models.Credential, minVersion int) (models.Credential, error) {
+	if !cred.MetadataEncrypted {
+		return cred, nil
+	}
+
+	for _, field := range metadataFields(&cred) {
+		if *field.value == "" {
+			continue
+		}
+		plaintext, err := Open(key, cred.ID, field.name, *field.value, minVersion)
+		if err != nil {
+			return cred, fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.value = plaintext
+	}
+
+	cred.MetadataEncrypted = false
+	cred.DomainIndex, cred.CategoryIndex = "", ""
+	return cred, nil
+}
+
+func setIndexes(key *crypto.CryptoService, cred *models.Credential, domain, category string) error {
+	var err error
+	cred.DomainIndex, cred.CategoryIndex = "", ""
+	if domain != "" {
+		if cred.DomainIndex, err = key.BlindIndex(indexDomain, domain); err != nil {
+			return err
+		}
+	}
+	if category = normalizeCategory(category); category != "" {
+		if cred.CategoryIndex, err = key.BlindIndex(indexCategory, category); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindex recomputes the blind indexes of a credential whose metadata is
+// encrypted under key.
+func reindex(key *crypto.CryptoService, cred *models.Credential, minVersion int) error {
+	plain, err := OpenMetadata(key, *cred, minVersion)
+	if err != nil {
+		return err
+	}
+	return setIndexes(key, cred, Domain(plain.URL), plain.Category)
+}
+
+// SetMetadataEncryption turns encryption of credential metadata on or off
+// and converts every record to match. The config is saved first so new
+// records already follow the setting. Each record reads fine in either
+// form, so an interrupted run only has to be run again.
+func SetMetadataEncryption(ctx context.Context, store database.VaultStore, key *crypto.CryptoService, cfg models.VaultConfig, enable bool, progress Progress) (models.VaultConfig, *Report, error) {
+	// Records may be under either key, and saving cfg would drop the marker
+	if err := CheckRotation(ctx, store); err != nil {
+		return cfg, nil, err
+	}
+
+	next := cfg
+	next.EncryptMetadata = enable
+	if err := store.UpdateVaultConfig(ctx, next.ID, next); err != nil {
+		return cfg, nil, err
+	}
+
+	creds, err := store.ListCredentials(ctx, database.Filter{})
+	if err != nil {
+		return next, nil, err
+	}
+
+	report := &Report{Total: len(creds)}
+	for i, cred := range creds {
+		changed, err := updateRecord(ctx, store, cred, func(cred models.Credential) (models.Credential, bool, error) {
+			return convertMetadata(cred, key, enable, next.CipherVersion)
+		})
+
+		var recErr *recordError
+		switch {
+		case errors.As(err, &recErr):
+			report.Failures = append(report.Failures, failure(cred, err))
+		case err != nil:
+			return next, report, err
+		}
+		if changed {
+			report.Migrated++
+		}
+
+		if progress != nil {
+			progress(i+1, len(creds))
+		}
+	}
+
+	return next, report, nil
+}
+
+func convertMetadata(cred models.Credential, key *crypto.CryptoService, enable bool, minVersion int) (models.Credential, bool, error) {
+	if cred.MetadataEncrypted == enable {
+		return cred, false, nil
+	}
+
+	var err error
+	if enable {
+		err = SealMetadata(key, &cred)
+	} else {
+		cred, err = OpenMetadata(key, cred, minVersion)
+	}
+	if err != nil {
+		return cred, false, &recordError{"metadata", err}
+	}
+	return cred, true, nil
+}
+
+// Query selects credentials. Term matches any part of the title, username,
+// URL or category. Domain and Categories match exactly, ignoring the case
+// of categories, and with encrypted metadata the server answers them
+// through the blind indexes.
+type Query struct {
+	Term       string
+	Domain     string
+	Categories []string
+	Since      time.Time
+}
+
+// Find returns the credentials matching q with their metadata decrypted;
+// secrets stay encrypted. With encrypted metadata, substring matching can
+// only happen here, after decryption. Records whose metadata cannot be
+// decrypted are returned as failures instead.
+//
+// Records are matched by how each is stored rather than by cfg, as
+// SetMetadataEncryption may not have reached all of them yet.
+func Find(ctx context.Context, store database.VaultStore, key *crypto.CryptoService, cfg models.VaultConfig, q Query) ([]models.Credential, []Failure, error) {
+	domain := Domain(q.Domain)
+
+	sealed := []database.Condition{database.MetadataEncrypted(true)}
+	if domain != "" {
+		index, err := key.BlindIndex(indexDomain, domain)
+		if err != nil {
+			return nil, nil, err
+		}
+		sealed = append(sealed, database.Equals(database.FieldDomainIndex, index))
+	}
+	if len(q.Categories) > 0 {
+		indexes := make([]database.Condition, len(q.Categories))
+		for i, category := range q.Categories {
+			index, err := key.BlindIndex(indexCategory, normalizeCategory(category))
+			if err != nil {
+				return nil, nil, err
+			}
+			indexes[i] = database.Equals(database.FieldCategoryIndex, index)
+		}
+		sealed = append(sealed, database.Any(indexes...))
+	}
+
+	// Categories in the clear are left to matches, which ignores their case
+	// as the index does
+	plain := []database.Condition{database.MetadataEncrypted(false), database.Search(q.Term)}
+	if domain != "" {
+		plain = append(plain, database.Contains(database.FieldURL, domain))
+	}
+
+	conds := []database.Condition{database.Any(database.All(sealed...), database.All(plain...))}
+	if !q.Since.IsZero() {
+		conds = append(conds, database.Between(database.FieldUpdated, q.Since, time.Time{}))
+	}
+
+	creds, err := store.ListCredentials(ctx, database.All(conds...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Everything is checked again in the clear: indexes can collide and a
+	// LIKE on the URL is wider than an exact domain
+	var found []models.Credential
+	var failures []Failure
+	for _, cred := range creds {
+		plain, err := OpenMetadata(key, cred, cfg.CipherVersion)
+		if err != nil {
+			failures = append(failures, failure(cred, err))
+			continue
+		}
+		if q.matches(plain, domain) {
+			found = append(found, plain)
+		}
+	}
+	return found, failures, nil
+}
+
+func (q Query) matches(cred models.Credential, domain string) bool {
+	if !database.Search(q.Term).Match(cred) {
+		return false
+	}
+	if domain != "" && Domain(cred.URL) != domain {
+		return false
+	}
+	if len(q.Categories) == 0 {
+		return true
+	}
+	for _, category := range q.Categories {
+		if normalizeCategory(category) == normalizeCategory(cred.Category) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+// internal/vault/metadata_test.go
+package vault
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// TestFindMixedStorage searches a vault left half converted by an
+// interrupted SetMetadataEncryption.
+func TestFindMixedStorage(t *testing.T) {
+	ctx := context.Background()
+	store, cfg, dataKey := rekeyVault(t)
+	cfg.EncryptMetadata = true
+
+	add := func(cred models.Credential, seal bool) string {
+		t.Helper()
+		var err error
+		if cred.ID, err = database.NewRecordID(); err != nil {
+			t.Fatal(err)
+		}
+		if seal {
+			if err := SealMetadata(dataKey, &cred); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := store.CreateCredential(ctx, cred); err != nil {
+			t.Fatal(err)
+		}
+		return cred.ID
+	}
+
+	plain := add(models.Credential{Title: "Mail", URL: "https://example.com", Category: "work"}, false)
+	sealed := add(models.Credential{Title: "Login", URL: "https://www.example.com/login", Category: "Work"}, true)
+	add(models.Credential{Title: "Bank", URL: "https://bank.test", Category: "finance"}, true)
+
+	// Metadata that no longer decrypts
+	broken := models.Credential{Title: "Broken", URL: "https://example.com", Category: "work"}
+	brokenID := add(broken, true)
+	stored, err := store.GetCredential(ctx, brokenID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored.Title = stored.Title[:len(stored.Title)-4] + "AAAA"
+	if _, err := store.UpdateCredential(ctx, brokenID, *stored); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		query Query
+		want  []string
+	}{
+		{"category", Query{Categories: []string{"Work"}}, []string{plain, sealed}},
+		{"category lower case", Query{Categories: []string{"work"}}, []string{plain, sealed}},
+		{"domain", Query{Domain: "example.com"}, []string{plain, sealed}},
+		{"term", Query{Term: "mail"}, []string{plain}},
+		{"term sealed", Query{Term: "login"}, []string{sealed}},
+		{"no match", Query{Domain: "example.com", Categories: []string{"finance"}}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			found, failures, err := Find(ctx, store, dataKey, cfg, tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []string
+			for _, cred := range found {
+				got = append(got, cred.ID)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("Find() = %v, want %v", got, want)
+			}
+
+			for _, failure := range failures {
+				if failure.ID != brokenID {
+					t.Errorf("unexpected failure %s: %v", failure.ID, failure.Err)
+				}
+			}
+		})
+	}
+
+	_, failures, err := Find(ctx, store, dataKey, cfg, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 1 || failures[0].ID != brokenID {
+		t.Errorf("failures = %v, want only %s", failures, brokenID)
+	}
+}
@@ -0,0 +1,73 @@
+// internal/vault/pin.go
+package vault
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"passmanager/internal/config"
+	"passmanager/internal/crypto"
+	"passmanager/internal/models"
+)
+
+// pinPurpose names the blind index that identifies a data key in the pin
+// file without revealing anything about it.
+const pinPurpose = "cipher version pin"
+
+func pinPath() string {
+	return filepath.Join(config.GetConfigDir(), "cipher_versions.json")
+}
+
+// PinCipherVersion keeps the vault config's CipherVersion from going down.
+// The config is stored on the backend, where anyone with write access could
+// lower it and then swap older, unbound ciphertexts back in. This device
+// remembers the highest version it has seen for dataKey: a lower one in cfg
+// is raised to it, and the result reports that it was. A higher one is
+// recorded.
+func PinCipherVersion(cfg *models.VaultConfig, dataKey *crypto.CryptoService) (bool, error) {
+	id, err := dataKey.BlindIndex(pinPurpose, "")
+	if err != nil {
+		return false, err
+	}
+
+	pins, err := loadPins()
+	if err != nil {
+		return false, err
+	}
+
+	switch pinned := pins[id]; {
+	case cfg.CipherVersion < pinned:
+		cfg.CipherVersion = pinned
+		return true, nil
+	case cfg.CipherVersion > pinned:
+		pins[id] = cfg.CipherVersion
+		return false, savePins(pins)
+	}
+	return false, nil
+}
+
+func loadPins() (map[string]int, error) {
+	pins := map[string]int{}
+	data, err := os.ReadFile(pinPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("corrupt cipher version pins: %w", err)
+	}
+	return pins, nil
+}
+
+func savePins(pins map[string]int) error {
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return config.WriteFileAtomic(pinPath(), data, 0600)
+}
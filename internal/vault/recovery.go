@@ -0,0 +1,291 @@
+// internal/vault/recovery.go
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// A recovery key can unwrap the data key without the master password. It is
+// never stored: the user keeps it, either whole in an emergency kit or split
+// into shares of which a threshold number are needed to rebuild it. The
+// vault config only holds its public half, so the data key can be wrapped
+// for it again after a rotation.
+
+var (
+	ErrNoRecovery          = database.ErrNoRecovery
+	ErrInvalidShare        = errors.New("invalid recovery share")
+	ErrRecoveryKeyMismatch = errors.New("the recovery key does not belong to this vault")
+)
+
+// Shares are written as "PMS-" and base32 in groups of five. They hold a
+// format version, the set the share belongs to, the threshold, the share
+// itself and a checksum that catches typos. A whole recovery key is written
+// the same way after "PMR-", with just the version and the checksum.
+const (
+	sharePrefix       = "PMS-"
+	recoveryKeyPrefix = "PMR-"
+	shareVersion      = 1
+	shareHeaderLen    = 6
+	checksumLen       = 4
+)
+
+var shareEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// encodeChecked appends a checksum to buf and writes it in groups.
+func encodeChecked(prefix string, buf []byte) string {
+	sum := sha256.Sum256(buf)
+	encoded := shareEncoding.EncodeToString(append(buf, sum[:checksumLen]...))
+
+	var groups []string
+	for len(encoded) > 5 {
+		groups = append(groups, encoded[:5])
+		encoded = encoded[5:]
+	}
+	groups = append(groups, encoded)
+	return prefix + strings.Join(groups, "-")
+}
+
+// decodeChecked reverses encodeChecked for size bytes, not counting the
+// checksum. Case, spaces and dashes do not matter. text is left as it is;
+// the caller should clear the result once done with it.
+func decodeChecked(prefix string, text []byte, size int) ([]byte, error) {
+	text = bytes.TrimSpace(text)
+	if hasPrefixFold(text, prefix) {
+		text = text[len(prefix):]
+	}
+
+	// Room for every byte up front, so append never leaves a copy behind
+	clean := make([]byte, 0, len(text))
+	defer clear(clean[:cap(clean)])
+	for _, c := range text {
+		switch {
+		case c == '-' || c == ' ':
+		case 'a' <= c && c <= 'z':
+			clean = append(clean, c-'a'+'A')
+		default:
+			clean = append(clean, c)
+		}
+	}
+
+	buf := make([]byte, shareEncoding.DecodedLen(len(clean)))
+	n, err := shareEncoding.Decode(buf, clean)
+	if err != nil {
+		clear(buf)
+		return nil, errors.New("not valid base32")
+	}
+	if n != size+checksumLen {
+		clear(buf)
+		return nil, errors.New("wrong length, a part may be missing")
+	}
+
+	body, checksum := buf[:size], buf[size:n]
+	sum := sha256.Sum256(body)
+	if !bytes.Equal(sum[:checksumLen], checksum) {
+		clear(buf)
+		return nil, errors.New("checksum mismatch, check for typos")
+	}
+	clear(checksum)
+	if version := body[0]; version != shareVersion {
+		clear(buf)
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	return body, nil
+}
+
+func hasPrefixFold(text []byte, prefix string) bool {
+	return len(text) >= len(prefix) && bytes.EqualFold(text[:len(prefix)], []byte(prefix))
+}
+
+// Share is one part of a split recovery key.
+type Share struct {
+	Set       uint32 // the same for every share of one recovery key
+	Threshold int
+	data      []byte // the x coordinate, then one byte per key byte
+}
+
+func (s Share) Index() int {
+	return int(s.data[0])
+}
+
+func (s Share) String() string {
+	buf := []byte{shareVersion}
+	buf = binary.BigEndian.AppendUint32(buf, s.Set)
+	buf = append(buf, byte(s.Threshold))
+	buf = append(buf, s.data...)
+	return encodeChecked(sharePrefix, buf)
+}
+
+// ParseShare reads a share as String writes it.
+func ParseShare(text []byte) (Share, error) {
+	body, err := decodeChecked(sharePrefix, text, shareHeaderLen+1+crypto.RecoveryKeySize)
+	if err != nil {
+		return Share{}, fmt.Errorf("%w: %v", ErrInvalidShare, err)
+	}
+
+	share := Share{
+		Set:       binary.BigEndian.Uint32(body[1:5]),
+		Threshold: int(body[5]),
+		data:      body[shareHeaderLen:],
+	}
+	if share.Threshold < 2 || share.Index() == 0 {
+		clear(body)
+		return Share{}, fmt.Errorf("%w: corrupt share", ErrInvalidShare)
+	}
+	return share, nil
+}
+
+// FormatRecoveryKey writes a whole recovery key for the user to keep.
+func FormatRecoveryKey(recoveryKey []byte) string {
+	return encodeChecked(recoveryKeyPrefix, append([]byte{shareVersion}, recoveryKey...))
+}
+
+// IsRecoveryKey tells a recovery key written by FormatRecoveryKey from a
+// share.
+func IsRecoveryKey(text []byte) bool {
+	return hasPrefixFold(bytes.TrimSpace(text), recoveryKeyPrefix)
+}
+
+// ParseRecoveryKey reverses FormatRecoveryKey. The caller must Destroy the
+// result.
+func ParseRecoveryKey(text []byte) (*crypto.SecureBuffer, error) {
+	body, err := decodeChecked(recoveryKeyPrefix, text, 1+crypto.RecoveryKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", crypto.ErrInvalidRecoveryKey, err)
+	}
+	return crypto.SecureBytes(body[1:])
+}
+
+// shareSet identifies the recovery key behind publicKey in its shares.
+func shareSet(publicKey string) uint32 {
+	sum := sha256.Sum256([]byte(publicKey))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// NewRecoveryKey returns cfg with a new recovery key, and the key itself.
+// Nothing is saved: hand the key to the user first, then EnableRecovery, so
+// that a failure on the way leaves the earlier key working. The caller must
+// Destroy the key.
+func NewRecoveryKey(cfg models.VaultConfig, dataKey *crypto.CryptoService) (models.VaultConfig, *crypto.SecureBuffer, error) {
+	if cfg.WrappedKey == "" {
+		return cfg, nil, errors.New("the vault has to be upgraded before recovery can be set up")
+	}
+
+	recoveryKey, err := crypto.GenerateRecoveryKey()
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	next := cfg
+	if next.RecoveryPublicKey, err = crypto.RecoveryPublicKey(recoveryKey.Bytes()); err == nil {
+		next.RecoveryWrappedKey, err = crypto.RecoveryWrap(next.RecoveryPublicKey, dataKey)
+	}
+	if err != nil {
+		recoveryKey.Destroy()
+		return cfg, nil, err
+	}
+	return next, recoveryKey, nil
+}
+
+// EnableRecovery saves a config from NewRecoveryKey. From then on only its
+// recovery key works; any earlier one stops working.
+func EnableRecovery(ctx context.Context, store database.VaultStore, cfg models.VaultConfig) error {
+	// The rotation would commit a config without the new recovery key
+	if err := CheckRotation(ctx, store); err != nil {
+		return err
+	}
+	return store.UpdateVaultConfig(ctx, cfg.ID, cfg)
+}
+
+// DisableRecovery removes the recovery key, so its shares no longer work.
+func DisableRecovery(ctx context.Context, store database.VaultStore, cfg models.VaultConfig) (models.VaultConfig, error) {
+	if err := CheckRotation(ctx, store); err != nil {
+		return cfg, err
+	}
+
+	next := cfg
+	next.RecoveryPublicKey = ""
+	next.RecoveryWrappedKey = ""
+	if err := store.UpdateVaultConfig(ctx, next.ID, next); err != nil {
+		return cfg, err
+	}
+	return next, nil
+}
+
+// SplitRecoveryKey splits the recovery key of cfg into n shares, any
+// threshold of which rebuild it.
+func SplitRecoveryKey(cfg models.VaultConfig, recoveryKey []byte, n, threshold int) ([]Share, error) {
+	raw, err := crypto.SplitSecret(recoveryKey, n, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	set := shareSet(cfg.RecoveryPublicKey)
+	shares := make([]Share, len(raw))
+	for i, data := range raw {
+		shares[i] = Share{Set: set, Threshold: threshold, data: data}
+	}
+	return shares, nil
+}
+
+// CombineShares rebuilds a recovery key from its shares. The caller must
+// Destroy the result.
+func CombineShares(shares []Share) (*crypto.SecureBuffer, error) {
+	if len(shares) == 0 {
+		return nil, fmt.Errorf("%w: no shares", ErrInvalidShare)
+	}
+
+	first := shares[0]
+	raw := make([][]byte, len(shares))
+	for i, share := range shares {
+		if share.Set != first.Set || share.Threshold != first.Threshold {
+			return nil, fmt.Errorf("%w: share %d is from a different set", ErrInvalidShare, share.Index())
+		}
+		raw[i] = share.data
+	}
+	if len(shares) < first.Threshold {
+		return nil, fmt.Errorf("%w: %d of %d shares needed", ErrInvalidShare, len(shares), first.Threshold)
+	}
+
+	recoveryKey, err := crypto.CombineShares(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidShare, err)
+	}
+	return recoveryKey, nil
+}
+
+// UnlockWithRecovery returns the data key of cfg using its recovery key.
+func UnlockWithRecovery(recoveryKey []byte, cfg models.VaultConfig) (*crypto.CryptoService, error) {
+	if cfg.RecoveryWrappedKey == "" {
+		return nil, ErrNoRecovery
+	}
+
+	publicKey, err := crypto.RecoveryPublicKey(recoveryKey)
+	if err != nil {
+		return nil, err
+	}
+	if publicKey != cfg.RecoveryPublicKey {
+		return nil, ErrRecoveryKeyMismatch
+	}
+
+	return crypto.RecoveryUnwrap(recoveryKey, cfg.RecoveryWrappedKey)
+}
+
+// Recover sets newPassword on a vault unlocked with its recovery key. The
+// key file requirement is dropped too, since losing it may be why the
+// vault needed recovering; the recovery key itself stays valid.
+func Recover(ctx context.Context, store database.VaultStore, cfg models.VaultConfig, dataKey *crypto.CryptoService, newPassword []byte) (models.VaultConfig, error) {
+	next := cfg
+	next.KeyFile = false
+	return ChangePassword(ctx, store, next, dataKey, newPassword)
+}
@@ -0,0 +1,198 @@
+// internal/vault/recovery_test.go
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/models"
+)
+
+// recoveryVault returns a config with recovery set up, its data key and its
+// recovery key.
+func recoveryVault(t *testing.T) (models.VaultConfig, *crypto.CryptoService, *crypto.SecureBuffer) {
+	t.Helper()
+	dataKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dataKey.SecureClear)
+
+	cfg, recoveryKey, err := NewRecoveryKey(models.VaultConfig{WrappedKey: "wrapped"}, dataKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(recoveryKey.Destroy)
+	return cfg, dataKey, recoveryKey
+}
+
+// sameKey reports whether a and b decrypt each other's ciphertexts.
+func sameKey(t *testing.T, a, b *crypto.CryptoService) bool {
+	t.Helper()
+	ciphertext, err := a.Encrypt("probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := b.Decrypt(ciphertext)
+	if err != nil {
+		return false
+	}
+	defer plaintext.Destroy()
+	return bytes.Equal(plaintext.Bytes(), []byte("probe"))
+}
+
+func TestRecoveryKeyRoundTrip(t *testing.T) {
+	cfg, dataKey, recoveryKey := recoveryVault(t)
+
+	text := FormatRecoveryKey(recoveryKey.Bytes())
+	if !IsRecoveryKey([]byte(text)) {
+		t.Fatalf("IsRecoveryKey(%q) = false", text)
+	}
+
+	// Case, spaces and dashes do not matter
+	spaced := recoveryKeyPrefix + strings.ReplaceAll(strings.TrimPrefix(text, recoveryKeyPrefix), "-", " ")
+	for _, input := range []string{text, strings.ToLower(text), " " + spaced + " "} {
+		parsed, err := ParseRecoveryKey([]byte(input))
+		if err != nil {
+			t.Fatalf("ParseRecoveryKey(%q): %v", input, err)
+		}
+		if !bytes.Equal(parsed.Bytes(), recoveryKey.Bytes()) {
+			t.Errorf("ParseRecoveryKey(%q) returned a different key", input)
+		}
+
+		unlocked, err := UnlockWithRecovery(parsed.Bytes(), cfg)
+		parsed.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameKey(t, dataKey, unlocked) {
+			t.Error("the recovery key unwrapped a different data key")
+		}
+		unlocked.SecureClear()
+	}
+}
+
+func TestRecoveryKeyTypo(t *testing.T) {
+	_, _, recoveryKey := recoveryVault(t)
+	text := FormatRecoveryKey(recoveryKey.Bytes())
+
+	for i := len(recoveryKeyPrefix); i < len(text); i++ {
+		if text[i] == '-' {
+			continue
+		}
+		typo := []byte(text)
+		if typo[i] == 'A' {
+			typo[i] = 'B'
+		} else {
+			typo[i] = 'A'
+		}
+		// The last character ends in padding bits, which may not change
+		// the key at all
+		parsed, err := ParseRecoveryKey(typo)
+		if err == nil {
+			if !bytes.Equal(parsed.Bytes(), recoveryKey.Bytes()) {
+				t.Errorf("a typo at %d was not caught", i)
+			}
+			parsed.Destroy()
+		} else if !errors.Is(err, crypto.ErrInvalidRecoveryKey) {
+			t.Errorf("a typo at %d: ParseRecoveryKey() = %v, want ErrInvalidRecoveryKey", i, err)
+		}
+	}
+
+	missing := text[:len(text)-6]
+	if _, err := ParseRecoveryKey([]byte(missing)); !errors.Is(err, crypto.ErrInvalidRecoveryKey) {
+		t.Errorf("a missing group: ParseRecoveryKey() = %v, want ErrInvalidRecoveryKey", err)
+	}
+}
+
+func TestRecoveryShares(t *testing.T) {
+	cfg, dataKey, recoveryKey := recoveryVault(t)
+	shares, err := SplitRecoveryKey(cfg, recoveryKey.Bytes(), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shares go through their written form, as the user types them back in
+	var parsed []Share
+	for _, share := range shares {
+		back, err := ParseShare([]byte(share.String()))
+		if err != nil {
+			t.Fatalf("ParseShare(%q): %v", share, err)
+		}
+		if back.Set != share.Set || back.Threshold != 2 || back.Index() != share.Index() {
+			t.Errorf("ParseShare(%q) = set %d, threshold %d, index %d", share, back.Set, back.Threshold, back.Index())
+		}
+		parsed = append(parsed, back)
+	}
+
+	for _, picked := range [][]Share{parsed[:2], parsed[1:], {parsed[2], parsed[0]}, parsed} {
+		rebuilt, err := CombineShares(picked)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unlocked, err := UnlockWithRecovery(rebuilt.Bytes(), cfg)
+		rebuilt.Destroy()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sameKey(t, dataKey, unlocked) {
+			t.Error("the shares rebuilt a different recovery key")
+		}
+		unlocked.SecureClear()
+	}
+
+	if _, err := CombineShares(parsed[:1]); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("one of two shares: CombineShares() = %v, want ErrInvalidShare", err)
+	}
+}
+
+func TestRecoverySharesFromDifferentSets(t *testing.T) {
+	cfg, _, recoveryKey := recoveryVault(t)
+	otherCfg, _, otherKey := recoveryVault(t)
+
+	shares, err := SplitRecoveryKey(cfg, recoveryKey.Bytes(), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := SplitRecoveryKey(otherCfg, otherKey.Bytes(), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([]Share{shares[0], other[1]}); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("CombineShares() = %v, want ErrInvalidShare", err)
+	}
+
+	threshold3, err := SplitRecoveryKey(cfg, recoveryKey.Bytes(), 3, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([]Share{shares[0], threshold3[1], threshold3[2]}); !errors.Is(err, ErrInvalidShare) {
+		t.Errorf("mixed thresholds: CombineShares() = %v, want ErrInvalidShare", err)
+	}
+}
+
+func TestParseShareInvalid(t *testing.T) {
+	cfg, _, recoveryKey := recoveryVault(t)
+	shares, err := SplitRecoveryKey(cfg, recoveryKey.Bytes(), 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := shares[0].String()
+	typo := []byte(text)
+	typo[len(typo)/2] ^= 'A' ^ 'B'
+
+	tests := map[string]string{
+		"empty":        "",
+		"recovery key": FormatRecoveryKey(recoveryKey.Bytes()),
+		"truncated":    text[:len(text)-6],
+		"typo":         string(typo),
+	}
+	for name, input := range tests {
+		if _, err := ParseShare([]byte(input)); !errors.Is(err, ErrInvalidShare) {
+			t.Errorf("%s: ParseShare() = %v, want ErrInvalidShare", name, err)
+		}
+	}
+}
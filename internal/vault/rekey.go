@@ -0,0 +1,342 @@
+// internal/vault/rekey.go
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// ErrRekeyInProgress is returned by Begin while an earlier change is still
+// recorded in the journal.
+var ErrRekeyInProgress = errors.New("an interrupted vault key rotation has to be finished or rolled back first")
+
+// ErrRekeyElsewhere is ErrRekeyInProgress for a rotation that another device
+// sharing the vault started; only that device has its journal.
+var ErrRekeyElsewhere = fmt.Errorf("%w on the device that started it", ErrRekeyInProgress)
+
+// maxConflictRetries bounds how often a record is re-read when another
+// device changes it mid-migration.
+const maxConflictRetries = 3
+
+// journalBatch is how many records convert handles between journal writes.
+// Losing the last few to a crash only means checking them again.
+const journalBatch = 100
+
+// Progress is told how many of the vault's records have been processed.
+type Progress func(done, total int)
+
+// Failure is a record that could not be moved to the target key.
+type Failure struct {
+	ID    string
+	Title string
+	Err   error
+}
+
+type Report struct {
+	Total    int
+	Migrated int
+	Failures []Failure
+}
+
+// Rekey moves every record of a vault from one data key to another. Progress is
+// kept in a Journal, and each record is decrypted by trying both keys, so
+// the operation can be repeated safely after any interruption.
+type Rekey struct {
+	store   database.VaultStore
+	journal *Journal
+	oldKey  *crypto.CryptoService
+	newKey  *crypto.CryptoService
+}
+
+// recordError marks a problem with one record's data, as opposed to a
+// backend failure that stops the whole run.
+type recordError struct {
+	field string
+	err   error
+}
+
+func (e *recordError) Error() string {
+	return fmt.Sprintf("%s: %v", e.field, e.err)
+}
+
+// Begin records a change from oldKey to newKey, which r then owns. cfg is
+// the current vault config and next the one to save once every record has
+// moved. No record is touched until Run is called.
+func Begin(ctx context.Context, store database.VaultStore, oldKey, newKey *crypto.CryptoService, cfg, next models.VaultConfig) (*Rekey, error) {
+	if err := CheckRotation(ctx, store); err != nil {
+		newKey.SecureClear()
+		return nil, err
+	}
+
+	wrapped, err := oldKey.WrapKey(newKey)
+	if err != nil {
+		newKey.SecureClear()
+		return nil, err
+	}
+
+	journal := &Journal{
+		VaultID: next.ID,
+		Started: time.Now().UTC().Format(time.RFC3339),
+		Config:  next,
+		NewKey:  wrapped,
+	}
+	journal.Config.Rotation = ""
+	if err := journal.save(); err != nil {
+		newKey.SecureClear()
+		return nil, fmt.Errorf("failed to write rekey journal: %w", err)
+	}
+
+	marked := cfg
+	marked.Rotation = journal.Started
+	if err := store.UpdateVaultConfig(ctx, marked.ID, marked); err != nil {
+		RemoveJournal()
+		newKey.SecureClear()
+		return nil, fmt.Errorf("failed to mark the vault as rotating: %w", err)
+	}
+
+	return &Rekey{
+		store:   store,
+		journal: journal,
+		oldKey:  oldKey,
+		newKey:  newKey,
+	}, nil
+}
+
+// Resume picks up the change recorded in journal. oldKey is the key the
+// vault config still points at.
+func Resume(store database.VaultStore, oldKey *crypto.CryptoService, journal *Journal) (*Rekey, error) {
+	newKey, err := oldKey.UnwrapKey(journal.NewKey)
+	if err != nil {
+		return nil, fmt.Errorf("rekey journal does not belong to this vault: %w", err)
+	}
+
+	return &Rekey{
+		store:   store,
+		journal: journal,
+		oldKey:  oldKey,
+		newKey:  newKey,
+	}, nil
+}
+
+func (r *Rekey) Journal() *Journal {
+	return r.journal
+}
+
+// NewKey returns the key the vault moves to. It stays owned by r.
+func (r *Rekey) NewKey() *crypto.CryptoService {
+	return r.newKey
+}
+
+// Close clears the new key. Clone it first if it is still needed.
+func (r *Rekey) Close() {
+	r.newKey.SecureClear()
+}
+
+// Run moves every record to the new key, then reads the vault back and
+// checks that each record decrypts under it. Records that fail are listed
+// in the report; the caller decides whether to Commit, retry or Rollback.
+func (r *Rekey) Run(ctx context.Context, progress Progress) (*Report, error) {
+	report, err := r.convert(ctx, r.newKey, r.oldKey, progress)
+	if err != nil {
+		return report, err
+	}
+
+	failed := make(map[string]bool, len(report.Failures))
+	for _, failure := range report.Failures {
+		failed[failure.ID] = true
+	}
+
+	creds, err := r.store.ListCredentials(ctx, database.Filter{})
+	if err != nil {
+		return report, err
+	}
+
+	for _, cred := range creds {
+		if failed[cred.ID] {
+			continue
+		}
+		for _, field := range secretFields(&cred) {
+			if *field.value == "" {
+				continue
+			}
+			if err := opens(r.newKey, cred.ID, field.name, *field.value, r.journal.Config.CipherVersion); err != nil {
+				err = &recordError{field.name, errors.New("not readable with the new key")}
+				r.fail(report, cred, err)
+				break
+			}
+		}
+	}
+
+	report.Total = len(creds)
+	return report, r.journal.save()
+}
+
+// Commit points the vault config at the new key, which also clears the
+// rotation marker, and removes the journal. If it fails, the journal stays
+// and the change can still be resumed.
+func (r *Rekey) Commit(ctx context.Context) error {
+	cfg := r.journal.Config
+	if err := r.store.UpdateVaultConfig(ctx, cfg.ID, cfg); err != nil {
+		return fmt.Errorf("failed to save new vault config: %w", err)
+	}
+
+	return RemoveJournal()
+}
+
+// Rollback moves every record back to the old key. The rotation marker and
+// the journal are removed only if all of them made it.
+func (r *Rekey) Rollback(ctx context.Context, progress Progress) (*Report, error) {
+	report, err := r.convert(ctx, r.oldKey, r.newKey, progress)
+	if err != nil || len(report.Failures) > 0 {
+		return report, err
+	}
+	if err := r.unmark(ctx); err != nil {
+		return report, fmt.Errorf("failed to clear the rotation marker: %w", err)
+	}
+	return report, RemoveJournal()
+}
+
+// unmark clears the rotation marker Begin left in the stored config.
+func (r *Rekey) unmark(ctx context.Context) error {
+	current, err := r.store.GetVaultConfig(ctx)
+	if err != nil {
+		return err
+	}
+	if current.Rotation == "" {
+		return nil
+	}
+
+	cfg := *current
+	cfg.Rotation = ""
+	return r.store.UpdateVaultConfig(ctx, cfg.ID, cfg)
+}
+
+// convert re-encrypts every record under target. A field may be under
+// either key, since an interrupted run may or may not have written it.
+func (r *Rekey) convert(ctx context.Context, target, other *crypto.CryptoService, progress Progress) (*Report, error) {
+	creds, err := r.store.ListCredentials(ctx, database.Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{Total: len(creds)}
+	r.journal.Failed = nil
+	if target == r.newKey {
+		r.journal.Migrated = 0
+	}
+
+	for i, cred := range creds {
+		changed, err := r.convertRecord(ctx, cred, target, other)
+
+		var recErr *recordError
+		switch {
+		case errors.As(err, &recErr):
+			r.fail(report, cred, err)
+		case err != nil:
+			// Keep what was done so far for the next attempt
+			r.journal.save()
+			return report, err
+		case target == r.newKey:
+			r.journal.Migrated++
+		}
+		if changed {
+			report.Migrated++
+		}
+
+		if (i+1)%journalBatch == 0 || i+1 == len(creds) {
+			if err := r.journal.save(); err != nil {
+				return report, fmt.Errorf("failed to write rekey journal: %w", err)
+			}
+		}
+		if progress != nil {
+			progress(i+1, len(creds))
+		}
+	}
+
+	return report, nil
+}
+
+func (r *Rekey) convertRecord(ctx context.Context, cred models.Credential, target, other *crypto.CryptoService) (bool, error) {
+	return updateRecord(ctx, r.store, cred, func(cred models.Credential) (models.Credential, bool, error) {
+		return reencrypt(cred, target, other, r.journal.Config.CipherVersion)
+	})
+}
+
+// updateRecord saves the result of fn applied to cred, starting again from
+// the stored version if another device changed the record meanwhile.
+func updateRecord(ctx context.Context, store database.VaultStore, cred models.Credential, fn func(models.Credential) (models.Credential, bool, error)) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		updated, changed, err := fn(cred)
+		if err != nil || !changed {
+			return false, err
+		}
+
+		_, err = store.UpdateCredential(ctx, cred.ID, updated)
+
+		// Another device edited the record; start again from its version
+		var conflict *database.ConflictError
+		if errors.As(err, &conflict) && attempt < maxConflictRetries {
+			cred = conflict.Current
+			continue
+		}
+		return err == nil, err
+	}
+}
+
+// reencrypt returns cred with every field under target, and whether any
+// field had to change.
+func reencrypt(cred models.Credential, target, other *crypto.CryptoService, minVersion int) (models.Credential, bool, error) {
+	changed := false
+	for _, field := range secretFields(&cred) {
+		if *field.value == "" {
+			continue
+		}
+		if opens(target, cred.ID, field.name, *field.value, minVersion) == nil {
+			continue
+		}
+
+		plaintext, err := OpenSecret(other, cred.ID, field.name, *field.value, minVersion)
+		if err != nil {
+			return cred, false, &recordError{field.name, errors.New("not readable with either key")}
+		}
+
+		encrypted, err := sealChecked(target, cred.ID, field.name, plaintext)
+		plaintext.Destroy()
+		if err != nil {
+			return cred, false, &recordError{field.name, err}
+		}
+
+		*field.value = encrypted
+		changed = true
+	}
+
+	// Blind indexes are keyed too, so they move with the fields
+	if changed && cred.MetadataEncrypted {
+		if err := reindex(target, &cred, minVersion); err != nil {
+			return cred, false, &recordError{"metadata", err}
+		}
+	}
+	return cred, changed, nil
+}
+
+func failure(cred models.Credential, err error) Failure {
+	title := cred.Title
+	if cred.MetadataEncrypted {
+		title = ""
+	}
+	return Failure{ID: cred.ID, Title: title, Err: err}
+}
+
+func (r *Rekey) fail(report *Report, cred models.Credential, err error) {
+	report.Failures = append(report.Failures, failure(cred, err))
+	if r.journal.Failed == nil {
+		r.journal.Failed = map[string]string{}
+	}
+	r.journal.Failed[cred.ID] = err.Error()
+}
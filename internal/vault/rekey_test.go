@@ -0,0 +1,214 @@
+// internal/vault/rekey_test.go
+package vault
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+var testPassword = []byte("correct horse battery staple")
+
+// rekeyVault returns a SQLite vault with one credential, its config and its
+// data key. HOME points at a fresh directory, so the journal does too.
+func rekeyVault(t *testing.T) (*database.SQLiteStore, models.VaultConfig, *crypto.CryptoService) {
+	t.Helper()
+	ctx := context.Background()
+	t.Setenv("HOME", t.TempDir())
+
+	store, err := database.OpenSQLiteStore(filepath.Join(t.TempDir(), "vault.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	passKey, err := NewPasswordKey(testPassword, crypto.DefaultKDF())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer passKey.Destroy()
+	cfg, dataKey, err := NewConfig(passKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(dataKey.SecureClear)
+	if err := store.SaveVaultConfig(ctx, cfg); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := database.NewRecordID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := models.Credential{ID: id, Title: "example"}
+	if cred.EncryptedPassword, err = Seal(dataKey, id, FieldPassword, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.CreateCredential(ctx, cred); err != nil {
+		t.Fatal(err)
+	}
+	return store, *saved, dataKey
+}
+
+func rotation(t *testing.T, store database.VaultStore) string {
+	t.Helper()
+	cfg, err := store.GetVaultConfig(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.Rotation
+}
+
+func TestRotationMarker(t *testing.T) {
+	ctx := context.Background()
+	store, cfg, dataKey := rekeyVault(t)
+
+	rekey, err := RotateKey(ctx, store, dataKey, cfg, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rekey.Close()
+
+	if got := rotation(t, store); got != rekey.Journal().Started {
+		t.Errorf("Rotation = %q, want %q", got, rekey.Journal().Started)
+	}
+	if err := CheckRotation(ctx, store); !errors.Is(err, ErrRekeyInProgress) {
+		t.Errorf("CheckRotation() = %v, want ErrRekeyInProgress", err)
+	}
+
+	// Another device sharing the vault has no journal, only the marker
+	journal, err := os.ReadFile(JournalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveJournal(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRotation(ctx, store); !errors.Is(err, ErrRekeyElsewhere) {
+		t.Errorf("CheckRotation() elsewhere = %v, want ErrRekeyElsewhere", err)
+	}
+	if _, err := ChangePassword(ctx, store, cfg, dataKey, []byte("another password")); !errors.Is(err, ErrRekeyElsewhere) {
+		t.Errorf("ChangePassword() elsewhere = %v, want ErrRekeyElsewhere", err)
+	}
+	if _, err := DisableRecovery(ctx, store, cfg); !errors.Is(err, ErrRekeyElsewhere) {
+		t.Errorf("DisableRecovery() elsewhere = %v, want ErrRekeyElsewhere", err)
+	}
+	if _, _, err := UpgradeRecords(ctx, store, dataKey, cfg, nil); !errors.Is(err, ErrRekeyElsewhere) {
+		t.Errorf("UpgradeRecords() elsewhere = %v, want ErrRekeyElsewhere", err)
+	}
+	if _, err := RotateKey(ctx, store, dataKey, cfg, testPassword); !errors.Is(err, ErrRekeyElsewhere) {
+		t.Errorf("RotateKey() elsewhere = %v, want ErrRekeyElsewhere", err)
+	}
+	if got := rotation(t, store); got == "" {
+		t.Fatal("a refused change cleared the marker")
+	}
+	if err := os.WriteFile(JournalPath(), journal, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := rekey.Run(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) > 0 {
+		t.Fatalf("Run() failed for %v", report.Failures)
+	}
+	if err := rekey.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := rotation(t, store); got != "" {
+		t.Errorf("Rotation = %q after Commit, want it cleared", got)
+	}
+	if err := CheckRotation(ctx, store); err != nil {
+		t.Errorf("CheckRotation() after Commit = %v", err)
+	}
+}
+
+func TestRotationMarkerRollback(t *testing.T) {
+	ctx := context.Background()
+	store, cfg, dataKey := rekeyVault(t)
+
+	rekey, err := RotateKey(ctx, store, dataKey, cfg, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rekey.Close()
+
+	if _, err := rekey.Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	report, err := rekey.Rollback(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failures) > 0 {
+		t.Fatalf("Rollback() failed for %v", report.Failures)
+	}
+
+	if got := rotation(t, store); got != "" {
+		t.Errorf("Rotation = %q after Rollback, want it cleared", got)
+	}
+	if err := CheckRotation(ctx, store); err != nil {
+		t.Errorf("CheckRotation() after Rollback = %v", err)
+	}
+
+	creds, err := store.ListCredentials(ctx, database.Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, err := OpenSecret(dataKey, creds[0].ID, FieldPassword, creds[0].EncryptedPassword, cfg.CipherVersion)
+	if err != nil {
+		t.Fatalf("the old key no longer opens the credential: %v", err)
+	}
+	password.Destroy()
+}
+
+func TestRotationOtherVault(t *testing.T) {
+	ctx := context.Background()
+	store, cfg, dataKey := rekeyVault(t)
+
+	// A journal left behind by another vault on this device
+	other := &Journal{VaultID: "othervault12345", Started: "2026-01-02T03:04:05Z"}
+	if err := other.save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckRotation(ctx, store); err != nil {
+		t.Errorf("CheckRotation() with another vault's journal = %v", err)
+	}
+	if _, err := ChangePassword(ctx, store, cfg, dataKey, testPassword); err != nil {
+		t.Errorf("ChangePassword() with another vault's journal = %v", err)
+	}
+}
+
+func TestJournalMigratedCount(t *testing.T) {
+	ctx := context.Background()
+	store, cfg, dataKey := rekeyVault(t)
+
+	rekey, err := RotateKey(ctx, store, dataKey, cfg, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rekey.Close()
+	if _, err := rekey.Run(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err := LoadJournal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if journal.Migrated != 1 {
+		t.Errorf("Migrated = %d after Run, want 1", journal.Migrated)
+	}
+}
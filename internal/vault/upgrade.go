@@ -0,0 +1,101 @@
+// internal/vault/upgrade.go
+package vault
+
+import (
+	"context"
+	"errors"
+
+	"passmanager/internal/crypto"
+	"passmanager/internal/database"
+	"passmanager/internal/models"
+)
+
+// NeedsRecordUpgrade reports whether some records may still use an older
+// ciphertext format.
+func NeedsRecordUpgrade(cfg models.VaultConfig) bool {
+	return cfg.CipherVersion < crypto.CipherCurrent
+}
+
+// UpgradeRecords rewrites fields still in an older ciphertext format under
+// the same key, binding each to its record and field. Every record reads
+// fine before and after its own update, so unlike a rekey this needs no
+// journal. Once all records are current the config is saved with the new
+// CipherVersion and returned.
+func UpgradeRecords(ctx context.Context, store database.VaultStore, key *crypto.CryptoService, cfg models.VaultConfig, progress Progress) (models.VaultConfig, *Report, error) {
+	// Records may be under either key, and saving cfg would drop the marker
+	if err := CheckRotation(ctx, store); err != nil {
+		return cfg, nil, err
+	}
+
+	creds, err := store.ListCredentials(ctx, database.Filter{})
+	if err != nil {
+		return cfg, nil, err
+	}
+
+	report := &Report{Total: len(creds)}
+	for i, cred := range creds {
+		changed, err := updateRecord(ctx, store, cred, func(cred models.Credential) (models.Credential, bool, error) {
+			return upgradeFields(cred, key, cfg.CipherVersion)
+		})
+
+		var recErr *recordError
+		switch {
+		case errors.As(err, &recErr):
+			report.Failures = append(report.Failures, failure(cred, err))
+		case err != nil:
+			return cfg, report, err
+		}
+		if changed {
+			report.Migrated++
+		}
+
+		if progress != nil {
+			progress(i+1, len(creds))
+		}
+	}
+
+	if len(report.Failures) > 0 {
+		return cfg, report, nil
+	}
+
+	next := cfg
+	next.CipherVersion = crypto.CipherCurrent
+	if err := store.UpdateVaultConfig(ctx, next.ID, next); err != nil {
+		return cfg, report, err
+	}
+	// Best effort; the next unlock records it otherwise
+	PinCipherVersion(&next, key)
+	return next, report, nil
+}
+
+func upgradeFields(cred models.Credential, key *crypto.CryptoService, minVersion int) (models.Credential, bool, error) {
+	changed := false
+	for _, field := range secretFields(&cred) {
+		if *field.value == "" {
+			continue
+		}
+
+		version, err := crypto.CipherVersion(*field.value)
+		if err != nil {
+			return cred, false, &recordError{field.name, err}
+		}
+		if version >= crypto.CipherCurrent {
+			continue
+		}
+
+		plaintext, err := OpenSecret(key, cred.ID, field.name, *field.value, minVersion)
+		if err != nil {
+			return cred, false, &recordError{field.name, err}
+		}
+
+		encrypted, err := sealChecked(key, cred.ID, field.name, plaintext)
+		plaintext.Destroy()
+		if err != nil {
+			return cred, false, &recordError{field.name, err}
+		}
+
+		*field.value = encrypted
+		changed = true
+	}
+	return cred, changed, nil
+}
@@ -2,10 +2,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -16,26 +22,41 @@ import (
 	"passmanager/internal/database"
 	"passmanager/internal/models"
 	"passmanager/internal/session"
+	"passmanager/internal/strength"
 	"passmanager/internal/ui"
+	"passmanager/internal/vault"
 
 	"github.com/atotto/clipboard"
 	"github.com/briandowns/spinner"
-	"github.com/manifoldco/promptui"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/manifoldco/promptui"
 )
 
+// shutdownGrace is how long the main loop gets to wind down after a signal
+// before the vault is locked and the process exits regardless.
+const shutdownGrace = 5 * time.Second
 
 func main() {
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Handle graceful shutdown: the first signal cancels in-flight requests
+	// and lets the main loop lock the vault; a second one exits at once
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
 		fmt.Println("\n\n" + ui.Warning("Shutting down securely..."))
-		sess := session.GetSession()
-		sess.Logout()
-		os.Exit(0)
+		cancel()
+
+		// A blocked prompt never sees the cancellation
+		select {
+		case <-sigChan:
+		case <-time.After(shutdownGrace):
+		}
+		session.GetSession().Logout()
+		os.Exit(1)
 	}()
 
 	// Start application
@@ -44,54 +65,49 @@ func main() {
 
 	// Check if initialized
 	if !config.Exists() {
-		runSetup()
+		runSetup(ctx)
 	}
 
 	// Main loop
-	runMainLoop()
+	session.GetSession().SetReauthPrompt(promptServerLogin)
+	runMainLoop(ctx)
+
+	session.GetSession().Logout()
+	fmt.Println(ui.Success("Vault locked. Goodbye! 👋"))
 }
 
-func runSetup() {
+func runSetup(ctx context.Context) {
 	ui.PrintSection("First Time Setup")
 
 	fmt.Println(ui.Info("Let's set up your secure password vault.\n"))
 
-	// Get PocketBase URL
-	pbURL, err := ui.InputPrompt("PocketBase URL", "http://127.0.0.1:8090", validateURL)
+	// Choose where the vault lives
+	backendOptions := []string{
+		"🌐 PocketBase server",
+		"💾 Local encrypted file (offline)",
+		"🗃️  SQLite database (offline)",
+	}
+	_, backendChoice, err := ui.SelectFromList("Storage backend", backendOptions)
 	if err != nil {
 		fmt.Println(ui.Error("Setup cancelled"))
 		os.Exit(1)
 	}
 
-	// Test connection
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Suffix = " Testing connection..."
-	s.Start()
-
-	client := database.NewPocketBaseClient(pbURL)
-	if err := client.TestConnection(); err != nil {
-		s.Stop()
-		fmt.Println(ui.Error(fmt.Sprintf("Cannot connect to PocketBase: %v", err)))
-		fmt.Println(ui.Info("Make sure PocketBase is running: ./pocketbase serve"))
-		os.Exit(1)
+	cfg := &config.Config{
+		Backend:     config.BackendPocketBase,
+		Settings:    models.DefaultSettings(),
+		Initialized: true,
 	}
-	s.Stop()
-	fmt.Println(ui.Success("Connected to PocketBase"))
-
-	// Get admin credentials
-	adminEmail, _ := ui.InputPrompt("Admin Email", "", validateEmail)
-	adminPass, _ := ui.PasswordPrompt("Admin Password")
-
-	s.Suffix = " Authenticating..."
-	s.Start()
 
-	if err := client.Authenticate(adminEmail, adminPass); err != nil {
-		s.Stop()
-		fmt.Println(ui.Error(fmt.Sprintf("Authentication failed: %v", err)))
-		os.Exit(1)
+	var client *database.PocketBaseClient
+	switch {
+	case strings.Contains(backendChoice, "encrypted file"):
+		cfg.Backend = config.BackendLocal
+	case strings.Contains(backendChoice, "SQLite"):
+		cfg.Backend = config.BackendSQLite
+	default:
+		client, cfg.PocketBaseURL, cfg.AdminEmail = setupPocketBase(ctx)
 	}
-	s.Stop()
-	fmt.Println(ui.Success("Authenticated successfully"))
 
 	// Create master password
 	fmt.Println()
@@ -100,46 +116,83 @@ func runSetup() {
 	fmt.Println(ui.Subtle("  It cannot be recovered if lost!"))
 	fmt.Println()
 
-	var masterPass string
-	for {
-		masterPass, _ = ui.PasswordPrompt("Master Password (min 12 chars)")
-		if len(masterPass) < 12 {
-			fmt.Println(ui.Error("Password must be at least 12 characters"))
-			continue
-		}
+	masterPass := promptNewMasterPassword("Master Password (min 12 chars)", "Confirm Master Password", cfg.AdminEmail, cfg.PocketBaseURL)
+	defer masterPass.Destroy()
 
-		confirmPass, _ := ui.PasswordPrompt("Confirm Master Password")
-		if masterPass != confirmPass {
-			fmt.Println(ui.Error("Passwords don't match"))
-			continue
+	fmt.Println()
+	fmt.Println(ui.Subtle("  A key file, for example on a USB stick, can be required as well."))
+	if ui.ConfirmPrompt("Require a key file to unlock the vault?") {
+		cfg.KeyFile = chooseKeyFile()
+	}
+
+	fmt.Println()
+	fmt.Println(ui.Subtle("  A recovery key can unlock the vault if the master password is forgotten."))
+	wantKit := ui.ConfirmPrompt("Create a recovery key and printable emergency kit?")
+
+	kdf := crypto.DefaultKDF()
+	fmt.Println()
+	fmt.Println(ui.Subtle(fmt.Sprintf("  Unlocking uses %s by default.", kdf.Summary())))
+	if ui.ConfirmPrompt("Calibrate it for this device instead?") {
+		if tuned, ok := promptKDFTuning(); ok {
+			kdf = tuned
 		}
-		break
 	}
 
+	secret, err := vault.WithKeyFile(masterPass.Bytes(), cfg.KeyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		os.Exit(1)
+	}
+	defer secret.Destroy()
+
 	// Generate salt and save config
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
 	s.Suffix = " Setting up vault..."
 	s.Start()
 
-	salt, _ := crypto.GenerateSalt()
-	passwordHash := crypto.HashMasterPassword(masterPass, salt)
+	// The vault config and the local vault file share this key
+	passKey, err := vault.NewPasswordKey(secret.Bytes(), kdf)
+	if err != nil {
+		s.Stop()
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to derive key: %v", err)))
+		os.Exit(1)
+	}
+	defer passKey.Destroy()
 
-	vaultConfig := models.VaultConfig{
-		Salt:         base64.StdEncoding.EncodeToString(salt),
-		PasswordHash: passwordHash,
+	var store database.VaultStore = client
+	switch cfg.Backend {
+	case config.BackendLocal:
+		localStore, err := database.CreateLocalFileStore(config.GetVaultPath(), passKey)
+		if err != nil {
+			s.Stop()
+			fmt.Println(ui.Error(fmt.Sprintf("Failed to create vault file: %v", err)))
+			os.Exit(1)
+		}
+		store = localStore
+	case config.BackendSQLite:
+		sqliteStore, err := database.OpenSQLiteStore(config.GetSQLitePath())
+		if err != nil {
+			s.Stop()
+			fmt.Println(ui.Error(fmt.Sprintf("Failed to create database: %v", err)))
+			os.Exit(1)
+		}
+		store = sqliteStore
 	}
 
-	if err := client.SaveVaultConfig(vaultConfig); err != nil {
+	vaultConfig, dataKey, err := vault.NewConfig(passKey)
+	if err != nil {
 		s.Stop()
-		fmt.Println(ui.Error(fmt.Sprintf("Failed to save vault config: %v", err)))
-		fmt.Println(ui.Info("Make sure 'vault_config' collection exists in PocketBase"))
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to generate vault key: %v", err)))
 		os.Exit(1)
 	}
+	// Kept for the recovery key, which is set up once the vault has an ID
+	defer dataKey.SecureClear()
+	vaultConfig.KeyFile = cfg.KeyFile != ""
 
-	cfg := &config.Config{
-		PocketBaseURL: pbURL,
-		AdminEmail:    adminEmail,
-		Settings:      models.DefaultSettings(),
-		Initialized:   true,
+	if err := store.SaveVaultConfig(ctx, vaultConfig); err != nil {
+		s.Stop()
+		fmt.Println(backendError("Failed to save vault config", err))
+		os.Exit(1)
 	}
 
 	if err := cfg.Save(); err != nil {
@@ -150,22 +203,238 @@ func runSetup() {
 
 	s.Stop()
 	fmt.Println(ui.Success("Vault created successfully!"))
+	switch cfg.Backend {
+	case config.BackendLocal:
+		fmt.Println(ui.Subtle("  Stored at " + config.GetVaultPath()))
+	case config.BackendSQLite:
+		fmt.Println(ui.Subtle("  Stored at " + config.GetSQLitePath()))
+	}
 	fmt.Println()
 	fmt.Println(ui.Warning("IMPORTANT: Remember your master password!"))
-	fmt.Println(ui.Subtle("  It cannot be recovered if lost."))
+	if !wantKit {
+		fmt.Println(ui.Subtle("  It cannot be recovered if lost."))
+	}
+	if cfg.KeyFile != "" {
+		fmt.Println(ui.Warning("Keep a backup of your key file too; the vault cannot be opened without it."))
+	}
+
+	if wantKit {
+		// The kit needs the ID the store gave the vault
+		fmt.Println()
+		if saved, err := store.GetVaultConfig(ctx); err != nil {
+			fmt.Println(backendError("Failed to set up recovery", err))
+			fmt.Println(ui.Info("Create an emergency kit later in Settings → Recovery Key."))
+		} else {
+			setUpEmergencyKit(ctx, cfg, store, *saved, dataKey)
+		}
+	}
 	ui.PromptContinue()
 }
 
-func runMainLoop() {
-	sess := session.GetSession()
+// setUpEmergencyKit gives the vault a new recovery key and hands it to the
+// user in an emergency kit. The key is only saved with the vault once the
+// user has it, so giving up leaves any earlier key working.
+func setUpEmergencyKit(ctx context.Context, cfg *config.Config, store database.VaultStore, vaultConfig models.VaultConfig, dataKey *crypto.CryptoService) (models.VaultConfig, bool) {
+	next, recoveryKey, err := vault.NewRecoveryKey(vaultConfig, dataKey)
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to generate recovery key: %v", err)))
+		return vaultConfig, false
+	}
+	defer recoveryKey.Destroy()
+
+	if !saveEmergencyKit(cfg, next, recoveryKey) {
+		fmt.Println(ui.Info("Recovery key not changed."))
+		return vaultConfig, false
+	}
+	if !enableRecovery(ctx, store, next) {
+		return vaultConfig, false
+	}
+	fmt.Println(ui.Success("Recovery key set up"))
+	return next, true
+}
+
+// saveEmergencyKit shows the recovery key and writes the emergency kit
+// where the user asks. It reports whether the user has the key, in the
+// kit or written down.
+func saveEmergencyKit(cfg *config.Config, vaultConfig models.VaultConfig, recoveryKey *crypto.SecureBuffer) bool {
+	kit := ui.NewEmergencyKit(cfg, vaultConfig, recoveryKey.Bytes())
+
+	fmt.Println(ui.Info("Your recovery key:"))
+	fmt.Println("  " + ui.Bold + kit.RecoveryKey + ui.Reset)
+	fmt.Println(ui.Subtle("  It unlocks the vault without the master password or key file."))
+
+	home, _ := os.UserHomeDir()
+	for {
+		path, err := ui.InputPrompt("Save emergency kit to (.html or .txt)", filepath.Join(home, "passmanager-emergency-kit.html"), validateRequired)
+		if err != nil {
+			return ui.ConfirmPrompt("Emergency kit not saved. Have you written the recovery key down?")
+		}
+		if err := ui.SaveEmergencyKit(path, kit); err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Failed to save emergency kit: %v", err)))
+			continue
+		}
+		fmt.Println(ui.Success("Emergency kit saved to " + path))
+		fmt.Println(ui.Warning("Print it and keep it somewhere safe, then delete the file from this device."))
+		return true
+	}
+}
+
+// enableRecovery saves next, whose recovery key has just been handed out.
+func enableRecovery(ctx context.Context, store database.VaultStore, next models.VaultConfig) bool {
+	if err := vault.EnableRecovery(ctx, store, next); err != nil {
+		fmt.Println(backendError("Failed to save the new recovery key", err))
+		fmt.Println(ui.Warning("The kit or shares just created do not work; any earlier ones still do."))
+		return false
+	}
+	return true
+}
+
+// chooseKeyFile asks for the key file to use, offering to create one. It
+// returns an absolute path, or "" if the user gives up.
+func chooseKeyFile() string {
+	fmt.Println(ui.Subtle("  Any file works, but it must never change. Leave empty to cancel."))
+	for {
+		path, err := ui.InputPrompt("Key file path", "", nil)
+		path = strings.TrimSpace(path)
+		if err != nil || path == "" {
+			fmt.Println(ui.Info("No key file set"))
+			return ""
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+
+		if info, err := os.Stat(path); err == nil {
+			if info.IsDir() || info.Size() == 0 {
+				fmt.Println(ui.Error("A key file must be a file with some content"))
+				continue
+			}
+			return path
+		}
+
+		if !ui.ConfirmPrompt("No file there. Create a new random key file?") {
+			continue
+		}
+		if err := crypto.GenerateKeyFile(path); err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			continue
+		}
+		fmt.Println(ui.Success("Key file created: " + path))
+		return path
+	}
+}
+
+// unlockSecret combines password with the key file if the vault needs one.
+// The configured key file is tried first; if it is missing the user can
+// point to it elsewhere. It returns the key file used, and the caller must
+// Destroy the secret.
+func unlockSecret(cfg *config.Config, password *crypto.SecureBuffer, needsKeyFile bool) (*crypto.SecureBuffer, string, error) {
+	if !needsKeyFile {
+		secret, err := vault.WithKeyFile(password.Bytes(), "")
+		return secret, "", err
+	}
 
+	keyFile := cfg.KeyFile
+	if keyFile == "" {
+		fmt.Println(ui.Warning("This vault requires a key file"))
+	}
 	for {
+		if keyFile != "" {
+			secret, err := vault.WithKeyFile(password.Bytes(), keyFile)
+			if err == nil {
+				return secret, keyFile, nil
+			}
+			if errors.Is(err, crypto.ErrKeyFileMissing) {
+				fmt.Println(ui.Error("Key file not found at " + keyFile))
+				fmt.Println(ui.Subtle("  This vault cannot be unlocked without it. Is the drive it is on connected?"))
+			} else {
+				fmt.Println(ui.Error(err.Error()))
+			}
+		}
+
+		input, err := ui.InputPrompt("Key file path (empty to cancel)", "", nil)
+		if keyFile = strings.TrimSpace(input); err != nil || keyFile == "" {
+			return nil, "", vault.ErrKeyFileRequired
+		}
+	}
+}
+
+// invalidSecretMessage is shown when a password (and key file) is wrong.
+func invalidSecretMessage(needsKeyFile bool) string {
+	if needsKeyFile {
+		return "Invalid master password or key file"
+	}
+	return "Invalid master password"
+}
+
+func setupPocketBase(ctx context.Context) (*database.PocketBaseClient, string, string) {
+	// Get PocketBase URL
+	pbURL, err := ui.InputPrompt("PocketBase URL", "http://127.0.0.1:8090", validateURL)
+	if err != nil {
+		fmt.Println(ui.Error("Setup cancelled"))
+		os.Exit(1)
+	}
+
+	// Test connection
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Testing connection..."
+	s.Start()
+
+	client := database.NewPocketBaseClient(pbURL)
+	if err := client.TestConnection(ctx); err != nil {
+		s.Stop()
+		fmt.Println(backendError("Cannot connect to PocketBase", err))
+		fmt.Println(ui.Info("Make sure PocketBase is running: ./pocketbase serve"))
+		os.Exit(1)
+	}
+	s.Stop()
+	fmt.Println(ui.Success("Connected to PocketBase"))
+
+	// Get admin credentials
+	adminEmail, _ := ui.InputPrompt("Admin Email", "", validateEmail)
+	adminPass, _ := ui.PasswordPrompt("Admin Password")
+
+	s.Suffix = " Authenticating..."
+	s.Start()
+
+	if err := client.Authenticate(ctx, adminEmail, adminPass); err != nil {
+		s.Stop()
+		fmt.Println(backendError("Authentication failed", err))
+		os.Exit(1)
+	}
+	s.Stop()
+	fmt.Println(ui.Success("Authenticated successfully"))
+
+	// Create or upgrade the collections the vault needs
+	s.Suffix = " Preparing collections..."
+	s.Start()
+
+	changes, err := client.EnsureSchema(ctx)
+	if err != nil {
+		s.Stop()
+		fmt.Println(backendError("Failed to prepare collections", err))
+		fmt.Println(ui.Info("Sign in as a superuser, or create the collections by hand (see README)"))
+		os.Exit(1)
+	}
+	s.Stop()
+	fmt.Println(ui.Success("Collections ready"))
+	for _, change := range changes {
+		fmt.Println(ui.Subtle("  " + change))
+	}
+
+	return client, pbURL, adminEmail
+}
+
+func runMainLoop(ctx context.Context) {
+	sess := session.GetSession()
+
+	for ctx.Err() == nil {
 		ui.ClearScreen()
 		ui.PrintBanner()
 
 		// Check session
 		if !sess.IsAuthenticated() {
-			if !authenticate() {
+			if !authenticate(ctx) {
 				continue
 			}
 		}
@@ -189,25 +458,29 @@ func runMainLoop() {
 
 		switch choice {
 		case "Add Credential":
-			handleAddCredential()
+			handleAddCredential(ctx)
 		case "List Credentials":
-			handleListCredentials()
+			handleListCredentials(ctx)
 		case "Search Credentials":
-			handleSearchCredentials()
+			handleSearchCredentials(ctx)
 		case "Get Credential":
-			handleGetCredential()
+			handleGetCredential(ctx)
 		case "Generate Password":
 			handleGeneratePassword()
+		case "Password Health":
+			handlePasswordHealth(ctx)
 		case "Delete Credential":
-			handleDeleteCredential()
+			handleDeleteCredential(ctx)
 		case "Change Master Password":
-			handleChangeMasterPassword()
+			handleChangeMasterPassword(ctx)
+		case "Rotate Vault Key":
+			handleRotateKey(ctx)
 		case "Lock Vault":
 			sess.Logout()
 			fmt.Println(ui.Success("Vault locked"))
 			ui.PromptContinue()
 		case "Settings":
-			handleSettings()
+			handleSettings(ctx)
 		case "Help":
 			handleHelp()
 		case "Exit":
@@ -216,7 +489,7 @@ func runMainLoop() {
 	}
 }
 
-func authenticate() bool {
+func authenticate(ctx context.Context) bool {
 	ui.PrintSection("Unlock Vault")
 
 	cfg, err := config.Load()
@@ -225,28 +498,101 @@ func authenticate() bool {
 		return false
 	}
 
-	// Get admin password
-	adminPass, err := ui.PasswordPrompt("Admin Password")
-	if err != nil {
-		return false
-	}
+	// secret is the master password, followed by the key file's hash if the
+	// vault needs one
+	var (
+		store        database.VaultStore
+		secret       *crypto.SecureBuffer
+		keyFile      string
+		needsKeyFile bool
+		recoverable  bool
+		passKey      *crypto.DerivedKey // derived from secret by the local store
+	)
+	defer func() { secret.Destroy() }()
+	defer func() { passKey.Destroy() }()
 
 	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Suffix = " Connecting..."
-	s.Start()
 
-	client := database.NewPocketBaseClient(cfg.PocketBaseURL)
-	if err := client.Authenticate(cfg.AdminEmail, adminPass); err != nil {
-		s.Stop()
-		fmt.Println(ui.Error("Authentication failed"))
-		ui.PromptContinue()
-		return false
+	switch cfg.Backend {
+	case config.BackendLocal:
+		if needsKeyFile, err = database.LocalFileNeedsKeyFile(config.GetVaultPath()); err != nil {
+			fmt.Println(backendError("Failed to open vault", err))
+			ui.PromptContinue()
+			return false
+		}
+		recoverable, _ = database.LocalFileRecoverable(config.GetVaultPath())
+
+		// The vault file itself is encrypted with the master password
+		if secret, keyFile, err = promptUnlockSecret(cfg, needsKeyFile); err != nil {
+			if offerRecovery(recoverable && errors.Is(err, vault.ErrKeyFileRequired)) {
+				return recoverVault(ctx, cfg, nil)
+			}
+			return false
+		}
+
+		s.Suffix = " Opening vault..."
+		s.Start()
+
+		localStore, key, err := database.OpenLocalFileStore(config.GetVaultPath(), secret.Bytes())
+		if err != nil {
+			s.Stop()
+			if errors.Is(err, database.ErrInvalidPassphrase) {
+				fmt.Println(ui.Error(invalidSecretMessage(needsKeyFile)))
+				if offerRecovery(recoverable) {
+					return recoverVault(ctx, cfg, nil)
+				}
+			} else {
+				fmt.Println(backendError("Failed to open vault", err))
+			}
+			ui.PromptContinue()
+			return false
+		}
+		store, passKey = localStore, key
+	case config.BackendSQLite:
+		s.Suffix = " Opening database..."
+		s.Start()
+
+		sqliteStore, err := database.OpenSQLiteStore(config.GetSQLitePath())
+		if err != nil {
+			s.Stop()
+			fmt.Println(backendError("Failed to open database", err))
+			ui.PromptContinue()
+			return false
+		}
+		store = sqliteStore
+	default:
+		// Get admin password
+		adminPass, err := ui.PasswordPrompt("Admin Password")
+		if err != nil {
+			return false
+		}
+
+		s.Suffix = " Connecting..."
+		s.Start()
+
+		client := database.NewPocketBaseClient(cfg.PocketBaseURL)
+		policy := database.DefaultRetryPolicy()
+		policy.MaxRetries = cfg.Settings.MaxRetries
+		client.SetRetryPolicy(policy)
+
+		if err := client.Authenticate(ctx, cfg.AdminEmail, adminPass); err != nil {
+			s.Stop()
+			fmt.Println(backendError("Authentication failed", err))
+			ui.PromptContinue()
+			return false
+		}
+
+		// Best effort: brings older deployments up to date with new fields,
+		// but only works for superusers
+		client.EnsureSchema(ctx)
+
+		store = client
 	}
 
-	vaultConfig, err := client.GetVaultConfig()
+	vaultConfig, err := store.GetVaultConfig(ctx)
 	if err != nil {
 		s.Stop()
-		fmt.Println(ui.Error("Failed to load vault configuration"))
+		fmt.Println(backendError("Failed to load vault configuration", err))
 		ui.PromptContinue()
 		return false
 	}
@@ -254,97 +600,314 @@ func authenticate() bool {
 	s.Stop()
 
 	// Get master password
-	masterPass, err := ui.PasswordPrompt("Master Password")
+	if secret == nil {
+		needsKeyFile = vaultConfig.KeyFile
+		recoverable = vaultConfig.RecoveryPublicKey != ""
+		if secret, keyFile, err = promptUnlockSecret(cfg, needsKeyFile); err != nil {
+			if offerRecovery(recoverable && errors.Is(err, vault.ErrKeyFileRequired)) {
+				return recoverVault(ctx, cfg, store)
+			}
+			return false
+		}
+	}
+
+	cryptoSvc, err := vault.UnlockDerived(secret.Bytes(), passKey, *vaultConfig)
 	if err != nil {
+		if errors.Is(err, vault.ErrInvalidPassword) {
+			fmt.Println(ui.Error(invalidSecretMessage(needsKeyFile)))
+			if offerRecovery(recoverable) {
+				return recoverVault(ctx, cfg, store)
+			}
+		} else {
+			fmt.Println(ui.Error(err.Error()))
+		}
+		ui.PromptContinue()
 		return false
 	}
 
-	salt, _ := base64.StdEncoding.DecodeString(vaultConfig.Salt)
-	passwordHash := crypto.HashMasterPassword(masterPass, salt)
-
-	if passwordHash != vaultConfig.PasswordHash {
-		fmt.Println(ui.Error("Invalid master password"))
+	if !pinCipherVersion(vaultConfig, cryptoSvc) {
+		cryptoSvc.SecureClear()
 		ui.PromptContinue()
 		return false
 	}
 
-	cryptoSvc := crypto.NewCryptoService(masterPass, salt)
-
+	salt, _ := base64.StdEncoding.DecodeString(vaultConfig.Salt)
 	sess := session.GetSession()
-	sess.Login(client, cryptoSvc, salt)
+	sess.Login(store, cryptoSvc, salt)
+	sess.SetVaultConfig(*vaultConfig)
 	sess.SetTimeout(time.Duration(cfg.Settings.SessionTimeout) * time.Minute)
 
 	fmt.Println(ui.Success("Vault unlocked!"))
+	if keyFile != "" && keyFile != cfg.KeyFile && ui.ConfirmPrompt("Remember this key file location?") {
+		cfg.KeyFile = keyFile
+		cfg.Save()
+	}
+	if !resumePendingRekey(ctx, *vaultConfig) {
+		upgradeVault(ctx, *vaultConfig, secret)
+	}
 	time.Sleep(500 * time.Millisecond)
 
 	return true
 }
 
-func handleAddCredential() {
-	ui.ClearScreen()
-	ui.PrintSection("Add New Credential")
-
-	sess := session.GetSession()
-	cfg, _ := config.Load()
-
-	// Get credential details
-	title, _ := ui.InputPrompt("Title", "", validateRequired)
-	username, _ := ui.InputPrompt("Username/Email", "", nil)
-	urlInput, _ := ui.InputPrompt("URL", "", nil)
-	category, _ := ui.InputPrompt("Category", cfg.Settings.DefaultCategory, nil)
-	notes, _ := ui.InputPrompt("Notes (optional)", "", nil)
-
-	// Password options
-	passOptions := []string{
-		"🎲 Generate secure password",
-		"✏️  Enter password manually",
+// pinCipherVersion applies vault.PinCipherVersion and reports a lowered
+// version. It returns false if the vault should not be opened.
+func pinCipherVersion(vaultConfig *models.VaultConfig, cryptoSvc *crypto.CryptoService) bool {
+	lowered, err := vault.PinCipherVersion(vaultConfig, cryptoSvc)
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to check the vault's cipher version: %v", err)))
+		return false
 	}
-	_, passChoice, _ := ui.SelectFromList("Password", passOptions)
+	if lowered {
+		fmt.Println(ui.Warning("The vault configuration on the server claims an older encryption format than this device has seen"))
+		fmt.Println(ui.Subtle("  Older records are refused; someone with write access to the server may have tampered with it."))
+	}
+	return true
+}
 
-	var password string
-	if strings.Contains(passChoice, "Generate") {
-		length := cfg.Settings.PasswordLength
-		lengthStr, _ := ui.InputPrompt("Password length", strconv.Itoa(length), validateNumber)
-		length, _ = strconv.Atoi(lengthStr)
+// promptUnlockSecret asks for the master password and combines it with the
+// key file if the vault needs one. Errors have been shown already.
+func promptUnlockSecret(cfg *config.Config, needsKeyFile bool) (*crypto.SecureBuffer, string, error) {
+	password, err := ui.SecretPrompt("Master Password")
+	if err != nil {
+		return nil, "", err
+	}
+	defer password.Destroy()
 
-		password, _ = crypto.GeneratePassword(length, cfg.Settings.IncludeSymbols)
-		fmt.Printf("\n%s Generated: %s%s%s\n", ui.Subtle("🔑"), ui.Green+ui.Bold, password, ui.Reset)
-	} else {
-		password, _ = ui.PasswordPrompt("Password")
+	secret, keyFile, err := unlockSecret(cfg, password, needsKeyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		ui.PromptContinue()
+		return nil, "", err
 	}
+	return secret, keyFile, nil
+}
 
-	// Encrypt and save
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Suffix = " Encrypting and saving..."
-	s.Start()
+// offerRecovery asks whether to recover the vault after a failed unlock.
+// recoverable says whether the vault has recovery shares at all.
+func offerRecovery(recoverable bool) bool {
+	return recoverable && ui.ConfirmPrompt("Lost your master password or key file? Recover with your recovery key or shares")
+}
 
-	cryptoSvc := sess.GetCrypto()
-	encryptedPassword, _ := cryptoSvc.Encrypt(password)
+// recoverVault unlocks the vault with its recovery key or shares and sets a
+// new master password. store is nil for the local vault file, which the
+// recovery key opens itself.
+func recoverVault(ctx context.Context, cfg *config.Config, store database.VaultStore) bool {
+	ui.PrintSection("Recover Vault")
 
-	encryptedNotes := ""
-	if notes != "" {
-		encryptedNotes, _ = cryptoSvc.Encrypt(notes)
+	// Finishing the rotation later would bring the old password back
+	if journal, _ := vault.LoadJournal(); journal != nil {
+		fmt.Println(ui.Error(vault.ErrRekeyInProgress.Error()))
+		fmt.Println(ui.Info("This needs the master password; recovery is not possible until then."))
+		ui.PromptContinue()
+		return false
 	}
 
-	cred := models.Credential{
-		Title:             title,
-		Username:          username,
-		EncryptedPassword: encryptedPassword,
-		URL:               urlInput,
-		Notes:             encryptedNotes,
-		Category:          category,
+	fmt.Println(ui.Info("Enter your recovery key, or your recovery shares one at a time. Leave empty to cancel."))
+	recoveryKey, err := promptRecoveryKey()
+	if err != nil {
+		if err != errCancelled {
+			fmt.Println(ui.Error(err.Error()))
+		}
+		ui.PromptContinue()
+		return false
+	}
+	defer recoveryKey.Destroy()
+
+	if store == nil {
+		localStore, err := database.OpenLocalFileStoreRecovery(config.GetVaultPath(), recoveryKey.Bytes())
+		if err != nil {
+			fmt.Println(recoveryError(err))
+			ui.PromptContinue()
+			return false
+		}
+		store = localStore
+	}
+
+	vaultConfig, err := store.GetVaultConfig(ctx)
+	if err != nil {
+		fmt.Println(backendError("Failed to load vault configuration", err))
+		ui.PromptContinue()
+		return false
 	}
 
-	created, err := sess.GetDB().CreateCredential(cred)
+	dataKey, err := vault.UnlockWithRecovery(recoveryKey.Bytes(), *vaultConfig)
+	if err != nil {
+		fmt.Println(recoveryError(err))
+		ui.PromptContinue()
+		return false
+	}
+
+	if !pinCipherVersion(vaultConfig, dataKey) {
+		dataKey.SecureClear()
+		ui.PromptContinue()
+		return false
+	}
+
+	fmt.Println(ui.Success("Recovery key accepted"))
+	fmt.Println()
+	newPass := promptNewMasterPassword("New Master Password (min 12 chars)", "Confirm New Password", cfg.AdminEmail, cfg.PocketBaseURL)
+	defer newPass.Destroy()
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Setting new master password..."
+	s.Start()
+	updated, err := vault.Recover(ctx, store, *vaultConfig, dataKey, newPass.Bytes())
 	s.Stop()
+	if err != nil {
+		dataKey.SecureClear()
+		fmt.Println(backendError("Failed to set new master password", err))
+		ui.PromptContinue()
+		return false
+	}
+
+	cfg.KeyFile = ""
+	cfg.Save()
+
+	salt, _ := base64.StdEncoding.DecodeString(updated.Salt)
+	sess := session.GetSession()
+	sess.Login(store, dataKey, salt)
+	sess.SetVaultConfig(updated)
+	sess.SetTimeout(time.Duration(cfg.Settings.SessionTimeout) * time.Minute)
+
+	fmt.Println(ui.Success("Vault recovered and master password changed!"))
+	if vaultConfig.KeyFile {
+		fmt.Println(ui.Info("A key file is no longer required; add one again in Settings if you like."))
+	}
+	fmt.Println(ui.Subtle("  Your recovery key and shares still work. Create new ones in Settings if any were exposed."))
+	ui.PromptContinue()
+	return true
+}
+
+var errCancelled = errors.New("cancelled")
+
+// promptRecoveryKey reads the whole recovery key, or recovery shares until
+// there are enough to rebuild it. The caller must Destroy the result.
+func promptRecoveryKey() (*crypto.SecureBuffer, error) {
+	var shares []vault.Share
+	for {
+		label := "Recovery key or share 1"
+		if len(shares) > 0 {
+			label = fmt.Sprintf("Share %d of %d", len(shares)+1, shares[0].Threshold)
+		}
+		text, err := ui.SecretPrompt(label)
+		if err != nil {
+			return nil, errCancelled
+		}
+		if len(bytes.TrimSpace(text.Bytes())) == 0 {
+			text.Destroy()
+			return nil, errCancelled
+		}
+
+		if len(shares) == 0 && vault.IsRecoveryKey(text.Bytes()) {
+			recoveryKey, err := vault.ParseRecoveryKey(text.Bytes())
+			text.Destroy()
+			if err != nil {
+				fmt.Println(ui.Error(err.Error()))
+				continue
+			}
+			return recoveryKey, nil
+		}
+
+		share, err := vault.ParseShare(text.Bytes())
+		text.Destroy()
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			continue
+		}
+		if len(shares) > 0 && share.Set != shares[0].Set {
+			fmt.Println(ui.Error("This share belongs to a different set"))
+			continue
+		}
+		if slices.ContainsFunc(shares, func(s vault.Share) bool { return s.Index() == share.Index() }) {
+			fmt.Println(ui.Warning(fmt.Sprintf("Share %d was already entered", share.Index())))
+			continue
+		}
+
+		shares = append(shares, share)
+		if len(shares) >= share.Threshold {
+			return vault.CombineShares(shares)
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("Share %d accepted, %d more needed", share.Index(), share.Threshold-len(shares))))
+	}
+}
+
+func recoveryError(err error) string {
+	if errors.Is(err, crypto.ErrInvalidRecoveryKey) || errors.Is(err, vault.ErrRecoveryKeyMismatch) {
+		return ui.Error("This does not match the vault's current recovery key")
+	}
+	return backendError("Recovery failed", err)
+}
+
+// promptServerLogin asks for the admin password again when the PocketBase
+// login expires while the vault is unlocked.
+func promptServerLogin(ctx context.Context, identity string) (string, error) {
+	fmt.Println()
+	fmt.Println(ui.Warning("Server session expired, please sign in again as " + identity))
+	return ui.PasswordPrompt("Admin Password")
+}
+
+func handleAddCredential(ctx context.Context) {
+	ui.ClearScreen()
+	ui.PrintSection("Add New Credential")
+
+	sess := session.GetSession()
+	cfg, _ := config.Load()
+
+	// Get credential details
+	title, _ := ui.InputPrompt("Title", "", validateRequired)
+	username, _ := ui.InputPrompt("Username/Email", "", nil)
+	urlInput, _ := ui.InputPrompt("URL", "", nil)
+	category, _ := ui.InputPrompt("Category", cfg.Settings.DefaultCategory, nil)
+	notes, _ := ui.InputPrompt("Notes (optional)", "", nil)
+	totpInput, _ := ui.InputPrompt("TOTP secret or otpauth:// URI (optional)", "", validateTOTP)
+
+	password := choosePassword(cfg, title, username, urlInput)
+	defer password.Destroy()
+	totp := normalizeTOTP(totpInput)
+	defer totp.Destroy()
+
+	// Encrypt and save
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Encrypting and saving..."
+	s.Start()
 
+	// The ID is chosen up front because the encrypted fields are bound to it
+	id, err := database.NewRecordID()
 	if err != nil {
+		s.Stop()
 		fmt.Println(ui.Error(fmt.Sprintf("Failed to save: %v", err)))
+		ui.PromptContinue()
+		return
+	}
+
+	cred, err := encryptFields(sess.GetCrypto(), id, credentialFields{
+		Title:    title,
+		Username: username,
+		URL:      urlInput,
+		Category: category,
+		Password: password,
+		Notes:    notes,
+		TOTP:     totp,
+	})
+	if err != nil {
+		s.Stop()
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to encrypt: %v", err)))
+		ui.PromptContinue()
+		return
+	}
+
+	created, err := sess.GetDB().CreateCredential(ctx, cred)
+	s.Stop()
+
+	if err != nil {
+		fmt.Println(backendError("Failed to save", err))
 	} else {
 		fmt.Println(ui.Success(fmt.Sprintf("Credential saved! ID: %s", created.ID)))
 
 		if ui.ConfirmPrompt("Copy password to clipboard?") {
-			clipboard.WriteAll(password)
+			// The clipboard only takes strings
+			clipboard.WriteAll(password.UnsafeString())
 			fmt.Println(ui.Success("Password copied!"))
 		}
 	}
@@ -352,21 +915,136 @@ func handleAddCredential() {
 	ui.PromptContinue()
 }
 
-func handleListCredentials() {
+// choosePassword asks whether to generate a password or type one in. A typed
+// password is rated, with userInputs such as the title counting against it.
+// The caller must Destroy the result.
+func choosePassword(cfg *config.Config, userInputs ...string) *crypto.SecureBuffer {
+	passOptions := []string{
+		"🎲 Generate secure password",
+		"✏️  Enter password manually",
+	}
+	_, passChoice, _ := ui.SelectFromList("Password", passOptions)
+
+	if strings.Contains(passChoice, "Generate") {
+		policy := cfg.Settings.PasswordPolicy
+		lengthStr, _ := ui.InputPrompt("Password length", strconv.Itoa(policy.Length), validateNumber)
+		policy.Length, _ = strconv.Atoi(lengthStr)
+
+		generated, err := crypto.GeneratePassword(policy)
+		if err != nil {
+			fmt.Println(ui.Error("Failed to generate password: " + err.Error()))
+			return promptPassword()
+		}
+		fmt.Printf("\n%s Generated: %s%s%s\n", ui.Subtle("🔑"), ui.Green+ui.Bold, generated, ui.Reset)
+		password, _ := crypto.SecureString(generated)
+		return password
+	}
+
+	password := promptPassword()
+	printStrength(estimateSecret(password, userInputs...))
+	return password
+}
+
+// promptPassword reads a credential password straight into locked memory.
+// A cancelled prompt gives an empty password.
+func promptPassword() *crypto.SecureBuffer {
+	password, err := ui.SecretPrompt("Password")
+	if err != nil {
+		password, _ = crypto.NewSecureBuffer(0)
+	}
+	return password
+}
+
+// estimateSecret rates a password without leaving a copy of it in the
+// result.
+func estimateSecret(password *crypto.SecureBuffer, userInputs ...string) strength.Result {
+	var result strength.Result
+	password.Use(func(secret []byte) error {
+		result = strength.EstimateSecret(secret, userInputs...)
+		return nil
+	})
+	return result
+}
+
+// printStrength shows how hard a password is to guess and what makes it
+// weak.
+func printStrength(result strength.Result) {
+	color := ui.Green
+	switch {
+	case result.Score < strength.ScoreFair:
+		color = ui.Red
+	case result.Score < strength.ScoreStrong:
+		color = ui.Yellow
+	}
+
+	bar := strings.Repeat("█", result.Score+1) + strings.Repeat("░", strength.ScoreVeryStrong-result.Score)
+	fmt.Printf("  Strength: %s%s %s%s %s\n", color, bar, result.Label(), ui.Reset,
+		ui.Subtle("(offline attack: "+result.CrackTime()+")"))
+
+	if result.Warning != "" {
+		fmt.Println(ui.Warning(result.Warning))
+	}
+	for _, suggestion := range result.Suggestions {
+		fmt.Println(ui.Subtle("  • " + suggestion))
+	}
+}
+
+// acceptMasterPassword rates a new master password and asks for
+// confirmation before a weak one is used.
+func acceptMasterPassword(password *crypto.SecureBuffer, userInputs ...string) bool {
+	result := estimateSecret(password, userInputs...)
+	printStrength(result)
+
+	if result.Score >= strength.ScoreStrong {
+		return true
+	}
+	return ui.ConfirmPrompt("This master password is easy to guess. Use it anyway?")
+}
+
+// promptNewMasterPassword asks for a new master password until one is long
+// enough, accepted and confirmed. The caller must Destroy the result.
+func promptNewMasterPassword(label, confirmLabel string, userInputs ...string) *crypto.SecureBuffer {
+	for {
+		password, err := ui.SecretPrompt(label)
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			continue
+		}
+		if password.Len() < 12 {
+			fmt.Println(ui.Error("Password must be at least 12 characters"))
+			password.Destroy()
+			continue
+		}
+		if !acceptMasterPassword(password, userInputs...) {
+			password.Destroy()
+			continue
+		}
+
+		confirm, err := ui.SecretPrompt(confirmLabel)
+		matches := err == nil && password.Equal(confirm)
+		confirm.Destroy()
+		if !matches {
+			fmt.Println(ui.Error("Passwords don't match"))
+			password.Destroy()
+			continue
+		}
+		return password
+	}
+}
+
+func handleListCredentials(ctx context.Context) {
 	ui.ClearScreen()
 	ui.PrintSection("All Credentials")
 
-	sess := session.GetSession()
-
 	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
 	s.Suffix = " Loading..."
 	s.Start()
 
-	creds, err := sess.GetDB().ListCredentials("")
+	creds, err := loadAllCredentials(ctx, s, "Loading")
 	s.Stop()
 
 	if err != nil {
-		fmt.Println(ui.Error(fmt.Sprintf("Failed to load credentials: %v", err)))
+		fmt.Println(backendError("Failed to load credentials", err))
 		ui.PromptContinue()
 		return
 	}
@@ -384,7 +1062,7 @@ func handleListCredentials() {
 	ui.PromptContinue()
 }
 
-func handleSearchCredentials() {
+func handleSearchCredentials(ctx context.Context) {
 	ui.ClearScreen()
 	ui.PrintSection("Search Credentials")
 
@@ -396,14 +1074,15 @@ func handleSearchCredentials() {
 	s.Suffix = " Searching..."
 	s.Start()
 
-	creds, err := sess.GetDB().ListCredentials(query)
+	creds, failures, err := vault.Find(ctx, sess.GetDB(), sess.GetCrypto(), sess.GetVaultConfig(), vault.Query{Term: query})
 	s.Stop()
 
 	if err != nil {
-		fmt.Println(ui.Error(fmt.Sprintf("Search failed: %v", err)))
+		fmt.Println(backendError("Search failed", err))
 		ui.PromptContinue()
 		return
 	}
+	printFindFailures(failures)
 
 	if len(creds) == 0 {
 		fmt.Println(ui.Info(fmt.Sprintf("No credentials found matching '%s'", query)))
@@ -418,295 +1097,1625 @@ func handleSearchCredentials() {
 	// Option to view one
 	if ui.ConfirmPrompt("View credential details?") {
 		id, _ := ui.InputPrompt("Enter ID", "", validateRequired)
-		viewCredential(id)
+		viewCredential(ctx, id)
 	}
 
 	ui.PromptContinue()
 }
 
-func handleGetCredential() {
+func handleGetCredential(ctx context.Context) {
 	ui.ClearScreen()
 	ui.PrintSection("Get Credential")
 
 	id, _ := ui.InputPrompt("Credential ID", "", validateRequired)
-	viewCredential(id)
+	viewCredential(ctx, id)
 	ui.PromptContinue()
 }
 
-func viewCredential(id string) {
+func viewCredential(ctx context.Context, id string) {
+	sess := session.GetSession()
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Loading..."
+	s.Start()
+
+	cred, err := sess.GetDB().GetCredential(ctx, id)
+	s.Stop()
+
+	if err != nil {
+		fmt.Println(backendError("Failed to load credential", err))
+		return
+	}
+
+	// The password and TOTP secret stay in locked memory, and are wiped
+	// when leaving this view or when the vault locks
+	cryptoSvc := sess.GetCrypto()
+	minVersion := sess.GetVaultConfig().CipherVersion
+	fields, err := decryptDetails(cryptoSvc, *cred)
+
+	password, passErr := vault.OpenSecret(cryptoSvc, cred.ID, vault.FieldPassword, cred.EncryptedPassword, minVersion)
+	if err == nil {
+		err = passErr
+	}
+	defer sess.Track(password).Destroy()
+
+	var totp *crypto.SecureBuffer
+	if cred.TOTP != "" {
+		var totpErr error
+		totp, totpErr = vault.OpenSecret(cryptoSvc, cred.ID, vault.FieldTOTP, cred.TOTP, minVersion)
+		if err == nil {
+			err = totpErr
+		}
+		defer sess.Track(totp).Destroy()
+	}
+	notes := fields.Notes
+
+	ui.PrintCredentialCard(cred.ID, fields.Title, fields.Username, fields.URL, fields.Category, false, "")
+	if err != nil {
+		fmt.Println(ui.Warning(fmt.Sprintf("Could not decrypt this credential: %v", err)))
+	}
+
+	if notes != "" {
+		fmt.Printf("\n  %sNotes:%s %s\n", ui.Dim, ui.Reset, notes)
+	}
+	if totp != nil {
+		printTOTPCode(totp)
+	}
+
+	// Actions menu
+	actions := []string{
+		"👁️  Show password",
+		"📋 Copy password to clipboard",
+		"📋 Copy username to clipboard",
+	}
+	if totp != nil {
+		actions = append(actions,
+			"🔢 Show TOTP code",
+			"📋 Copy TOTP code to clipboard",
+		)
+	}
+	actions = append(actions,
+		"✏️  Edit credential",
+		"🔙 Go back",
+	)
+
+	for {
+		_, action, _ := ui.SelectFromList("Action", actions)
+
+		switch {
+		case strings.Contains(action, "Show password"):
+			fmt.Printf("\n  %sPassword:%s %s", ui.Dim, ui.Reset, ui.Green)
+			// The session may destroy it on auto-lock, so it is read
+			// through Use
+			password.Use(func(secret []byte) error {
+				_, err := os.Stdout.Write(secret)
+				return err
+			})
+			fmt.Printf("%s\n", ui.Reset)
+		case strings.Contains(action, "Copy password"):
+			// The clipboard only takes strings
+			clipboard.WriteAll(password.UnsafeString())
+			fmt.Println(ui.Success("Password copied to clipboard!"))
+		case strings.Contains(action, "Copy username"):
+			clipboard.WriteAll(fields.Username)
+			fmt.Println(ui.Success("Username copied to clipboard!"))
+		case strings.Contains(action, "Show TOTP"):
+			printTOTPCode(totp)
+		case strings.Contains(action, "Copy TOTP"):
+			code, remaining, err := totpCode(totp)
+			if err != nil {
+				fmt.Println(ui.Error(err.Error()))
+				continue
+			}
+			clipboard.WriteAll(code)
+			fmt.Println(ui.Success(fmt.Sprintf("TOTP code copied to clipboard! Valid for %ds", int(remaining.Seconds()))))
+		case strings.Contains(action, "Edit credential"):
+			editCredential(ctx, *cred)
+			return
+		case strings.Contains(action, "Go back"):
+			return
+		}
+	}
+}
+
+// credentialFields is a credential with its secrets decrypted, for editing
+// and for comparing two versions. The password and TOTP URI stay in locked
+// memory; release them with destroy.
+type credentialFields struct {
+	Title    string
+	Username string
+	URL      string
+	Category string
+	Password *crypto.SecureBuffer
+	// Notes are shown and edited as text, so they are kept as a string
+	Notes string
+	TOTP  *crypto.SecureBuffer // nil when there is none
+}
+
+func (f credentialFields) destroy() {
+	f.Password.Destroy()
+	f.TOTP.Destroy()
+}
+
+// editableField is one field of credentialFields; secret is set for the
+// fields held in locked memory, text for the rest.
+type editableField struct {
+	label  string
+	text   func(f *credentialFields) *string
+	secret func(f *credentialFields) **crypto.SecureBuffer
+}
+
+// same reports whether a and b agree on the field.
+func (field editableField) same(a, b *credentialFields) bool {
+	if field.secret == nil {
+		return *field.text(a) == *field.text(b)
+	}
+	x, y := *field.secret(a), *field.secret(b)
+	if x == nil || y == nil {
+		return x == y
+	}
+	return x.Equal(y)
+}
+
+// editableFields lists the fields in display order. Secret values are
+// masked when versions are compared.
+var editableFields = []editableField{
+	{"Title", func(f *credentialFields) *string { return &f.Title }, nil},
+	{"Username", func(f *credentialFields) *string { return &f.Username }, nil},
+	{"URL", func(f *credentialFields) *string { return &f.URL }, nil},
+	{"Category", func(f *credentialFields) *string { return &f.Category }, nil},
+	{"Password", nil, func(f *credentialFields) **crypto.SecureBuffer { return &f.Password }},
+	{"Notes", func(f *credentialFields) *string { return &f.Notes }, nil},
+	{"TOTP", nil, func(f *credentialFields) **crypto.SecureBuffer { return &f.TOTP }},
+}
+
+func sameFields(a, b credentialFields) bool {
+	for _, field := range editableFields {
+		if !field.same(&a, &b) {
+			return false
+		}
+	}
+	return true
+}
+
+// decryptFields decrypts what it can of cred; the error is for any secret
+// that could not be read, which is left nil. The caller must destroy the
+// result.
+func decryptFields(cryptoSvc *crypto.CryptoService, cred models.Credential) (credentialFields, error) {
+	minVersion := session.GetSession().GetVaultConfig().CipherVersion
+	fields, err := decryptDetails(cryptoSvc, cred)
+
+	password, passErr := vault.OpenSecret(cryptoSvc, cred.ID, vault.FieldPassword, cred.EncryptedPassword, minVersion)
+	if passErr == nil {
+		fields.Password = password
+	} else if err == nil {
+		err = passErr
+	}
+	if cred.TOTP != "" {
+		totp, totpErr := vault.OpenSecret(cryptoSvc, cred.ID, vault.FieldTOTP, cred.TOTP, minVersion)
+		if totpErr == nil {
+			fields.TOTP = totp
+		} else if err == nil {
+			err = totpErr
+		}
+	}
+	return fields, err
+}
+
+// decryptDetails is decryptFields without the password and TOTP secret.
+func decryptDetails(cryptoSvc *crypto.CryptoService, cred models.Credential) (credentialFields, error) {
+	minVersion := session.GetSession().GetVaultConfig().CipherVersion
+	cred, err := vault.OpenMetadata(cryptoSvc, cred, minVersion)
+	if err != nil {
+		return credentialFields{}, err
+	}
+
+	fields := credentialFields{
+		Title:    cred.Title,
+		Username: cred.Username,
+		URL:      cred.URL,
+		Category: cred.Category,
+	}
+	if cred.Notes != "" {
+		fields.Notes, err = vault.Open(cryptoSvc, cred.ID, vault.FieldNotes, cred.Notes, minVersion)
+	}
+	return fields, err
+}
+
+func encryptFields(cryptoSvc *crypto.CryptoService, id string, fields credentialFields) (models.Credential, error) {
+	encryptedPassword, err := vault.SealSecret(cryptoSvc, id, vault.FieldPassword, fields.Password)
+	if err != nil {
+		return models.Credential{}, err
+	}
+
+	encryptedNotes := ""
+	if fields.Notes != "" {
+		if encryptedNotes, err = vault.Seal(cryptoSvc, id, vault.FieldNotes, fields.Notes); err != nil {
+			return models.Credential{}, err
+		}
+	}
+
+	encryptedTOTP := ""
+	if fields.TOTP != nil {
+		if encryptedTOTP, err = vault.SealSecret(cryptoSvc, id, vault.FieldTOTP, fields.TOTP); err != nil {
+			return models.Credential{}, err
+		}
+	}
+
+	cred := models.Credential{
+		ID:                id,
+		Title:             fields.Title,
+		Username:          fields.Username,
+		EncryptedPassword: encryptedPassword,
+		URL:               fields.URL,
+		Notes:             encryptedNotes,
+		TOTP:              encryptedTOTP,
+		Category:          fields.Category,
+	}
+
+	if session.GetSession().GetVaultConfig().EncryptMetadata {
+		if err := vault.SealMetadata(cryptoSvc, &cred); err != nil {
+			return models.Credential{}, err
+		}
+	}
+	return cred, nil
+}
+
+func editCredential(ctx context.Context, original models.Credential) {
+	sess := session.GetSession()
+	cfg, _ := config.Load()
+	cryptoSvc := sess.GetCrypto()
+
+	fmt.Println(ui.Subtle("  Press Enter to keep the current value"))
+	edited, err := decryptFields(cryptoSvc, original)
+	defer func() { edited.destroy() }()
+	if err != nil {
+		// Saving would replace the unreadable secret with an empty one
+		fmt.Println(ui.Error(fmt.Sprintf("Cannot edit, this credential could not be decrypted: %v", err)))
+		return
+	}
+	edited.Title, _ = ui.InputPrompt("Title", edited.Title, validateRequired)
+	edited.Username, _ = ui.InputPrompt("Username/Email", edited.Username, nil)
+	edited.URL, _ = ui.InputPrompt("URL", edited.URL, nil)
+	edited.Category, _ = ui.InputPrompt("Category", edited.Category, nil)
+	edited.Notes, _ = ui.InputPrompt("Notes", edited.Notes, nil)
+	if ui.ConfirmPrompt("Change password?") {
+		edited.Password.Destroy()
+		edited.Password = choosePassword(cfg, edited.Title, edited.Username, edited.URL)
+	}
+	edited.TOTP = editTOTP(edited.TOTP)
+
+	// base is the stored version the edit applies to; it moves forward
+	// whenever a conflict is resolved
+	base := original
+	for {
+		cred, err := encryptFields(cryptoSvc, base.ID, edited)
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Failed to encrypt: %v", err)))
+			return
+		}
+		cred.Updated = base.Updated
+
+		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+		s.Suffix = " Saving..."
+		s.Start()
+		_, err = sess.GetDB().UpdateCredential(ctx, base.ID, cred)
+		s.Stop()
+
+		var conflict *database.ConflictError
+		if !errors.As(err, &conflict) {
+			if err != nil {
+				fmt.Println(backendError("Failed to save", err))
+			} else {
+				fmt.Println(ui.Success("Credential updated!"))
+			}
+			return
+		}
+
+		theirs, _ := decryptFields(cryptoSvc, conflict.Current)
+		defer theirs.destroy()
+		if sameFields(theirs, edited) {
+			fmt.Println(ui.Success("Credential already has these changes"))
+			return
+		}
+
+		fmt.Println()
+		fmt.Println(ui.Warning("This credential was changed elsewhere while you were editing it"))
+		printFieldDiff(edited, theirs)
+
+		options := []string{
+			"💾 Keep my version",
+			"📥 Keep the other version",
+			"🔀 Merge field by field",
+			"🔙 Cancel",
+		}
+		_, choice, _ := ui.SelectFromList("Resolve conflict", options)
+
+		switch {
+		case strings.Contains(choice, "Keep my version"):
+			base = conflict.Current
+		case strings.Contains(choice, "Merge"):
+			edited = mergeFields(edited, theirs)
+			base = conflict.Current
+		case strings.Contains(choice, "other version"):
+			fmt.Println(ui.Info("Kept the other version; your changes were discarded"))
+			return
+		default:
+			fmt.Println(ui.Info("Edit cancelled"))
+			return
+		}
+	}
+}
+
+// editTOTP asks whether to add, replace or remove the TOTP secret. current
+// is destroyed if it is replaced or removed.
+func editTOTP(current *crypto.SecureBuffer) *crypto.SecureBuffer {
+	if current == nil {
+		input, _ := ui.InputPrompt("TOTP secret or otpauth:// URI (optional)", "", validateTOTP)
+		return normalizeTOTP(input)
+	}
+
+	options := []string{
+		"✅ Keep current TOTP secret",
+		"✏️  Replace TOTP secret",
+		"🗑️  Remove TOTP secret",
+	}
+	_, choice, _ := ui.SelectFromList("TOTP", options)
+
+	switch {
+	case strings.Contains(choice, "Replace"):
+		input, _ := ui.InputPrompt("TOTP secret or otpauth:// URI", "", func(s string) error {
+			if err := validateRequired(s); err != nil {
+				return err
+			}
+			return validateTOTP(s)
+		})
+		if uri := normalizeTOTP(input); uri != nil {
+			current.Destroy()
+			return uri
+		}
+	case strings.Contains(choice, "Remove"):
+		current.Destroy()
+		return nil
+	}
+	return current
+}
+
+// normalizeTOTP turns a base32 secret or otpauth:// URI into the full URI
+// that is stored. Empty or invalid input gives nil.
+func normalizeTOTP(input string) *crypto.SecureBuffer {
+	if strings.TrimSpace(input) == "" {
+		return nil
+	}
+	totp, err := crypto.ParseTOTP(input)
+	if err != nil {
+		return nil
+	}
+	defer totp.Wipe()
+
+	uri, err := crypto.SecureString(totp.URI())
+	if err != nil {
+		return nil
+	}
+	return uri
+}
+
+func totpCode(uri *crypto.SecureBuffer) (code string, remaining time.Duration, err error) {
+	err = uri.Use(func(secret []byte) error {
+		totp, err := crypto.ParseTOTPBytes(secret)
+		if err != nil {
+			return err
+		}
+		defer totp.Wipe()
+		code, remaining, err = totp.Code(time.Now())
+		return err
+	})
+	return code, remaining, err
+}
+
+func printTOTPCode(uri *crypto.SecureBuffer) {
+	code, remaining, err := totpCode(uri)
+	if err != nil {
+		fmt.Println(ui.Warning(fmt.Sprintf("Could not generate a TOTP code: %v", err)))
+		return
+	}
+	fmt.Printf("\n  %sTOTP:%s %s%s%s %s(valid for %ds)%s\n", ui.Dim, ui.Reset, ui.Green, code, ui.Reset, ui.Dim, int(remaining.Seconds()), ui.Reset)
+}
+
+// printFieldDiff shows the fields where two versions differ.
+func printFieldDiff(mine, theirs credentialFields) {
+	fmt.Println()
+	for _, field := range editableFields {
+		if field.same(&mine, &theirs) {
+			continue
+		}
+		var a, b string
+		if field.secret != nil {
+			a, b = strings.Repeat("•", (*field.secret(&mine)).Len()), strings.Repeat("•", (*field.secret(&theirs)).Len())
+		} else {
+			a, b = *field.text(&mine), *field.text(&theirs)
+		}
+		fmt.Printf("  %s%s%s\n", ui.Bold, field.label, ui.Reset)
+		fmt.Printf("    %sMine:%s   %s\n", ui.Dim, ui.Reset, a)
+		fmt.Printf("    %sTheirs:%s %s\n", ui.Dim, ui.Reset, b)
+	}
+	fmt.Println()
+}
+
+// mergeFields asks, for every field that differs, which version to keep.
+// Secrets taken from theirs are copied, so both can still be destroyed.
+func mergeFields(mine, theirs credentialFields) credentialFields {
+	merged := mine
+	for _, field := range editableFields {
+		if field.same(&mine, &theirs) {
+			continue
+		}
+
+		var shownA, shownB string
+		if field.secret != nil {
+			shownA, shownB = "my "+strings.ToLower(field.label), "their "+strings.ToLower(field.label)
+		} else {
+			shownA, shownB = *field.text(&mine), *field.text(&theirs)
+		}
+		options := []string{"Mine: " + shownA, "Theirs: " + shownB}
+		idx, _, _ := ui.SelectFromList(field.label, options)
+		if idx != 1 {
+			continue
+		}
+
+		if field.secret == nil {
+			*field.text(&merged) = *field.text(&theirs)
+			continue
+		}
+		var theirSecret *crypto.SecureBuffer
+		if b := *field.secret(&theirs); b != nil {
+			var err error
+			if theirSecret, err = b.Clone(); err != nil {
+				fmt.Println(ui.Error(fmt.Sprintf("Kept my %s: %v", strings.ToLower(field.label), err)))
+				continue
+			}
+		}
+		(*field.secret(&merged)).Destroy()
+		*field.secret(&merged) = theirSecret
+	}
+	return merged
+}
+
+func handleGeneratePassword() {
+	ui.ClearScreen()
+	ui.PrintSection("Generate Password")
+
+	cfg, _ := config.Load()
+
+	kinds := []string{
+		"🎲 Random characters",
+		"📖 Passphrase (random words, easier to remember)",
+	}
+	kind, _, err := ui.SelectFromList("Type", kinds)
+	if err != nil {
+		return
+	}
+
+	var password string
+	if kind == 1 {
+		password, err = generatePassphrase()
+	} else {
+		policy := cfg.Settings.PasswordPolicy
+		lengthStr, _ := ui.InputPrompt("Password length", strconv.Itoa(policy.Length), validateNumber)
+		policy.Length, _ = strconv.Atoi(lengthStr)
+
+		fmt.Println(ui.Subtle("  " + policy.String()))
+		if ui.ConfirmPrompt("Change character rules?") {
+			policy = editPasswordPolicy(policy)
+		}
+
+		password, err = crypto.GeneratePassword(policy)
+	}
+	if err != nil {
+		fmt.Println(ui.Error("Failed to generate password: " + err.Error()))
+		ui.PromptContinue()
+		return
+	}
+
+	fmt.Println()
+	fmt.Printf("  %s┌─────────────────────────────────────────────────┐%s\n", ui.Cyan, ui.Reset)
+	fmt.Printf("  %s│%s  Generated Password:                            %s│%s\n", ui.Cyan, ui.Reset, ui.Cyan, ui.Reset)
+	fmt.Printf("  %s│%s  %s%-45s%s %s│%s\n", ui.Cyan, ui.Reset, ui.Green+ui.Bold, password, ui.Reset, ui.Cyan, ui.Reset)
+	fmt.Printf("  %s└─────────────────────────────────────────────────┘%s\n", ui.Cyan, ui.Reset)
+	fmt.Println()
+
+	if ui.ConfirmPrompt("Copy to clipboard?") {
+		clipboard.WriteAll(password)
+		fmt.Println(ui.Success("Password copied!"))
+	}
+
+	ui.PromptContinue()
+}
+
+// generatePassphrase asks how to build a Diceware passphrase, generates it
+// and reports its entropy.
+func generatePassphrase() (string, error) {
+	policy := crypto.DefaultPassphrasePolicy()
+
+	wordsStr, _ := ui.InputPrompt("Number of words", strconv.Itoa(policy.Words), validateNumber)
+	policy.Words, _ = strconv.Atoi(wordsStr)
+	policy.Separator, _ = ui.InputPrompt("Separator", policy.Separator, nil)
+	policy.Capitalize = ui.ConfirmPrompt("Capitalize each word?")
+	policy.AddDigit = ui.ConfirmPrompt("Add a digit?")
+
+	passphrase, err := crypto.GeneratePassphrase(policy)
+	if err != nil {
+		return "", err
+	}
+
+	fmt.Println(ui.Info(fmt.Sprintf("Entropy: %.1f bits", policy.Entropy())))
+	return passphrase, nil
+}
+
+// editPasswordPolicy lets the user change each rule of policy until they
+// pick Done with a policy that can be satisfied.
+func editPasswordPolicy(policy crypto.PasswordPolicy) crypto.PasswordPolicy {
+	onOff := func(on bool, min int) string {
+		if !on {
+			return "off"
+		}
+		return fmt.Sprintf("on (min %d)", min)
+	}
+	orNone := func(s, none string) string {
+		if s == "" {
+			return none
+		}
+		return s
+	}
+
+	for {
+		maxRepeat := "no limit"
+		if policy.MaxRepeat > 0 {
+			maxRepeat = strconv.Itoa(policy.MaxRepeat)
+		}
+
+		items := []string{
+			fmt.Sprintf("Length: %d", policy.Length),
+			"Lowercase: " + onOff(policy.Lowercase, policy.MinLowercase),
+			"Uppercase: " + onOff(policy.Uppercase, policy.MinUppercase),
+			"Digits: " + onOff(policy.Digits, policy.MinDigits),
+			"Symbols: " + onOff(policy.Symbols, policy.MinSymbols),
+			"Symbol set: " + orNone(policy.SymbolSet, crypto.DefaultSymbols),
+			fmt.Sprintf("Avoid look-alikes (0/O, l/1): %v", policy.ExcludeAmbiguous),
+			"Excluded characters: " + orNone(policy.Exclude, "none"),
+			"Max repeats in a row: " + maxRepeat,
+			"✅ Done",
+		}
+
+		idx, _, err := ui.SelectFromList("Password Policy", items)
+		if err != nil {
+			return policy
+		}
+
+		switch idx {
+		case 0:
+			val, _ := ui.InputPrompt("Password length", strconv.Itoa(policy.Length), validateNumber)
+			policy.Length, _ = strconv.Atoi(val)
+		case 1:
+			policy.Lowercase, policy.MinLowercase = promptCharClass("lowercase letters", policy.MinLowercase)
+		case 2:
+			policy.Uppercase, policy.MinUppercase = promptCharClass("uppercase letters", policy.MinUppercase)
+		case 3:
+			policy.Digits, policy.MinDigits = promptCharClass("digits", policy.MinDigits)
+		case 4:
+			policy.Symbols, policy.MinSymbols = promptCharClass("symbols", policy.MinSymbols)
+		case 5:
+			val, _ := ui.InputPrompt("Symbols to choose from", orNone(policy.SymbolSet, crypto.DefaultSymbols), validateRequired)
+			policy.SymbolSet = strings.TrimSpace(val)
+			if policy.SymbolSet == crypto.DefaultSymbols {
+				policy.SymbolSet = ""
+			}
+		case 6:
+			policy.ExcludeAmbiguous = ui.ConfirmPrompt("Avoid characters that look alike?")
+		case 7:
+			val, _ := ui.InputPrompt("Characters never to use (empty for none)", policy.Exclude, nil)
+			policy.Exclude = strings.TrimSpace(val)
+		case 8:
+			val, _ := ui.InputPrompt("Most times a character may repeat in a row (0 = no limit)", strconv.Itoa(policy.MaxRepeat), validateCount)
+			policy.MaxRepeat, _ = strconv.Atoi(val)
+		default:
+			if err := policy.Validate(); err != nil {
+				fmt.Println(ui.Error(err.Error()))
+				continue
+			}
+			return policy
+		}
+	}
+}
+
+// promptCharClass asks whether to use a character class and how many of
+// its characters every password needs.
+func promptCharClass(name string, min int) (bool, int) {
+	if !ui.ConfirmPrompt("Include " + name + "?") {
+		return false, 0
+	}
+	val, _ := ui.InputPrompt("Minimum "+name, strconv.Itoa(min), validateCount)
+	min, _ = strconv.Atoi(val)
+	return true, min
+}
+
+// handlePasswordHealth rates every stored password and lists the ones that
+// are easy to guess.
+func handlePasswordHealth(ctx context.Context) {
+	ui.ClearScreen()
+	ui.PrintSection("Password Health")
+
+	sess := session.GetSession()
+	cryptoSvc := sess.GetCrypto()
+
+	type weakPassword struct {
+		cred   models.Credential
+		result strength.Result
+	}
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Checking passwords..."
+	s.Start()
+
+	var weak []weakPassword
+	counts := make([]int, strength.ScoreVeryStrong+1)
+	unreadable := 0
+	err := sess.GetDB().EachCredential(ctx, database.Filter{}, func(cred models.Credential, loaded, total int) error {
+		setSpinnerSuffix(s, fmt.Sprintf(" Checking passwords (%d/%d)...", loaded, total))
+
+		fields, err := decryptFields(cryptoSvc, cred)
+		defer fields.destroy()
+		if err != nil {
+			unreadable++
+			return nil
+		}
+
+		result := estimateSecret(fields.Password, fields.Title, fields.Username, fields.URL)
+		counts[result.Score]++
+		if result.Score < strength.ScoreStrong {
+			cred.Title, cred.Username = fields.Title, fields.Username
+			weak = append(weak, weakPassword{cred, result})
+		}
+		return nil
+	})
+	s.Stop()
+
+	if err != nil {
+		fmt.Println(backendError("Failed to load credentials", err))
+		ui.PromptContinue()
+		return
+	}
+
+	fmt.Println()
+	for score := strength.ScoreVeryStrong; score >= strength.ScoreVeryWeak; score-- {
+		label := strength.Result{Score: score}.Label()
+		fmt.Printf("  %-12s %s%d%s\n", label+":", ui.Bold, counts[score], ui.Reset)
+	}
+	if unreadable > 0 {
+		fmt.Println(ui.Warning(fmt.Sprintf("%d credential(s) could not be decrypted and were skipped", unreadable)))
+	}
+	fmt.Println()
+
+	if len(weak) == 0 {
+		fmt.Println(ui.Success("No weak passwords found"))
+		ui.PromptContinue()
+		return
+	}
+
+	sort.SliceStable(weak, func(i, j int) bool { return weak[i].result.Score < weak[j].result.Score })
+
+	t := table.NewWriter()
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"ID", "Title", "Strength", "Problem"})
+	for _, w := range weak {
+		problem := w.result.Warning
+		if problem == "" {
+			problem = "Too short or predictable"
+		}
+		t.AppendRow(table.Row{
+			truncateStr(w.cred.ID, 15),
+			truncateStr(w.cred.Title, 25),
+			w.result.Label(),
+			problem,
+		})
+	}
+	t.SetStyle(table.StyleLight)
+	t.Render()
+
+	fmt.Printf("\n%s\n", ui.Subtle(fmt.Sprintf("%d weak password(s). Edit them from Get Credential to generate new ones.", len(weak))))
+	ui.PromptContinue()
+}
+
+func handleDeleteCredential(ctx context.Context) {
+	ui.ClearScreen()
+	ui.PrintSection("Delete Credential")
+
+	id, _ := ui.InputPrompt("Credential ID to delete", "", validateRequired)
+
+	sess := session.GetSession()
+
+	// Show credential first
+	cred, err := sess.GetDB().GetCredential(ctx, id)
+	if err != nil {
+		fmt.Println(backendError("Failed to load credential", err))
+		ui.PromptContinue()
+		return
+	}
+
+	fields, _ := decryptDetails(sess.GetCrypto(), *cred)
+
+	fmt.Printf("\n%s You are about to delete:\n", ui.Warning(""))
+	fmt.Printf("  Title: %s%s%s\n", ui.Bold, fields.Title, ui.Reset)
+	fmt.Printf("  Username: %s\n", fields.Username)
+	fmt.Println()
+
+	if !ui.ConfirmPrompt("Are you sure? This cannot be undone") {
+		fmt.Println(ui.Info("Deletion cancelled"))
+		ui.PromptContinue()
+		return
+	}
+
+	// Double confirm
+	confirm, _ := ui.InputPrompt("Type 'DELETE' to confirm", "", nil)
+	if confirm != "DELETE" {
+		fmt.Println(ui.Info("Deletion cancelled"))
+		ui.PromptContinue()
+		return
+	}
+
+	if err := sess.GetDB().DeleteCredential(ctx, id); err != nil {
+		fmt.Println(backendError("Failed to delete", err))
+	} else {
+		fmt.Println(ui.Success("Credential deleted"))
+	}
+
+	ui.PromptContinue()
+}
+
+func handleChangeMasterPassword(ctx context.Context) {
+	ui.ClearScreen()
+	ui.PrintSection("Change Master Password")
+
+	fmt.Println(ui.Subtle("Your credentials stay as they are; only the key protecting them is re-wrapped."))
+	fmt.Println()
+
+	if !ui.ConfirmPrompt("Continue?") {
+		return
+	}
+
+	sess := session.GetSession()
+
+	if rotationPending(ctx) {
+		ui.PromptContinue()
+		return
+	}
+
+	// Verify current password
+	currentPass, err := ui.SecretPrompt("Current Master Password")
+	if err != nil {
+		return
+	}
+	defer currentPass.Destroy()
+
+	vaultConfig, err := sess.GetDB().GetVaultConfig(ctx)
+	if err != nil {
+		fmt.Println(backendError("Failed to load vault configuration", err))
+		ui.PromptContinue()
+		return
+	}
+
+	cfg, _ := config.Load()
+	currentSecret, keyFile, err := unlockSecret(cfg, currentPass, vaultConfig.KeyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		ui.PromptContinue()
+		return
+	}
+	defer currentSecret.Destroy()
+
+	if vault.CheckPassword(currentSecret.Bytes(), *vaultConfig) != nil {
+		fmt.Println(ui.Error(invalidSecretMessage(vaultConfig.KeyFile)))
+		ui.PromptContinue()
+		return
+	}
+
+	// The new password should not resemble the account. The old password
+	// stays out of the estimate, which would copy it into strings; it is
+	// only checked for reuse
+	userInputs := []string{cfg.AdminEmail, cfg.PocketBaseURL}
+
+	// Get new password; the key file stays the same
+	var newPass *crypto.SecureBuffer
+	for {
+		newPass = promptNewMasterPassword("New Master Password (min 12 chars)", "Confirm New Password", userInputs...)
+		if !newPass.Equal(currentPass) {
+			break
+		}
+		fmt.Println(ui.Error("The new master password must differ from the current one"))
+		newPass.Destroy()
+	}
+	defer newPass.Destroy()
+
+	newSecret, err := vault.WithKeyFile(newPass.Bytes(), keyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		ui.PromptContinue()
+		return
+	}
+	defer newSecret.Destroy()
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Changing master password..."
+	s.Start()
+
+	updated, err := vault.ChangePassword(ctx, sess.GetDB(), *vaultConfig, sess.GetCrypto(), newSecret.Bytes())
+	s.Stop()
+	if err != nil {
+		fmt.Println(backendError("Failed to change master password", err))
+		fmt.Println(ui.Info("Master password was not changed"))
+		ui.PromptContinue()
+		return
+	}
+
+	newSalt, _ := base64.StdEncoding.DecodeString(updated.Salt)
+	sess.Login(sess.GetDB(), sess.GetCrypto(), newSalt)
+	sess.SetVaultConfig(updated)
+
+	fmt.Println(ui.Success("Master password changed successfully!"))
+	fmt.Println(ui.Warning("Remember your new password!"))
+	ui.PromptContinue()
+}
+
+func handleRotateKey(ctx context.Context) {
+	ui.ClearScreen()
+	ui.PrintSection("Rotate Vault Key")
+
+	fmt.Println(ui.Warning("This will re-encrypt all your credentials with a new vault key."))
+	fmt.Println(ui.Subtle("Your master password stays the same. Make sure you have a backup before proceeding."))
+	fmt.Println()
+
+	if !ui.ConfirmPrompt("Continue?") {
+		return
+	}
+
+	sess := session.GetSession()
+
+	// The new key has to be wrapped with the master password
+	vaultConfig, err := sess.GetDB().GetVaultConfig(ctx)
+	if err != nil {
+		fmt.Println(backendError("Failed to load vault configuration", err))
+		ui.PromptContinue()
+		return
+	}
+
+	cfg, _ := config.Load()
+	secret, _, err := promptUnlockSecret(cfg, vaultConfig.KeyFile)
+	if err != nil {
+		return
+	}
+	defer secret.Destroy()
+
+	if vault.CheckPassword(secret.Bytes(), *vaultConfig) != nil {
+		fmt.Println(ui.Error(invalidSecretMessage(vaultConfig.KeyFile)))
+		ui.PromptContinue()
+		return
+	}
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Preparing..."
+	s.Start()
+
+	// The journal is written before any record changes, so an interruption
+	// from here on can be resumed or rolled back at the next unlock
+	rekey, err := vault.RotateKey(ctx, sess.GetDB(), sess.GetCrypto(), *vaultConfig, secret.Bytes())
+	s.Stop()
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to start: %v", err)))
+		fmt.Println(ui.Info("Vault key was not changed"))
+		ui.PromptContinue()
+		return
+	}
+
+	finishRekey(ctx, rekey)
+	ui.PromptContinue()
+}
+
+// upgradeVault brings a vault from an older release up to date right after
+// it is unlocked: the vault key is wrapped with the current KDF settings,
+// then records still in an older ciphertext format are rewritten. Anything
+// that fails is tried again at the next unlock.
+func upgradeVault(ctx context.Context, vaultConfig models.VaultConfig, secret *crypto.SecureBuffer) {
+	sess := session.GetSession()
+
+	if vault.NeedsUpgrade(vaultConfig) {
+		// The key already in use becomes the data key, so no record changes
+		updated, err := vault.ChangePassword(ctx, sess.GetDB(), vaultConfig, sess.GetCrypto(), secret.Bytes())
+		if err != nil {
+			fmt.Println(ui.Warning("Could not upgrade the vault key: " + database.Describe(err)))
+			return
+		}
+
+		newSalt, _ := base64.StdEncoding.DecodeString(updated.Salt)
+		sess.Login(sess.GetDB(), sess.GetCrypto(), newSalt)
+		sess.SetVaultConfig(updated)
+		vaultConfig = updated
+		fmt.Println(ui.Info("Vault key upgraded to the current format"))
+	}
+
+	if !vault.NeedsRecordUpgrade(vaultConfig) {
+		return
+	}
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Upgrading credentials..."
+	s.Start()
+	updated, report, err := vault.UpgradeRecords(ctx, sess.GetDB(), sess.GetCrypto(), vaultConfig, rekeyProgress(s, "Upgrading credentials"))
+	s.Stop()
+	sess.SetVaultConfig(updated)
+
+	switch {
+	case err != nil:
+		fmt.Println(ui.Warning("Could not upgrade credentials: " + database.Describe(err)))
+	case len(report.Failures) > 0:
+		printRekeyFailures(report)
+	case report.Migrated > 0:
+		fmt.Println(ui.Info(fmt.Sprintf("%d credential(s) upgraded to the current format", report.Migrated)))
+	}
+}
+
+// finishRekey moves the vault to the new key and commits it, asking what to
+// do about any records that could not be moved.
+func finishRekey(ctx context.Context, rekey *vault.Rekey) {
+	defer rekey.Close()
+
+	for {
+		s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+		s.Suffix = " Re-encrypting credentials..."
+		s.Start()
+		report, err := rekey.Run(ctx, rekeyProgress(s, "Re-encrypting credentials"))
+		s.Stop()
+
+		if err != nil {
+			fmt.Println(backendError("Re-encryption interrupted", err))
+			fmt.Println(ui.Info("Vault key was not changed. Unlock the vault again to resume."))
+			return
+		}
+
+		if len(report.Failures) > 0 {
+			printRekeyFailures(report)
+
+			options := []string{
+				"🔁 Retry",
+				"↩️  Roll back and keep the current key",
+				"⚠️  Switch keys anyway (these records stay unreadable)",
+				"⏸  Decide later",
+			}
+			_, choice, _ := ui.SelectFromList("What now?", options)
+
+			switch {
+			case strings.Contains(choice, "Retry"):
+				continue
+			case strings.Contains(choice, "Roll back"):
+				rollbackRekey(ctx, rekey)
+				return
+			case !strings.Contains(choice, "anyway"):
+				fmt.Println(ui.Info("Vault key was not changed. Unlock the vault again to resume."))
+				return
+			}
+		}
+
+		if err := rekey.Commit(ctx); err != nil {
+			fmt.Println(backendError("Failed to save the new vault key", err))
+			fmt.Println(ui.Info("Unlock the vault again to resume."))
+			return
+		}
+
+		sess := session.GetSession()
+		oldCryptoSvc := sess.GetCrypto()
+		newKey, err := rekey.NewKey().Clone()
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Failed to switch to the new vault key: %v", err)))
+			fmt.Println(ui.Info("Unlock the vault again to continue."))
+			sess.Logout()
+			return
+		}
+		sess.Login(sess.GetDB(), newKey, sess.GetSalt())
+		sess.SetVaultConfig(rekey.Journal().Config)
+		oldCryptoSvc.SecureClear()
+
+		fmt.Println(ui.Success(fmt.Sprintf("Vault key rotated successfully! (%d credentials re-encrypted)", report.Migrated)))
+		return
+	}
+}
+
+func rollbackRekey(ctx context.Context, rekey *vault.Rekey) {
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Rolling back..."
+	s.Start()
+	report, err := rekey.Rollback(ctx, rekeyProgress(s, "Rolling back"))
+	s.Stop()
+
+	switch {
+	case err != nil:
+		fmt.Println(backendError("Roll back interrupted", err))
+		fmt.Println(ui.Info("Unlock the vault again to try again."))
+	case len(report.Failures) > 0:
+		printRekeyFailures(report)
+		fmt.Println(ui.Info("Unlock the vault again to try again."))
+	default:
+		fmt.Println(ui.Success("Rolled back; your vault key is unchanged"))
+	}
+}
+
+// resumePendingRekey deals with a key rotation that was interrupted, right
+// after the vault is unlocked. It reports whether one is still pending.
+func resumePendingRekey(ctx context.Context, vaultConfig models.VaultConfig) bool {
+	journal, err := vault.LoadJournal()
+	if err != nil {
+		fmt.Println(ui.Warning(fmt.Sprintf("Could not read %s: %v", vault.JournalPath(), err)))
+		return true
+	}
+	if journal == nil {
+		if vaultConfig.Rotation == "" {
+			return false
+		}
+		fmt.Println()
+		fmt.Println(ui.Warning("Another device is rotating the vault key"))
+		fmt.Println(ui.Subtle(fmt.Sprintf("  Started %s. Some credentials may not open until it is finished or rolled back there.", vaultConfig.Rotation)))
+		ui.PromptContinue()
+		return true
+	}
+
+	// Only the cleanup was missed
+	if journal.Committed(vaultConfig) {
+		vault.RemoveJournal()
+		return false
+	}
+
+	if journal.VaultID != vaultConfig.ID {
+		fmt.Println(ui.Warning(fmt.Sprintf("Ignoring %s, it belongs to a different vault", vault.JournalPath())))
+		return false
+	}
+
+	sess := session.GetSession()
+	rekey, err := vault.Resume(sess.GetDB(), sess.GetCrypto(), journal)
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Cannot resume vault key rotation: %v", err)))
+		ui.PromptContinue()
+		return true
+	}
+
+	fmt.Println()
+	fmt.Println(ui.Warning("A vault key rotation was interrupted"))
+	fmt.Println(ui.Subtle(fmt.Sprintf("  Started %s, %d credential(s) already re-encrypted", journal.Started, journal.Migrated)))
+	fmt.Println(ui.Subtle("  Some credentials may not open until it is finished or rolled back."))
+
+	options := []string{
+		"▶️  Finish rotating the key",
+		"↩️  Roll back and keep the current key",
+		"⏸  Decide later",
+	}
+	_, choice, _ := ui.SelectFromList("What now?", options)
+
+	switch {
+	case strings.Contains(choice, "Finish"):
+		finishRekey(ctx, rekey)
+	case strings.Contains(choice, "Roll back"):
+		rollbackRekey(ctx, rekey)
+		rekey.Close()
+	default:
+		rekey.Close()
+		return true
+	}
+	ui.PromptContinue()
+
+	pending, _ := vault.LoadJournal()
+	return pending != nil
+}
+
+// rotationPending reports a key rotation that keeps the vault's keys from
+// being changed now, and tells the user where to deal with it.
+func rotationPending(ctx context.Context) bool {
+	err := vault.CheckRotation(ctx, session.GetSession().GetDB())
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, vault.ErrRekeyElsewhere):
+		fmt.Println(ui.Error("Another device is rotating the vault key"))
+		fmt.Println(ui.Info("Finish or roll it back on that device first."))
+	case errors.Is(err, vault.ErrRekeyInProgress):
+		fmt.Println(ui.Error("A vault key rotation is still pending"))
+		fmt.Println(ui.Info("Lock and unlock the vault to finish or roll it back first."))
+	default:
+		fmt.Println(backendError("Failed to check for a vault key rotation", err))
+	}
+	return true
+}
+
+func printRekeyFailures(report *vault.Report) {
+	fmt.Println(ui.Warning(fmt.Sprintf("%d of %d credentials could not be re-encrypted:", len(report.Failures), report.Total)))
+	for _, failure := range report.Failures {
+		fmt.Println(ui.Subtle(fmt.Sprintf("  %s  %s (%v)", failure.ID, failure.Title, failure.Err)))
+	}
+}
+
+// printFindFailures warns about credentials a search could not decrypt,
+// which may or may not have matched.
+func printFindFailures(failures []vault.Failure) {
+	if len(failures) == 0 {
+		return
+	}
+	fmt.Println(ui.Warning(fmt.Sprintf("%d credential(s) could not be decrypted and were skipped:", len(failures))))
+	for _, failure := range failures {
+		fmt.Println(ui.Subtle(fmt.Sprintf("  %s  %s (%v)", failure.ID, failure.Title, failure.Err)))
+	}
+}
+
+func rekeyProgress(s *spinner.Spinner, label string) vault.Progress {
+	return func(done, total int) {
+		setSpinnerSuffix(s, fmt.Sprintf(" %s (%d/%d)...", label, done, total))
+	}
+}
+
+func handleSettings(ctx context.Context) {
+	ui.ClearScreen()
+	ui.PrintSection("Settings")
+
+	cfg, _ := config.Load()
+
+	for {
+		fmt.Println()
+		fmt.Printf("  %s1.%s Session Timeout: %s%d minutes%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.SessionTimeout, ui.Reset)
+		fmt.Printf("  %s2.%s Clipboard Timeout: %s%d seconds%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.ClipboardTimeout, ui.Reset)
+		fmt.Printf("  %s3.%s Default Category: %s%s%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.DefaultCategory, ui.Reset)
+		fmt.Printf("  %s4.%s Password Policy: %s%s%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.PasswordPolicy, ui.Reset)
+		fmt.Printf("  %s5.%s Network Retries: %s%d%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.MaxRetries, ui.Reset)
+		fmt.Printf("  %s6.%s Encrypt Credential Details: %s%v%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, session.GetSession().GetVaultConfig().EncryptMetadata, ui.Reset)
+		fmt.Printf("  %s7.%s Key File: %s%s%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, keyFileStatus(cfg), ui.Reset)
+		fmt.Printf("  %s8.%s Recovery Key: %s%s%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, recoveryStatus(), ui.Reset)
+		fmt.Printf("  %s9.%s Key Derivation: %s%s%s\n",
+			ui.Cyan, ui.Reset, ui.Bold, kdfStatus(), ui.Reset)
+		fmt.Printf("  %s10.%s Back to Main Menu\n", ui.Cyan, ui.Reset)
+		fmt.Println()
+
+		choice, _ := ui.InputPrompt("Select option (1-10)", "", nil)
+
+		switch choice {
+		case "1":
+			val, _ := ui.InputPrompt("Session timeout (minutes)", strconv.Itoa(cfg.Settings.SessionTimeout), validateNumber)
+			cfg.Settings.SessionTimeout, _ = strconv.Atoi(val)
+			sess := session.GetSession()
+			sess.SetTimeout(time.Duration(cfg.Settings.SessionTimeout) * time.Minute)
+		case "2":
+			val, _ := ui.InputPrompt("Clipboard timeout (seconds)", strconv.Itoa(cfg.Settings.ClipboardTimeout), validateNumber)
+			cfg.Settings.ClipboardTimeout, _ = strconv.Atoi(val)
+		case "3":
+			cfg.Settings.DefaultCategory, _ = ui.InputPrompt("Default category", cfg.Settings.DefaultCategory, nil)
+		case "4":
+			cfg.Settings.PasswordPolicy = editPasswordPolicy(cfg.Settings.PasswordPolicy)
+		case "5":
+			val, _ := ui.InputPrompt("Retries for failed server requests (applies at next unlock)", strconv.Itoa(cfg.Settings.MaxRetries), validateNumber)
+			cfg.Settings.MaxRetries, _ = strconv.Atoi(val)
+		case "6":
+			// Stored with the vault, not in the local config
+			toggleMetadataEncryption(ctx)
+			continue
+		case "7":
+			handleKeyFile(ctx, cfg)
+			continue
+		case "8":
+			handleRecoveryShares(ctx, cfg)
+			continue
+		case "9":
+			handleKDFTune(ctx, cfg)
+			continue
+		case "10":
+			cfg.Save()
+			return
+		}
+
+		cfg.Save()
+		fmt.Println(ui.Success("Setting updated"))
+	}
+}
+
+// toggleMetadataEncryption switches encryption of titles, usernames, URLs
+// and categories for the whole vault and converts the stored credentials.
+func toggleMetadataEncryption(ctx context.Context) {
+	sess := session.GetSession()
+
+	if rotationPending(ctx) {
+		return
+	}
+
+	vaultConfig, err := sess.GetDB().GetVaultConfig(ctx)
+	if err != nil {
+		fmt.Println(backendError("Failed to load vault configuration", err))
+		return
+	}
+
+	enable := !vaultConfig.EncryptMetadata
+	if enable {
+		fmt.Println(ui.Info("Titles, usernames, URLs and categories will be encrypted on the server."))
+		fmt.Println(ui.Subtle("  Search then happens on this device after downloading the vault."))
+		fmt.Println(ui.Subtle("  Every device using this vault needs this version of PassManager."))
+		if !ui.ConfirmPrompt("Encrypt credential details?") {
+			return
+		}
+	} else if !ui.ConfirmPrompt("Store credential details in the clear again?") {
+		return
+	}
+
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Converting credentials..."
+	s.Start()
+	updated, report, err := vault.SetMetadataEncryption(ctx, sess.GetDB(), sess.GetCrypto(), *vaultConfig, enable, rekeyProgress(s, "Converting credentials"))
+	s.Stop()
+	sess.SetVaultConfig(updated)
+
+	switch {
+	case err != nil:
+		fmt.Println(backendError("Conversion interrupted", err))
+		fmt.Println(ui.Info("Select this option again to finish converting."))
+	case len(report.Failures) > 0:
+		printRekeyFailures(report)
+		fmt.Println(ui.Info("Select this option again to retry them."))
+	default:
+		fmt.Println(ui.Success(fmt.Sprintf("Setting updated (%d credentials converted)", report.Migrated)))
+	}
+}
+
+func keyFileStatus(cfg *config.Config) string {
+	if !session.GetSession().GetVaultConfig().KeyFile {
+		return "not required"
+	}
+	if cfg.KeyFile == "" {
+		return "required"
+	}
+	return cfg.KeyFile
+}
+
+// handleKeyFile adds, replaces or removes the key file that is needed
+// together with the master password. Only the vault key is re-wrapped.
+func handleKeyFile(ctx context.Context, cfg *config.Config) {
 	sess := session.GetSession()
 
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Suffix = " Loading..."
-	s.Start()
-
-	cred, err := sess.GetDB().GetCredential(id)
-	s.Stop()
+	if rotationPending(ctx) {
+		return
+	}
 
+	vaultConfig, err := sess.GetDB().GetVaultConfig(ctx)
 	if err != nil {
-		fmt.Println(ui.Error("Credential not found"))
+		fmt.Println(backendError("Failed to load vault configuration", err))
 		return
 	}
 
-	cryptoSvc := sess.GetCrypto()
-	password, _ := cryptoSvc.Decrypt(cred.EncryptedPassword)
-
-	notes := ""
-	if cred.Notes != "" {
-		notes, _ = cryptoSvc.Decrypt(cred.Notes)
+	var options []string
+	if vaultConfig.KeyFile {
+		options = []string{"🔁 Replace key file", "🗑️  Remove key file", "🔙 Back"}
+	} else {
+		fmt.Println(ui.Info("With a key file, unlocking needs both the master password and the file."))
+		fmt.Println(ui.Subtle("  Every device using this vault will need a copy of it."))
+		options = []string{"➕ Add key file", "🔙 Back"}
+	}
+	_, choice, _ := ui.SelectFromList("Key file", options)
+	if strings.Contains(choice, "Back") || choice == "" {
+		return
 	}
 
-	ui.PrintCredentialCard(cred.ID, cred.Title, cred.Username, cred.URL, cred.Category, false, "")
-
-	if notes != "" {
-		fmt.Printf("\n  %sNotes:%s %s\n", ui.Dim, ui.Reset, notes)
+	password, err := ui.SecretPrompt("Master Password")
+	if err != nil {
+		return
 	}
+	defer password.Destroy()
 
-	// Actions menu
-	actions := []string{
-		"👁️  Show password",
-		"📋 Copy password to clipboard",
-		"📋 Copy username to clipboard",
-		"🔙 Go back",
+	current, _, err := unlockSecret(cfg, password, vaultConfig.KeyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
 	}
+	defer current.Destroy()
 
-	for {
-		_, action, _ := ui.SelectFromList("Action", actions)
+	if vault.CheckPassword(current.Bytes(), *vaultConfig) != nil {
+		fmt.Println(ui.Error(invalidSecretMessage(vaultConfig.KeyFile)))
+		return
+	}
 
-		switch {
-		case strings.Contains(action, "Show password"):
-			fmt.Printf("\n  %sPassword:%s %s%s%s\n", ui.Dim, ui.Reset, ui.Green, password, ui.Reset)
-		case strings.Contains(action, "Copy password"):
-			clipboard.WriteAll(password)
-			fmt.Println(ui.Success("Password copied to clipboard!"))
-		case strings.Contains(action, "Copy username"):
-			clipboard.WriteAll(cred.Username)
-			fmt.Println(ui.Success("Username copied to clipboard!"))
-		case strings.Contains(action, "Go back"):
+	newKeyFile := ""
+	if !strings.Contains(choice, "Remove") {
+		if newKeyFile = chooseKeyFile(); newKeyFile == "" {
 			return
 		}
 	}
-}
-
-func handleGeneratePassword() {
-	ui.ClearScreen()
-	ui.PrintSection("Generate Password")
-
-	cfg, _ := config.Load()
-
-	lengthStr, _ := ui.InputPrompt("Password length", strconv.Itoa(cfg.Settings.PasswordLength), validateNumber)
-	length, _ := strconv.Atoi(lengthStr)
-
-	includeSymbols := ui.ConfirmPrompt("Include symbols (!@#$%...)?")
 
-	password, err := crypto.GeneratePassword(length, includeSymbols)
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Updating vault key..."
+	s.Start()
+	updated, err := vault.SetKeyFile(ctx, sess.GetDB(), *vaultConfig, sess.GetCrypto(), password.Bytes(), newKeyFile)
+	s.Stop()
 	if err != nil {
-		fmt.Println(ui.Error("Failed to generate password"))
-		ui.PromptContinue()
+		fmt.Println(backendError("Failed to update key file", err))
+		fmt.Println(ui.Info("Key file setting was not changed"))
 		return
 	}
 
-	fmt.Println()
-	fmt.Printf("  %s┌─────────────────────────────────────────────────┐%s\n", ui.Cyan, ui.Reset)
-	fmt.Printf("  %s│%s  Generated Password:                            %s│%s\n", ui.Cyan, ui.Reset, ui.Cyan, ui.Reset)
-	fmt.Printf("  %s│%s  %s%-45s%s %s│%s\n", ui.Cyan, ui.Reset, ui.Green+ui.Bold, password, ui.Reset, ui.Cyan, ui.Reset)
-	fmt.Printf("  %s└─────────────────────────────────────────────────┘%s\n", ui.Cyan, ui.Reset)
-	fmt.Println()
+	newSalt, _ := base64.StdEncoding.DecodeString(updated.Salt)
+	sess.Login(sess.GetDB(), sess.GetCrypto(), newSalt)
+	sess.SetVaultConfig(updated)
+	cfg.KeyFile = newKeyFile
+	cfg.Save()
 
-	if ui.ConfirmPrompt("Copy to clipboard?") {
-		clipboard.WriteAll(password)
-		fmt.Println(ui.Success("Password copied!"))
+	if newKeyFile == "" {
+		fmt.Println(ui.Success("Key file removed; the master password alone unlocks the vault"))
+		return
 	}
-
-	ui.PromptContinue()
+	fmt.Println(ui.Success("Key file set: " + newKeyFile))
+	fmt.Println(ui.Warning("Keep a backup of it; the vault cannot be opened without it."))
 }
 
-func handleDeleteCredential() {
-	ui.ClearScreen()
-	ui.PrintSection("Delete Credential")
-
-	id, _ := ui.InputPrompt("Credential ID to delete", "", validateRequired)
+func kdfStatus() string {
+	kdf, err := crypto.ParseKDF(session.GetSession().GetVaultConfig().KDF)
+	if err != nil {
+		return "unknown"
+	}
+	return kdf.Summary()
+}
 
+// handleKDFTune calibrates Argon2 for this device and re-wraps the vault
+// key with the result.
+func handleKDFTune(ctx context.Context, cfg *config.Config) {
 	sess := session.GetSession()
 
-	// Show credential first
-	cred, err := sess.GetDB().GetCredential(id)
+	if rotationPending(ctx) {
+		return
+	}
+
+	vaultConfig, err := sess.GetDB().GetVaultConfig(ctx)
 	if err != nil {
-		fmt.Println(ui.Error("Credential not found"))
-		ui.PromptContinue()
+		fmt.Println(backendError("Failed to load vault configuration", err))
 		return
 	}
 
-	fmt.Printf("\n%s You are about to delete:\n", ui.Warning(""))
-	fmt.Printf("  Title: %s%s%s\n", ui.Bold, cred.Title, ui.Reset)
-	fmt.Printf("  Username: %s\n", cred.Username)
-	fmt.Println()
+	fmt.Println(ui.Info("Unlocking runs Argon2, which can be tuned to take a set time on this device."))
+	fmt.Println(ui.Subtle("  Every device using this vault runs it with the same settings, so tune on the slowest."))
+	kdf, ok := promptKDFTuning()
+	if !ok || !ui.ConfirmPrompt("Use these settings?") {
+		return
+	}
 
-	if !ui.ConfirmPrompt("Are you sure? This cannot be undone") {
-		fmt.Println(ui.Info("Deletion cancelled"))
-		ui.PromptContinue()
+	password, err := ui.SecretPrompt("Master Password")
+	if err != nil {
 		return
 	}
+	defer password.Destroy()
 
-	// Double confirm
-	confirm, _ := ui.InputPrompt("Type 'DELETE' to confirm", "", nil)
-	if confirm != "DELETE" {
-		fmt.Println(ui.Info("Deletion cancelled"))
-		ui.PromptContinue()
+	secret, _, err := unlockSecret(cfg, password, vaultConfig.KeyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
 		return
 	}
+	defer secret.Destroy()
 
-	if err := sess.GetDB().DeleteCredential(id); err != nil {
-		fmt.Println(ui.Error(fmt.Sprintf("Failed to delete: %v", err)))
-	} else {
-		fmt.Println(ui.Success("Credential deleted"))
+	if vault.CheckPassword(secret.Bytes(), *vaultConfig) != nil {
+		fmt.Println(ui.Error(invalidSecretMessage(vaultConfig.KeyFile)))
+		return
 	}
 
-	ui.PromptContinue()
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Updating vault key..."
+	s.Start()
+	updated, err := vault.SetKDF(ctx, sess.GetDB(), *vaultConfig, sess.GetCrypto(), secret.Bytes(), kdf)
+	s.Stop()
+	if err != nil {
+		fmt.Println(backendError("Failed to update key derivation", err))
+		return
+	}
+
+	newSalt, _ := base64.StdEncoding.DecodeString(updated.Salt)
+	sess.Login(sess.GetDB(), sess.GetCrypto(), newSalt)
+	sess.SetVaultConfig(updated)
+	fmt.Println(ui.Success("Key derivation updated"))
 }
 
-func handleChangeMasterPassword() {
-	ui.ClearScreen()
-	ui.PrintSection("Change Master Password")
+// promptKDFTuning asks for a target unlock time and memory limit and
+// calibrates Argon2 for them. ok is false if the user gave up.
+func promptKDFTuning() (kdf crypto.KDFParams, ok bool) {
+	val, err := ui.InputPrompt("Target unlock time (milliseconds)", strconv.Itoa(int(crypto.DefaultKDFTarget/time.Millisecond)), validateNumber)
+	if err != nil {
+		return kdf, false
+	}
+	target, _ := strconv.Atoi(val)
+	val, err = ui.InputPrompt("Memory limit (MiB)", strconv.Itoa(crypto.DefaultKDFMaxMemory/1024), validateNumber)
+	if err != nil {
+		return kdf, false
+	}
+	maxMemory, _ := strconv.Atoi(val)
 
-	fmt.Println(ui.Warning("This will re-encrypt all your credentials."))
-	fmt.Println(ui.Subtle("Make sure you have a backup before proceeding."))
-	fmt.Println()
+	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
+	s.Suffix = " Measuring this device..."
+	s.Start()
+	kdf, took, err := crypto.Calibrate(time.Duration(target)*time.Millisecond, uint32(maxMemory)*1024)
+	s.Stop()
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return kdf, false
+	}
 
-	if !ui.ConfirmPrompt("Continue?") {
-		return
+	fmt.Println(ui.Success(kdf.Summary()))
+	fmt.Println(ui.Subtle(fmt.Sprintf("  An unlock takes %s on this device.", took.Round(10*time.Millisecond))))
+	return kdf, true
+}
+
+func recoveryStatus() string {
+	if session.GetSession().GetVaultConfig().RecoveryPublicKey == "" {
+		return "not set up"
 	}
+	return "set up"
+}
 
+// handleRecoveryShares gives the vault a new recovery key split into
+// shares, or turns recovery off.
+func handleRecoveryShares(ctx context.Context, cfg *config.Config) {
 	sess := session.GetSession()
-	cfg, _ := config.Load()
 
-	// Verify current password
-	currentPass, _ := ui.PasswordPrompt("Current Master Password")
-	currentHash := crypto.HashMasterPassword(currentPass, sess.GetSalt())
+	vaultConfig, err := sess.GetDB().GetVaultConfig(ctx)
+	if err != nil {
+		fmt.Println(backendError("Failed to load vault configuration", err))
+		return
+	}
 
-	vaultConfig, _ := sess.GetDB().GetVaultConfig()
-	if currentHash != vaultConfig.PasswordHash {
-		fmt.Println(ui.Error("Invalid current password"))
-		ui.PromptContinue()
+	fmt.Println(ui.Info("A recovery key unlocks the vault if the master password or key file is lost."))
+	fmt.Println(ui.Subtle("  Keep it whole in a printed emergency kit, or split it into shares for people"))
+	fmt.Println(ui.Subtle("  or places you trust; a set number of them rebuild it."))
+	options := []string{"📄 Create emergency kit", "🧩 Create recovery shares", "🔙 Back"}
+	if vaultConfig.RecoveryPublicKey != "" {
+		fmt.Println(ui.Warning("A new kit or new shares replace the recovery key; the existing kit and shares stop working."))
+		options = []string{"📄 Create new emergency kit", "🧩 Create new recovery shares", "🚫 Turn off recovery", "🔙 Back"}
+	}
+	_, choice, _ := ui.SelectFromList("Recovery", options)
+	if strings.Contains(choice, "Back") || choice == "" {
 		return
 	}
 
-	// Get new password
-	var newPass string
-	for {
-		newPass, _ = ui.PasswordPrompt("New Master Password (min 12 chars)")
-		if len(newPass) < 12 {
-			fmt.Println(ui.Error("Password must be at least 12 characters"))
-			continue
-		}
+	// An unlocked vault left unattended should not be enough
+	if !checkMasterPassword(cfg, *vaultConfig) {
+		return
+	}
 
-		confirmPass, _ := ui.PasswordPrompt("Confirm New Password")
-		if newPass != confirmPass {
-			fmt.Println(ui.Error("Passwords don't match"))
-			continue
+	if strings.Contains(choice, "Turn off") {
+		updated, err := vault.DisableRecovery(ctx, sess.GetDB(), *vaultConfig)
+		if err != nil {
+			fmt.Println(backendError("Failed to turn off recovery", err))
+			return
 		}
-		break
+		sess.SetVaultConfig(updated)
+		fmt.Println(ui.Success("Recovery turned off; the existing kit and shares no longer work"))
+		return
 	}
 
-	s := spinner.New(spinner.CharSets[14], 100*time.Millisecond)
-	s.Suffix = " Re-encrypting all credentials..."
-	s.Start()
-
-	// Get all credentials
-	creds, _ := sess.GetDB().ListCredentials("")
-
-	// Create new crypto service
-	newSalt, _ := crypto.GenerateSalt()
-	newCryptoSvc := crypto.NewCryptoService(newPass, newSalt)
-	oldCryptoSvc := sess.GetCrypto()
+	// Saving the new key would fail at the end, after handing it out
+	if rotationPending(ctx) {
+		return
+	}
 
-	// Re-encrypt all credentials
-	for _, cred := range creds {
-		// Decrypt with old key
-		password, _ := oldCryptoSvc.Decrypt(cred.EncryptedPassword)
-		notes := ""
-		if cred.Notes != "" {
-			notes, _ = oldCryptoSvc.Decrypt(cred.Notes)
+	if strings.Contains(choice, "emergency kit") {
+		if updated, ok := setUpEmergencyKit(ctx, cfg, sess.GetDB(), *vaultConfig, sess.GetCrypto()); ok {
+			sess.SetVaultConfig(updated)
 		}
+		return
+	}
 
-		// Encrypt with new key
-		newEncPassword, _ := newCryptoSvc.Encrypt(password)
-		newEncNotes := ""
-		if notes != "" {
-			newEncNotes, _ = newCryptoSvc.Encrypt(notes)
+	val, err := ui.InputPrompt("Number of shares", "5", validateShareCount)
+	if err != nil {
+		return
+	}
+	total, _ := strconv.Atoi(val)
+	val, err = ui.InputPrompt("Shares needed to recover", strconv.Itoa(total/2+1), func(input string) error {
+		if n, err := strconv.Atoi(input); err != nil || n < 2 || n > total {
+			return fmt.Errorf("enter a number from 2 to %d", total)
 		}
-
-		// Update credential
-		cred.EncryptedPassword = newEncPassword
-		cred.Notes = newEncNotes
-		sess.GetDB().UpdateCredential(cred.ID, cred)
+		return nil
+	})
+	if err != nil {
+		return
 	}
+	threshold, _ := strconv.Atoi(val)
 
-	// Update vault config
-	newPasswordHash := crypto.HashMasterPassword(newPass, newSalt)
-	vaultConfig.Salt = base64.StdEncoding.EncodeToString(newSalt)
-	vaultConfig.PasswordHash = newPasswordHash
-	sess.GetDB().UpdateVaultConfig(vaultConfig.ID, *vaultConfig)
-
-	s.Stop()
-
-	// Update session
-	sess.Login(sess.GetDB(), newCryptoSvc, newSalt)
-	sess.SetTimeout(time.Duration(cfg.Settings.SessionTimeout) * time.Minute)
+	next, recoveryKey, err := vault.NewRecoveryKey(*vaultConfig, sess.GetCrypto())
+	if err != nil {
+		fmt.Println(ui.Error(fmt.Sprintf("Failed to generate recovery key: %v", err)))
+		return
+	}
+	defer recoveryKey.Destroy()
 
-	fmt.Println(ui.Success("Master password changed successfully!"))
-	fmt.Println(ui.Warning("Remember your new password!"))
+	shares, err := vault.SplitRecoveryKey(next, recoveryKey.Bytes(), total, threshold)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return
+	}
 
-	ui.PromptContinue()
+	// The shares only take effect once every one of them has been handed out
+	if !deliverShares(shares) {
+		fmt.Println(ui.Info("Recovery key not changed."))
+		return
+	}
+	if !enableRecovery(ctx, sess.GetDB(), next) {
+		return
+	}
+	sess.SetVaultConfig(next)
+	fmt.Println(ui.Success(fmt.Sprintf("Recovery set up: any %d of %d shares recover the vault", threshold, total)))
 }
 
-func handleSettings() {
-	ui.ClearScreen()
-	ui.PrintSection("Settings")
+// deliverShares saves the shares to files or shows them one by one, and
+// reports whether the user has them all.
+func deliverShares(shares []vault.Share) bool {
+	_, how, err := ui.SelectFromList("Hand out the shares", []string{"🖥️  Show each share on screen", "💾 Save shares to files"})
+	if err != nil {
+		return false
+	}
 
-	cfg, _ := config.Load()
+	if strings.Contains(how, "Save") {
+		dir, err := ui.InputPrompt("Folder", filepath.Join(config.GetConfigDir(), "recovery"), validateRequired)
+		if err != nil {
+			return false
+		}
+		paths, err := ui.SaveShares(dir, shares)
+		if err != nil {
+			fmt.Println(ui.Error(fmt.Sprintf("Failed to save shares: %v", err)))
+			return false
+		}
+		for _, path := range paths {
+			fmt.Println(ui.Subtle("  " + path))
+		}
+		fmt.Println(ui.Success(fmt.Sprintf("%d shares saved", len(shares))))
+		fmt.Println(ui.Warning("Print or copy them elsewhere, then delete the files from this device."))
+		return true
+	}
 
-	for {
-		fmt.Println()
-		fmt.Printf("  %s1.%s Session Timeout: %s%d minutes%s\n",
-			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.SessionTimeout, ui.Reset)
-		fmt.Printf("  %s2.%s Clipboard Timeout: %s%d seconds%s\n",
-			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.ClipboardTimeout, ui.Reset)
-		fmt.Printf("  %s3.%s Default Category: %s%s%s\n",
-			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.DefaultCategory, ui.Reset)
-		fmt.Printf("  %s4.%s Default Password Length: %s%d%s\n",
-			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.PasswordLength, ui.Reset)
-		fmt.Printf("  %s5.%s Include Symbols by Default: %s%v%s\n",
-			ui.Cyan, ui.Reset, ui.Bold, cfg.Settings.IncludeSymbols, ui.Reset)
-		fmt.Printf("  %s6.%s Back to Main Menu\n", ui.Cyan, ui.Reset)
-		fmt.Println()
+	for _, share := range shares {
+		ui.ClearScreen()
+		card, err := ui.ShareCard(share, len(shares))
+		if err != nil {
+			fmt.Println(ui.Error(err.Error()))
+			return false
+		}
+		fmt.Println(card)
+		fmt.Println(ui.Subtle("Write it down or scan it, then press Enter for the next one."))
+		ui.PromptContinue()
+	}
+	ui.ClearScreen()
+	return ui.ConfirmPrompt(fmt.Sprintf("Have all %d shares been written down or scanned?", len(shares)))
+}
 
-		choice, _ := ui.InputPrompt("Select option (1-6)", "", nil)
+// checkMasterPassword asks for the master password (and key file) again
+// before a sensitive change, and says whether it was right.
+func checkMasterPassword(cfg *config.Config, vaultConfig models.VaultConfig) bool {
+	password, err := ui.SecretPrompt("Master Password")
+	if err != nil {
+		return false
+	}
+	defer password.Destroy()
 
-		switch choice {
-		case "1":
-			val, _ := ui.InputPrompt("Session timeout (minutes)", strconv.Itoa(cfg.Settings.SessionTimeout), validateNumber)
-			cfg.Settings.SessionTimeout, _ = strconv.Atoi(val)
-			sess := session.GetSession()
-			sess.SetTimeout(time.Duration(cfg.Settings.SessionTimeout) * time.Minute)
-		case "2":
-			val, _ := ui.InputPrompt("Clipboard timeout (seconds)", strconv.Itoa(cfg.Settings.ClipboardTimeout), validateNumber)
-			cfg.Settings.ClipboardTimeout, _ = strconv.Atoi(val)
-		case "3":
-			cfg.Settings.DefaultCategory, _ = ui.InputPrompt("Default category", cfg.Settings.DefaultCategory, nil)
-		case "4":
-			val, _ := ui.InputPrompt("Default password length", strconv.Itoa(cfg.Settings.PasswordLength), validateNumber)
-			cfg.Settings.PasswordLength, _ = strconv.Atoi(val)
-		case "5":
-			cfg.Settings.IncludeSymbols = ui.ConfirmPrompt("Include symbols by default?")
-		case "6":
-			cfg.Save()
-			return
-		}
+	secret, _, err := unlockSecret(cfg, password, vaultConfig.KeyFile)
+	if err != nil {
+		fmt.Println(ui.Error(err.Error()))
+		return false
+	}
+	defer secret.Destroy()
 
-		cfg.Save()
-		fmt.Println(ui.Success("Setting updated"))
+	if vault.CheckPassword(secret.Bytes(), vaultConfig) != nil {
+		fmt.Println(ui.Error(invalidSecretMessage(vaultConfig.KeyFile)))
+		return false
 	}
+	return true
 }
 
 func handleHelp() {
@@ -835,6 +2844,42 @@ func printCredentialsTable(creds []models.Credential) {
 	t.Render()
 }
 
+// loadAllCredentials fetches every credential in the vault, showing
+// progress on the spinner as pages arrive.
+func loadAllCredentials(ctx context.Context, s *spinner.Spinner, label string) ([]models.Credential, error) {
+	sess := session.GetSession()
+
+	cryptoSvc := sess.GetCrypto()
+	minVersion := sess.GetVaultConfig().CipherVersion
+
+	var creds []models.Credential
+	err := sess.GetDB().EachCredential(ctx, database.Filter{}, func(cred models.Credential, loaded, total int) error {
+		plain, err := vault.OpenMetadata(cryptoSvc, cred, minVersion)
+		if err != nil {
+			plain = models.Credential{ID: cred.ID, Title: "⚠️  cannot decrypt"}
+		}
+		creds = append(creds, plain)
+		setSpinnerSuffix(s, fmt.Sprintf(" %s (%d/%d)...", label, loaded, total))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return creds, nil
+}
+
+// backendError formats a failed store call for display.
+func backendError(action string, err error) string {
+	return ui.Error(fmt.Sprintf("%s: %s", action, database.Describe(err)))
+}
+
+func setSpinnerSuffix(s *spinner.Spinner, suffix string) {
+	s.Lock()
+	s.Suffix = suffix
+	s.Unlock()
+}
+
 func truncateStr(s string, max int) string {
 	if len(s) > max {
 		return s[:max-3] + "..."
@@ -860,6 +2905,14 @@ func validateRequired(input string) error {
 
 // Validators (continued in main.go)
 
+func validateTOTP(input string) error {
+	if strings.TrimSpace(input) == "" {
+		return nil
+	}
+	_, err := crypto.ParseTOTP(input)
+	return err
+}
+
 func validateURL(input string) error {
 	input = strings.TrimSpace(input)
 	if input == "" {
@@ -882,6 +2935,26 @@ func validateEmail(input string) error {
 	return nil
 }
 
+// validateCount accepts zero as well as positive numbers.
+func validateCount(input string) error {
+	num, err := strconv.Atoi(strings.TrimSpace(input))
+	if err != nil {
+		return fmt.Errorf("must be a valid number")
+	}
+	if num < 0 {
+		return fmt.Errorf("cannot be negative")
+	}
+	return nil
+}
+
+func validateShareCount(input string) error {
+	n, err := strconv.Atoi(input)
+	if err != nil || n < 2 || n > 255 {
+		return fmt.Errorf("enter a number from 2 to 255")
+	}
+	return nil
+}
+
 func validateNumber(input string) error {
 	input = strings.TrimSpace(input)
 	if input == "" {
@@ -895,4 +2968,4 @@ func validateNumber(input string) error {
 		return fmt.Errorf("must be greater than 0")
 	}
 	return nil
-}
\ No newline at end of file
+}